@@ -0,0 +1,108 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+var ErrMsgPanic = errors.New("actor msg panic")
+
+// FuncMsg 函数消息,不需要回复时使用
+type FuncMsg func() error
+
+func (f FuncMsg) Proc() error {
+	return f()
+}
+
+// ProcMsg 处理消息,panic转换为错误返回,避免actor协程退出
+func ProcMsg(msg Msg) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v\n%s", ErrMsgPanic, r, debug.Stack())
+		}
+	}()
+	return msg.Proc()
+}
+
+type askReply[T any] struct {
+	value T
+	err   error
+}
+
+type askMsg[T any] struct {
+	ctx   context.Context
+	fn    func() (T, error)
+	reply chan askReply[T]
+}
+
+// Proc 调用方已放弃等待时不再执行;结果送达调用方后返回nil,错误由调用方处理
+func (msg *askMsg[T]) Proc() error {
+	return msg.run(msg.fn)
+}
+
+func (msg *askMsg[T]) run(fn func() (T, error)) error {
+	if err := msg.ctx.Err(); err != nil {
+		return fmt.Errorf("ask msg skipped: %w", err)
+	}
+	var reply askReply[T]
+	reply.err = ProcMsg(FuncMsg(func() (err error) {
+		reply.value, err = fn()
+		return err
+	}))
+	msg.reply <- reply
+	if msg.ctx.Err() != nil {
+		return reply.err
+	}
+	return nil
+}
+
+// Discard 消息未处理即被丢弃时通知调用方
+func (msg *askMsg[T]) Discard(err error) {
+	msg.reply <- askReply[T]{err: err}
+}
+
+// askWait 投递消息并等待回复,msg为askMsg或包装askMsg的消息
+func askWait[T any](ctx context.Context, m *ActorManager, roleID uint64, msg Msg, reply chan askReply[T]) (T, error) {
+	var zero T
+	if err := m.SendContext(ctx, roleID, msg); err != nil {
+		return zero, err
+	}
+	select {
+	case reply := <-reply:
+		return reply.value, reply.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+type askStateMsg[T any] struct {
+	*askMsg[T]
+	stateFn func(state *RoleState) (T, error)
+}
+
+func (msg *askStateMsg[T]) ProcState(state *RoleState) error {
+	return msg.run(func() (T, error) {
+		return msg.stateFn(state)
+	})
+}
+
+// Ask 在角色actor中执行fn并等待结果,fn的错误(含panic)返回给调用方
+// ctx结束时返回ctx.Err(),此时fn可能尚未执行或已执行完成;fn内不可再Ask同一角色
+func Ask[T any](ctx context.Context, m *ActorManager, roleID uint64, fn func() (T, error)) (T, error) {
+	msg := &askMsg[T]{ctx: ctx, fn: fn, reply: make(chan askReply[T], 1)}
+	return askWait(ctx, m, roleID, msg, msg.reply)
+}
+
+// AskState 同Ask,fn可读写角色状态,变更由actor定时写入;actor不持有状态时返回ErrStateUnavailable
+func AskState[T any](ctx context.Context, m *ActorManager, roleID uint64, fn func(state *RoleState) (T, error)) (T, error) {
+	msg := &askStateMsg[T]{
+		askMsg: &askMsg[T]{ctx: ctx, reply: make(chan askReply[T], 1), fn: func() (T, error) {
+			var zero T
+			return zero, ErrStateUnavailable
+		}},
+		stateFn: fn,
+	}
+	return askWait(ctx, m, roleID, msg, msg.reply)
+}
@@ -0,0 +1,393 @@
+package actor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"ppt/log"
+	"ppt/model"
+	"ppt/monitor"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const clusterAskRetry = 2 // 租约转移时重新定位持有节点的次数
+
+var (
+	ErrNotOwner       = errors.New("actor not owned by this node")
+	ErrUnknownMsgType = errors.New("unknown actor msg type")
+	ErrRemoteFailed   = errors.New("remote actor msg failed")
+)
+
+// RoleCluster 全局角色actor集群
+var RoleCluster *Cluster
+
+// LeaseStore 角色actor归属租约存储
+type LeaseStore interface {
+	AcquireActorLease(roleID uint64, nodeID string, ttl time.Duration) (string, error) // 无主时占有,返回当前持有节点
+	RenewActorLeases(roleIDs []uint64, nodeID string, ttl time.Duration) ([]uint64, error)
+	ReleaseActorLease(roleID uint64, nodeID string) error
+}
+
+// Transport 节点间消息转发,每个节点只读取发往自己的消息
+type Transport interface {
+	SendActorEnvelope(nodeID string, env *model.ActorEnvelope) error
+	ReadActorEnvelopes(ctx context.Context, nodeID, lastID string) ([]*model.ActorEnvelope, string, error)
+	DelActorInbox(nodeID string) error
+}
+
+// RemoteHandler 按类型注册的角色消息处理函数,在持有角色的节点的actor协程中执行,返回值以JSON回复
+type RemoteHandler func(state *RoleState, payload json.RawMessage) (any, error)
+
+// Cluster 角色actor集群,通过租约保证同一角色只在一个节点运行,其他节点的消息转发至持有节点
+// 节点宕机后租约到期,由下一个收到该角色消息的节点接管;leases为空时为单节点模式
+type Cluster struct {
+	nodeID    string
+	manager   *ActorManager
+	leases    LeaseStore
+	transport Transport
+	leaseTTL  time.Duration
+	handlers  map[string]RemoteHandler
+	errs      []error
+	mu        sync.Mutex
+	owned     map[uint64]time.Time // 本节点持有租约的角色及最近获取时间
+	pending   sync.Map             // requestID -> chan *model.ActorEnvelope
+	lastRenew atomic.Int64
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewCluster 创建角色actor集群,需注册消息处理函数后Start
+func NewCluster(nodeID string, manager *ActorManager, leases LeaseStore, transport Transport, leaseTTL time.Duration) *Cluster {
+	return &Cluster{
+		nodeID:    nodeID,
+		manager:   manager,
+		leases:    leases,
+		transport: transport,
+		leaseTTL:  leaseTTL,
+		handlers:  make(map[string]RemoteHandler),
+		errs: []error{ErrNotOwner, ErrUnknownMsgType, ErrStateUnavailable, ErrManagerClosed,
+			ErrMailboxFull, ErrMsgDropped, ErrMsgPanic, context.DeadlineExceeded},
+		owned: make(map[uint64]time.Time),
+	}
+}
+
+// InitRoleCluster 初始化全局角色actor集群,leases为空时为单节点模式
+func InitRoleCluster(nodeID string, leases LeaseStore, transport Transport, leaseTTL time.Duration) {
+	RoleCluster = NewCluster(nodeID, RoleActorManager, leases, transport, leaseTTL)
+}
+
+func (c *Cluster) NodeID() string {
+	return c.nodeID
+}
+
+// RegisterHandler 注册消息处理函数,须在Start前调用
+func (c *Cluster) RegisterHandler(msgType string, handler RemoteHandler) {
+	c.handlers[msgType] = handler
+}
+
+// RegisterErrors 注册可跨节点还原的错误,远程错误信息以其开头时可通过errors.Is判断,须在Start前调用
+func (c *Cluster) RegisterErrors(errs ...error) {
+	c.errs = append(c.errs, errs...)
+}
+
+// Start 开始接收转发消息并定期续期租约
+func (c *Cluster) Start() {
+	if c.leases == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.lastRenew.Store(time.Now().UnixNano())
+	c.wg.Add(2)
+	go c.receiveLoop(ctx)
+	go c.renewLoop(ctx)
+}
+
+// Stop 停止接收转发消息,等待本节点actor处理完剩余消息并写入状态后释放租约
+// actor未能在ctx结束前停止时不释放租约,由租约到期后转移
+func (c *Cluster) Stop(ctx context.Context) error {
+	if c.cancel != nil {
+		c.cancel()
+		c.wg.Wait()
+	}
+	err := c.manager.Shutdown(ctx)
+	if c.leases == nil || err != nil {
+		return err
+	}
+	c.mu.Lock()
+	owned := c.owned
+	c.owned = make(map[uint64]time.Time)
+	c.mu.Unlock()
+	for roleID := range owned {
+		_ = c.leases.ReleaseActorLease(roleID, c.nodeID)
+	}
+	_ = c.transport.DelActorInbox(c.nodeID)
+	log.Info("Cluster Stop success", zap.String("node_id", c.nodeID), zap.Int("released", len(owned)))
+	return nil
+}
+
+// Ask 向角色发送msgType消息并等待回复,角色由其他节点持有时转发至该节点;reply为空时忽略返回值
+func (c *Cluster) Ask(ctx context.Context, roleID uint64, msgType string, payload any, reply any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var result json.RawMessage
+	for attempt := 0; ; attempt++ {
+		var owner string
+		if owner, err = c.acquire(roleID); err != nil {
+			return err
+		}
+		if owner == c.nodeID {
+			result, err = c.askLocal(ctx, roleID, msgType, data)
+		} else {
+			result, err = c.askRemote(ctx, owner, roleID, msgType, data)
+		}
+		if !errors.Is(err, ErrNotOwner) || attempt >= clusterAskRetry {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if reply == nil || len(result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(result, reply)
+}
+
+// acquire 获取角色租约,返回持有节点
+func (c *Cluster) acquire(roleID uint64) (string, error) {
+	if c.leases == nil {
+		return c.nodeID, nil
+	}
+	owner, err := c.leases.AcquireActorLease(roleID, c.nodeID, c.leaseTTL)
+	if err != nil {
+		return "", err
+	}
+	if owner == c.nodeID {
+		c.mu.Lock()
+		c.owned[roleID] = time.Now()
+		c.mu.Unlock()
+	}
+	return owner, nil
+}
+
+func (c *Cluster) askLocal(ctx context.Context, roleID uint64, msgType string, payload json.RawMessage) (json.RawMessage, error) {
+	handler, ok := c.handlers[msgType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMsgType, msgType)
+	}
+	return AskState(ctx, c.manager, roleID, func(state *RoleState) (json.RawMessage, error) {
+		// 租约可能在消息排队期间失效并被其他节点获取,执行前再次确认
+		if !c.ownsLease(roleID) {
+			return nil, ErrNotOwner
+		}
+		result, err := handler(state, payload)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(result)
+	})
+}
+
+// ownsLease 角色租约仍由本节点持有:在owned中且最近续期或获取距今不超过TTL/2,超过后其他节点可能即将接管
+func (c *Cluster) ownsLease(roleID uint64) bool {
+	if c.leases == nil {
+		return true
+	}
+	c.mu.Lock()
+	acquiredAt, ok := c.owned[roleID]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	renewedAt := time.Unix(0, c.lastRenew.Load())
+	if acquiredAt.After(renewedAt) {
+		renewedAt = acquiredAt
+	}
+	return time.Since(renewedAt) < c.leaseTTL/2
+}
+
+func (c *Cluster) askRemote(ctx context.Context, owner string, roleID uint64, msgType string, payload json.RawMessage) (json.RawMessage, error) {
+	requestID := uuid.NewString()
+	replyChan := make(chan *model.ActorEnvelope, 1)
+	c.pending.Store(requestID, replyChan)
+	defer c.pending.Delete(requestID)
+
+	env := &model.ActorEnvelope{
+		Kind:      model.ActorEnvelopeRequest,
+		RequestID: requestID,
+		RoleID:    roleID,
+		MsgType:   msgType,
+		Payload:   payload,
+		ReplyTo:   c.nodeID,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		env.Deadline = deadline.UnixMilli()
+	}
+	if err := c.transport.SendActorEnvelope(owner, env); err != nil {
+		monitor.ActorForwardCount.WithLabelValues(msgType, "error").Inc()
+		return nil, err
+	}
+	select {
+	case reply := <-replyChan:
+		if reply.Error != "" {
+			monitor.ActorForwardCount.WithLabelValues(msgType, "error").Inc()
+			return nil, c.decodeError(reply.Error)
+		}
+		monitor.ActorForwardCount.WithLabelValues(msgType, "ok").Inc()
+		return reply.Payload, nil
+	case <-ctx.Done():
+		monitor.ActorForwardCount.WithLabelValues(msgType, "timeout").Inc()
+		log.Warn("Cluster askRemote timeout", zap.String("owner", owner), zap.Uint64("role_id", roleID), zap.String("msg_type", msgType), zap.Error(ctx.Err()))
+		return nil, ctx.Err()
+	}
+}
+
+// decodeError 还原远程错误,未注册的错误包装为ErrRemoteFailed
+func (c *Cluster) decodeError(msg string) error {
+	for _, err := range c.errs {
+		prefix := err.Error()
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+		if len(msg) == len(prefix) {
+			return err
+		}
+		return fmt.Errorf("%w%s", err, msg[len(prefix):])
+	}
+	return fmt.Errorf("%w: %s", ErrRemoteFailed, msg)
+}
+
+// receiveLoop 读取发往本节点的消息,节点ID每次启动生成,从头读取不会处理上次运行的消息
+func (c *Cluster) receiveLoop(ctx context.Context) {
+	defer c.wg.Done()
+	lastID := "0"
+	for ctx.Err() == nil {
+		envs, nextID, err := c.transport.ReadActorEnvelopes(ctx, c.nodeID, lastID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Error("Cluster ReadActorEnvelopes error", zap.String("node_id", c.nodeID), zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		lastID = nextID
+		for _, env := range envs {
+			c.dispatch(env)
+		}
+	}
+}
+
+func (c *Cluster) dispatch(env *model.ActorEnvelope) {
+	switch env.Kind {
+	case model.ActorEnvelopeReply:
+		if replyChan, ok := c.pending.Load(env.RequestID); ok {
+			select {
+			case replyChan.(chan *model.ActorEnvelope) <- env:
+			default:
+			}
+		}
+	case model.ActorEnvelopeRequest:
+		c.wg.Add(1)
+		go c.handleRequest(env)
+	default:
+		log.Warn("Cluster dispatch unknown envelope kind", zap.Any("envelope", env))
+	}
+}
+
+// handleRequest 处理其他节点转发的消息,租约已转移时回复ErrNotOwner由发送方重新定位
+func (c *Cluster) handleRequest(env *model.ActorEnvelope) {
+	defer c.wg.Done()
+	deadline := time.Now().Add(c.leaseTTL)
+	if env.Deadline > 0 {
+		deadline = time.UnixMilli(env.Deadline)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	reply := &model.ActorEnvelope{Kind: model.ActorEnvelopeReply, RequestID: env.RequestID, RoleID: env.RoleID}
+	owner, err := c.acquire(env.RoleID)
+	if err == nil && owner != c.nodeID {
+		err = ErrNotOwner
+	}
+	if err == nil {
+		reply.Payload, err = c.askLocal(ctx, env.RoleID, env.MsgType, env.Payload)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	if err = c.transport.SendActorEnvelope(env.ReplyTo, reply); err != nil {
+		log.Error("Cluster handleRequest send reply error", zap.String("reply_to", env.ReplyTo), zap.String("request_id", env.RequestID), zap.Error(err))
+	}
+}
+
+func (c *Cluster) renewLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.renewLeases()
+		}
+	}
+}
+
+// renewLeases 续期本节点持有的租约
+// 租约已被其他节点持有,或续期持续失败即将到期时异步停止本地actor,避免两个节点同时处理同一角色
+func (c *Cluster) renewLeases() {
+	now := time.Now()
+	c.mu.Lock()
+	roleIDs := make([]uint64, 0, len(c.owned))
+	for roleID, acquiredAt := range c.owned {
+		// actor已回收且租约近期未使用,不再续期,租约到期后由其他节点获取
+		if !c.manager.Has(roleID) && now.Sub(acquiredAt) > c.leaseTTL {
+			delete(c.owned, roleID)
+			continue
+		}
+		roleIDs = append(roleIDs, roleID)
+	}
+	c.mu.Unlock()
+
+	lost, err := c.leases.RenewActorLeases(roleIDs, c.nodeID, c.leaseTTL)
+	if err != nil {
+		if now.Sub(time.Unix(0, c.lastRenew.Load())) < c.leaseTTL/2 {
+			return
+		}
+		log.Error("Cluster renew leases failed, stop local actors", zap.String("node_id", c.nodeID), zap.Int("leases", len(roleIDs)), zap.Error(err))
+		lost = roleIDs
+	} else {
+		c.lastRenew.Store(now.UnixNano())
+	}
+	for _, roleID := range lost {
+		c.mu.Lock()
+		delete(c.owned, roleID)
+		c.mu.Unlock()
+		c.wg.Add(1)
+		go c.stopLostActor(roleID)
+	}
+	if len(lost) > 0 {
+		log.Warn("Cluster lost actor leases", zap.String("node_id", c.nodeID), zap.Int("lost", len(lost)))
+	}
+}
+
+// stopLostActor 异步停止已失去租约的actor,剩余消息以ErrNotOwner丢弃由发送方重新定位,不阻塞其余租约的续期
+func (c *Cluster) stopLostActor(roleID uint64) {
+	defer c.wg.Done()
+	c.manager.DiscardActor(roleID, ErrNotOwner)
+}
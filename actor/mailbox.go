@@ -0,0 +1,173 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"ppt/monitor"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MailboxPolicy 邮箱已满时的处理策略
+type MailboxPolicy string
+
+const (
+	MailboxPolicyBlock      MailboxPolicy = "block"       // 阻塞至有空位或ctx结束
+	MailboxPolicyDropOldest MailboxPolicy = "drop_oldest" // 丢弃最早的消息
+	MailboxPolicyReject     MailboxPolicy = "reject"      // 返回ErrMailboxFull
+)
+
+const DefaultMailboxCapacity = 256
+
+var (
+	ErrMailboxFull   = errors.New("actor mailbox full")
+	ErrMailboxClosed = errors.New("actor mailbox closed")
+	ErrMsgDropped    = errors.New("actor msg dropped")
+)
+
+// discardable 未处理即被丢弃时需要通知发送方的消息
+type discardable interface {
+	Discard(err error)
+}
+
+// ParseMailboxPolicy 解析邮箱策略,为空时使用block
+func ParseMailboxPolicy(policy string) (MailboxPolicy, error) {
+	switch MailboxPolicy(policy) {
+	case "":
+		return MailboxPolicyBlock, nil
+	case MailboxPolicyBlock, MailboxPolicyDropOldest, MailboxPolicyReject:
+		return MailboxPolicy(policy), nil
+	}
+	return "", fmt.Errorf("invalid mailbox policy %q", policy)
+}
+
+type envelope struct {
+	msg        Msg
+	enqueuedAt time.Time
+}
+
+// Mailbox 基于channel的有界邮箱,支持多个发送方、单个消费方
+type Mailbox struct {
+	policy   MailboxPolicy
+	ch       chan envelope
+	mu       sync.RWMutex // 投递持读锁,关闭持写锁,保证不会向已关闭的channel发送
+	closed   bool
+	discard  atomic.Pointer[error] // 非空时未处理的消息不再执行,以该错误通知发送方
+	depth    prometheus.Gauge
+	overflow prometheus.Counter
+	wait     prometheus.Observer
+	proc     prometheus.Observer
+}
+
+// NewMailbox kind为actor类型,用作指标标签;capacity<=0时使用默认容量
+func NewMailbox(kind string, capacity int, policy MailboxPolicy) *Mailbox {
+	if capacity <= 0 {
+		capacity = DefaultMailboxCapacity
+	}
+	if policy == "" {
+		policy = MailboxPolicyBlock
+	}
+	return &Mailbox{
+		policy:   policy,
+		ch:       make(chan envelope, capacity),
+		depth:    monitor.ActorMailboxDepth.WithLabelValues(kind),
+		overflow: monitor.ActorMailboxOverflowCount.WithLabelValues(kind, string(policy)),
+		wait:     monitor.ActorMsgWaitDuration.WithLabelValues(kind),
+		proc:     monitor.ActorMsgProcDuration.WithLabelValues(kind),
+	}
+}
+
+// Push 投递消息,邮箱已满时按策略处理
+func (mb *Mailbox) Push(ctx context.Context, msg Msg) error {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	if mb.closed {
+		return ErrMailboxClosed
+	}
+	env := envelope{msg: msg, enqueuedAt: time.Now()}
+	mb.depth.Inc()
+	switch mb.policy {
+	case MailboxPolicyReject:
+		select {
+		case mb.ch <- env:
+		default:
+			mb.depth.Dec()
+			mb.overflow.Inc()
+			return ErrMailboxFull
+		}
+	case MailboxPolicyDropOldest:
+		for {
+			select {
+			case mb.ch <- env:
+				return nil
+			default:
+			}
+			select {
+			case old := <-mb.ch:
+				mb.depth.Dec()
+				mb.overflow.Inc()
+				if discarded, ok := old.msg.(discardable); ok {
+					discarded.Discard(ErrMsgDropped)
+				}
+			default:
+			}
+		}
+	default:
+		select {
+		case mb.ch <- env:
+		case <-ctx.Done():
+			mb.depth.Dec()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Len 邮箱中待处理的消息数
+func (mb *Mailbox) Len() int {
+	return len(mb.ch)
+}
+
+// Close 关闭邮箱,之后的投递返回ErrMailboxClosed,已投递的消息仍会被处理
+func (mb *Mailbox) Close() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if !mb.closed {
+		mb.closed = true
+		close(mb.ch)
+	}
+}
+
+// CloseDiscard 关闭邮箱并丢弃未处理的消息,可丢弃的消息以err通知发送方
+func (mb *Mailbox) CloseDiscard(err error) {
+	mb.discard.Store(&err)
+	mb.Close()
+}
+
+// Process 依次处理消息直至邮箱关闭且消息处理完毕,tick触发时在同一协程中调用onTick,仅由actor协程调用
+func (mb *Mailbox) Process(proc func(msg Msg), tick <-chan time.Time, onTick func()) {
+	for {
+		select {
+		case env, ok := <-mb.ch:
+			if !ok {
+				return
+			}
+			mb.depth.Dec()
+			if err := mb.discard.Load(); err != nil {
+				if discarded, ok := env.msg.(discardable); ok {
+					discarded.Discard(*err)
+				}
+				continue
+			}
+			start := time.Now()
+			mb.wait.Observe(start.Sub(env.enqueuedAt).Seconds())
+			proc(env.msg)
+			mb.proc.Observe(time.Since(start).Seconds())
+		case <-tick:
+			onTick()
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"ppt/config"
+	"ppt/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultIdleTimeout = 10 * time.Minute // 角色无消息超过该时长后回收
+
+var (
+	ErrManagerClosed  = errors.New("actor manager closed")
+	ErrActorNotFound  = errors.New("actor not found")
+	ErrActorRunning   = errors.New("actor already running")
+	ErrNotSnapshotter = errors.New("actor does not support snapshot")
+)
+
+// RoleActorManager 全局角色actor管理器
+var RoleActorManager *ActorManager
+
+// ActorFactory 创建角色actor(无需Start)
+type ActorFactory func(roleID uint64) Actor
+
+// Snapshotter 支持快照迁移的actor,Snapshot在Stop后调用,Restore在Start前调用
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+type actorEntry struct {
+	actor      Actor
+	mu         sync.RWMutex // 投递持读锁,停止持写锁,保证不会向已停止的actor投递
+	stopped    atomic.Bool  // actor已完全停止(剩余消息已处理),可被新actor替换
+	lastActive atomic.Int64
+}
+
+// ActorManager 角色actor注册表,首条消息到达时创建actor,按roleID投递消息
+// 同一roleID同时只有一个运行中的actor,旧actor处理完剩余消息后新actor才会创建
+type ActorManager struct {
+	factory     ActorFactory
+	idleTimeout time.Duration
+	mu          sync.RWMutex
+	actors      map[uint64]*actorEntry
+	closed      bool
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewActorManager idleTimeout<=0时不回收空闲actor
+func NewActorManager(factory ActorFactory, idleTimeout time.Duration) *ActorManager {
+	m := &ActorManager{
+		factory:     factory,
+		idleTimeout: idleTimeout,
+		actors:      make(map[uint64]*actorEntry),
+		stopChan:    make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		m.wg.Add(1)
+		go m.passivateLoop(idleTimeout / 2)
+	}
+	return m
+}
+
+// InitRoleActorManager 初始化全局角色actor管理器,store为角色状态存储
+func InitRoleActorManager(cfg *config.ActorConfig, store StateStore) error {
+	policy, err := ParseMailboxPolicy(cfg.Mailbox.Policy)
+	if err != nil {
+		return err
+	}
+	idleTimeout := DefaultIdleTimeout
+	if cfg.IdleTimeout > 0 {
+		idleTimeout = time.Duration(cfg.IdleTimeout) * time.Second
+	}
+	roleCfg := &RoleActorConfig{
+		Capacity:      cfg.Mailbox.Capacity,
+		Policy:        policy,
+		Store:         store,
+		FlushInterval: time.Duration(cfg.FlushInterval) * time.Second,
+	}
+	RoleActorManager = NewActorManager(func(roleID uint64) Actor {
+		return NewRoleActor(roleID, roleCfg)
+	}, idleTimeout)
+	return nil
+}
+
+// Send 投递消息至角色actor,actor不存在时创建;邮箱已满时按邮箱策略处理
+func (m *ActorManager) Send(roleID uint64, msg Msg) error {
+	return m.SendContext(context.Background(), roleID, msg)
+}
+
+// SendContext 同Send,block策略下邮箱已满时阻塞至ctx结束
+func (m *ActorManager) SendContext(ctx context.Context, roleID uint64, msg Msg) error {
+	for {
+		entry, err := m.getOrSpawn(roleID)
+		if err != nil {
+			return err
+		}
+		entry.mu.RLock()
+		if entry.stopped.Load() {
+			// 恰好被回收,重新获取
+			entry.mu.RUnlock()
+			continue
+		}
+		entry.lastActive.Store(time.Now().UnixNano())
+		err = entry.actor.Push(ctx, msg)
+		entry.mu.RUnlock()
+		return err
+	}
+}
+
+// StopActor 停止角色actor(如登出),剩余消息处理完后返回;actor不存在时返回false
+func (m *ActorManager) StopActor(roleID uint64) bool {
+	m.mu.RLock()
+	entry, ok := m.actors[roleID]
+	m.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	stopped := m.stopEntry(entry, 0)
+	m.removeEntry(roleID, entry)
+	return stopped
+}
+
+// discardStopper 支持丢弃剩余消息停止的actor
+type discardStopper interface {
+	StopDiscard(err error)
+}
+
+// DiscardActor 停止角色actor并丢弃剩余消息(以err通知发送方),用于角色已转移至其他节点;actor不存在时返回false
+func (m *ActorManager) DiscardActor(roleID uint64, err error) bool {
+	m.mu.RLock()
+	entry, ok := m.actors[roleID]
+	m.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	entry.mu.Lock()
+	stopped := !entry.stopped.Load()
+	if stopped {
+		if stopper, ok := entry.actor.(discardStopper); ok {
+			stopper.StopDiscard(err)
+		} else {
+			entry.actor.Stop()
+		}
+		entry.stopped.Store(true)
+	}
+	entry.mu.Unlock()
+	m.removeEntry(roleID, entry)
+	return stopped
+}
+
+// HandoffActor 停止角色actor(剩余消息处理完毕并写入状态)并返回状态快照,用于迁移至其他节点
+func (m *ActorManager) HandoffActor(roleID uint64) ([]byte, error) {
+	m.mu.RLock()
+	entry, ok := m.actors[roleID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrActorNotFound
+	}
+	snapshotter, ok := entry.actor.(Snapshotter)
+	if !ok {
+		return nil, ErrNotSnapshotter
+	}
+	stopped := m.stopEntry(entry, 0)
+	m.removeEntry(roleID, entry)
+	if !stopped {
+		return nil, ErrActorNotFound
+	}
+	return snapshotter.Snapshot()
+}
+
+// RestoreActor 以快照创建并启动角色actor,actor已在运行时返回ErrActorRunning
+func (m *ActorManager) RestoreActor(roleID uint64, snapshot []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrManagerClosed
+	}
+	if entry, ok := m.actors[roleID]; ok && !entry.stopped.Load() {
+		return ErrActorRunning
+	}
+	actor := m.factory(roleID)
+	snapshotter, ok := actor.(Snapshotter)
+	if !ok {
+		return ErrNotSnapshotter
+	}
+	if err := snapshotter.Restore(snapshot); err != nil {
+		return err
+	}
+	m.startEntry(roleID, actor)
+	return nil
+}
+
+// Has 角色actor是否在运行
+func (m *ActorManager) Has(roleID uint64) bool {
+	m.mu.RLock()
+	entry, ok := m.actors[roleID]
+	m.mu.RUnlock()
+	return ok && !entry.stopped.Load()
+}
+
+// Len 运行中的actor数量
+func (m *ActorManager) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.actors)
+}
+
+// Shutdown 停止接收消息并等待全部actor处理完剩余消息,ctx超时时返回错误
+func (m *ActorManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	entries := make([]*actorEntry, 0, len(m.actors))
+	for _, entry := range m.actors {
+		entries = append(entries, entry)
+	}
+	m.mu.Unlock()
+
+	close(m.stopChan)
+	m.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, entry := range entries {
+			wg.Add(1)
+			go func(entry *actorEntry) {
+				defer wg.Done()
+				m.stopEntry(entry, 0)
+			}(entry)
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("ActorManager Shutdown success", zap.Int("actors", len(entries)))
+		return nil
+	case <-ctx.Done():
+		log.Error("ActorManager Shutdown timeout", zap.Int("actors", len(entries)), zap.Error(ctx.Err()))
+		return ctx.Err()
+	}
+}
+
+func (m *ActorManager) getOrSpawn(roleID uint64) (*actorEntry, error) {
+	m.mu.RLock()
+	entry, ok := m.actors[roleID]
+	closed := m.closed
+	m.mu.RUnlock()
+	if closed {
+		return nil, ErrManagerClosed
+	}
+	if ok && !entry.stopped.Load() {
+		return entry, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrManagerClosed
+	}
+	if entry, ok = m.actors[roleID]; ok && !entry.stopped.Load() {
+		return entry, nil
+	}
+	return m.startEntry(roleID, m.factory(roleID)), nil
+}
+
+// startEntry 启动actor并注册,调用方需持有m.mu写锁
+func (m *ActorManager) startEntry(roleID uint64, actor Actor) *actorEntry {
+	entry := &actorEntry{actor: actor}
+	entry.lastActive.Store(time.Now().UnixNano())
+	actor.Start()
+	m.actors[roleID] = entry
+	return entry
+}
+
+// stopEntry 停止actor,idleTimeout>0时仅在空闲超时后停止,返回是否由本次调用停止
+func (m *ActorManager) stopEntry(entry *actorEntry, idleTimeout time.Duration) bool {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.stopped.Load() {
+		return false
+	}
+	if idleTimeout > 0 && time.Since(time.Unix(0, entry.lastActive.Load())) < idleTimeout {
+		return false
+	}
+	entry.actor.Stop()
+	entry.stopped.Store(true)
+	return true
+}
+
+func (m *ActorManager) removeEntry(roleID uint64, entry *actorEntry) {
+	m.mu.Lock()
+	if m.actors[roleID] == entry {
+		delete(m.actors, roleID)
+	}
+	m.mu.Unlock()
+}
+
+// passivateLoop 定期回收空闲actor
+func (m *ActorManager) passivateLoop(interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stopChan:
+			return
+		case <-ticker.C:
+			m.passivateIdle()
+		}
+	}
+}
+
+func (m *ActorManager) passivateIdle() {
+	deadline := time.Now().Add(-m.idleTimeout).UnixNano()
+	m.mu.RLock()
+	idle := make(map[uint64]*actorEntry)
+	for roleID, entry := range m.actors {
+		if entry.lastActive.Load() <= deadline {
+			idle[roleID] = entry
+		}
+	}
+	m.mu.RUnlock()
+	for roleID, entry := range idle {
+		if m.stopEntry(entry, m.idleTimeout) {
+			m.removeEntry(roleID, entry)
+		}
+	}
+	if len(idle) > 0 {
+		log.Info("ActorManager passivate idle actors", zap.Int("idle", len(idle)), zap.Int("actors", m.Len()))
+	}
+}
@@ -1,16 +1,37 @@
 package actor
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"ppt/log"
+	"ppt/monitor"
+	"sync/atomic"
 	"time"
 )
 
+const roleActorKind = "role"
+
+var ErrActorStarted = errors.New("actor already started")
+
+// RoleActorConfig 角色actor配置,Store为空时actor不持有状态
+type RoleActorConfig struct {
+	Capacity      int
+	Policy        MailboxPolicy
+	Store         StateStore
+	FlushInterval time.Duration // <=0时使用默认间隔
+}
+
 type RoleActor struct {
-	roleID         uint64
-	msgQueue       MsgQueue
-	msgChan        chan Msg
-	closedChan     chan bool
-	procClosedChan chan bool
-	logout         bool
+	roleID        uint64
+	mailbox       *Mailbox
+	store         StateStore
+	flushInterval time.Duration
+	state         *RoleState // 仅在actor协程中访问,Start前及Stop后可由调用方访问
+	started       bool
+	done          chan struct{}
+	logout        atomic.Bool
 }
 
 func (role *RoleActor) GetRoleID() uint64 {
@@ -18,72 +39,160 @@ func (role *RoleActor) GetRoleID() uint64 {
 }
 
 func (role *RoleActor) Logout() bool {
-	return role.logout
+	return role.logout.Load()
 }
 
-func (role *RoleActor) GetMsgChan() chan Msg {
-	return role.msgChan
+func (role *RoleActor) Push(ctx context.Context, msg Msg) error {
+	return role.mailbox.Push(ctx, msg)
 }
 
+// Start 加载角色状态后开始处理消息,有状态时按间隔写入变更
 func (role *RoleActor) Start() {
-	go role.MsgReceiveLoop()
-	go role.MsgProcLoop()
+	role.started = true
+	go func() {
+		var tick <-chan time.Time
+		if role.store != nil {
+			role.loadState()
+			ticker := time.NewTicker(role.flushInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		role.mailbox.Process(role.procMsg, tick, role.flushState)
+		role.flushState()
+		role.logout.Store(true)
+		close(role.done)
+	}()
 }
 
+// Stop 关闭邮箱,等待剩余消息处理完毕并写入状态
 func (role *RoleActor) Stop() {
-	// 退出消息收发
-	close(role.msgChan)
-	<-role.closedChan
-	close(role.closedChan)
-	// 退出消息处理
-	role.procClosedChan <- true
-	close(role.procClosedChan)
+	role.mailbox.Close()
+	<-role.done
+}
+
+// StopDiscard 关闭邮箱并丢弃剩余消息(以err通知发送方),等待当前消息处理完毕并写入状态
+func (role *RoleActor) StopDiscard(err error) {
+	role.mailbox.CloseDiscard(err)
+	<-role.done
 }
 
-func (role *RoleActor) MsgReceiveLoop() {
-	for msg := range role.msgChan {
-		role.msgQueue.Enqueue(msg)
+// Snapshot 角色状态快照,须在Stop后调用;无状态时返回nil
+func (role *RoleActor) Snapshot() ([]byte, error) {
+	if role.state == nil {
+		return nil, nil
 	}
-	role.closedChan <- true
+	return role.state.Snapshot()
 }
 
-func (role *RoleActor) MsgProcLoop() {
-	ticker := time.NewTicker(time.Millisecond * 10)
-FOR:
-	for {
-		if msg := role.msgQueue.Dequeue(); msg != nil {
-			msg.Proc()
-			continue
-		}
-		select {
-		case <-role.procClosedChan:
-			break FOR
-		case <-ticker.C:
-			//TODO
-		}
+// Restore 从快照恢复角色状态,须在Start前调用
+func (role *RoleActor) Restore(data []byte) error {
+	if role.started {
+		return ErrActorStarted
+	}
+	state, err := restoreRoleState(data)
+	if err != nil {
+		return err
+	}
+	if state.RoleID != role.roleID {
+		return fmt.Errorf("snapshot role %d mismatch actor role %d", state.RoleID, role.roleID)
+	}
+	role.state = state
+	return nil
+}
+
+func (role *RoleActor) procMsg(msg Msg) {
+	var err error
+	if stateMsg, ok := msg.(StateMsg); ok {
+		err = role.procStateMsg(stateMsg)
+	} else {
+		err = ProcMsg(msg)
 	}
+	if err != nil {
+		log.Error("RoleActor proc msg error", zap.Uint64("role_id", role.roleID), zap.Error(err))
+	}
+}
 
-	//  处理剩余消息
-	if role.msgQueue.Size() > 0 {
-		for i := 0; i < role.msgQueue.Size(); i++ {
-			if msg := role.msgQueue.Dequeue(); msg != nil {
-				msg.Proc()
-			}
+func (role *RoleActor) procStateMsg(msg StateMsg) error {
+	if role.state == nil || role.state.User == nil {
+		role.loadState()
+	}
+	if role.state == nil || role.state.User == nil {
+		err := ErrStateUnavailable
+		if discarded, ok := msg.(discardable); ok {
+			discarded.Discard(err)
+			return nil
 		}
+		return err
+	}
+	return ProcMsg(FuncMsg(func() error {
+		return msg.ProcState(role.state)
+	}))
+}
+
+// loadState 加载角色状态,从快照恢复时仅加载用户基础信息;失败时在下一条状态消息到达时重试
+func (role *RoleActor) loadState() {
+	if role.store == nil {
+		return
+	}
+	user, err := role.store.LoadUser(role.roleID)
+	if err != nil {
+		log.Error("RoleActor LoadUser error", zap.Uint64("role_id", role.roleID), zap.Error(err))
+		return
+	}
+	if role.state != nil {
+		role.state.User = user
+		return
+	}
+	stored, err := role.store.LoadState(role.roleID)
+	if err != nil {
+		log.Error("RoleActor LoadState error", zap.Uint64("role_id", role.roleID), zap.Error(err))
+		return
+	}
+	if role.state, err = newRoleState(role.roleID, user, stored); err != nil {
+		log.Error("RoleActor newRoleState error", zap.Uint64("role_id", role.roleID), zap.Error(err))
 	}
-	role.logout = true
 }
 
-func NewRoleActor(roleID uint64) Actor {
-	role := &RoleActor{}
-	role.roleID = roleID
-	role.msgQueue = NewMsgQueue()
-	role.msgChan = make(chan Msg, MSG_QUEUE_SIZE)
-	role.closedChan = make(chan bool)
-	role.procClosedChan = make(chan bool)
-	return role
+// flushState 写入状态变更;版本冲突说明状态已被其他节点更新,丢弃本地变更并重新加载
+func (role *RoleActor) flushState() {
+	if role.store == nil || role.state == nil || !role.state.dirty {
+		return
+	}
+	stored, err := role.state.toModel()
+	if err == nil {
+		err = role.store.SaveState(stored)
+	}
+	switch {
+	case err == nil:
+		role.state.Version++
+		role.state.dirty = false
+		monitor.ActorStateFlushCount.WithLabelValues(roleActorKind, "ok").Inc()
+	case errors.Is(err, ErrStateConflict):
+		monitor.ActorStateFlushCount.WithLabelValues(roleActorKind, "conflict").Inc()
+		log.Error("RoleActor flush state version conflict, reload", zap.Uint64("role_id", role.roleID), zap.Int64("version", role.state.Version))
+		role.state = nil
+		role.loadState()
+	default:
+		monitor.ActorStateFlushCount.WithLabelValues(roleActorKind, "error").Inc()
+		log.Error("RoleActor flush state error", zap.Uint64("role_id", role.roleID), zap.Int64("version", role.state.Version), zap.Error(err))
+	}
+}
+
+// NewRoleActor 创建角色actor
+func NewRoleActor(roleID uint64, cfg *RoleActorConfig) Actor {
+	flushInterval := cfg.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	return &RoleActor{
+		roleID:        roleID,
+		mailbox:       NewMailbox(roleActorKind, cfg.Capacity, cfg.Policy),
+		store:         cfg.Store,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
 }
 
-func SendToMsg(actor RoleActor, msg Msg) {
-	actor.GetMsgChan() <- msg
+func SendToMsg(actor Actor, msg Msg) error {
+	return actor.Push(context.Background(), msg)
 }
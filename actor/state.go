@@ -0,0 +1,117 @@
+package actor
+
+import (
+	"encoding/json"
+	"errors"
+	"ppt/model"
+	"time"
+)
+
+const DefaultFlushInterval = 30 * time.Second // 角色状态定时写入间隔
+
+var (
+	ErrStateConflict    = errors.New("role state version conflict")
+	ErrStateUnavailable = errors.New("role state unavailable")
+)
+
+// StateStore 角色状态存储,由业务层实现
+type StateStore interface {
+	LoadUser(roleID uint64) (*model.User, error)
+	LoadState(roleID uint64) (*model.RoleState, error) // 不存在时返回nil
+	SaveState(state *model.RoleState) error            // 按版本写入,版本已被更新时返回ErrStateConflict
+}
+
+// StateMsg 需要访问角色状态的消息,由RoleActor在状态加载后调用ProcState
+type StateMsg interface {
+	Msg
+	ProcState(state *RoleState) error
+}
+
+// RoleState 角色actor持有的玩家状态,仅在actor协程中访问
+type RoleState struct {
+	RoleID  uint64
+	Version int64       // 最近一次读取或写入的持久化版本
+	User    *model.User // 用户基础信息,只读
+	data    map[string]json.RawMessage
+	dirty   bool
+}
+
+// roleSnapshot 角色状态快照,不含用户基础信息
+type roleSnapshot struct {
+	RoleID  uint64                     `json:"role_id"`
+	Version int64                      `json:"version"`
+	Data    map[string]json.RawMessage `json:"data"`
+	Dirty   bool                       `json:"dirty"`
+}
+
+func newRoleState(roleID uint64, user *model.User, stored *model.RoleState) (*RoleState, error) {
+	state := &RoleState{RoleID: roleID, User: user, data: make(map[string]json.RawMessage)}
+	if stored == nil {
+		return state, nil
+	}
+	state.Version = stored.Version
+	if len(stored.Data) > 0 {
+		if err := json.Unmarshal(stored.Data, &state.data); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// Get 读取状态字段至v,字段不存在时返回false
+func (s *RoleState) Get(key string, v any) (bool, error) {
+	raw, ok := s.data[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Set 写入状态字段并标记为待写入
+func (s *RoleState) Set(key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.data[key] = raw
+	s.dirty = true
+	return nil
+}
+
+// Delete 删除状态字段并标记为待写入
+func (s *RoleState) Delete(key string) {
+	if _, ok := s.data[key]; ok {
+		delete(s.data, key)
+		s.dirty = true
+	}
+}
+
+// Dirty 是否有未写入的变更
+func (s *RoleState) Dirty() bool {
+	return s.dirty
+}
+
+// Snapshot 序列化状态(含未写入的变更),用于actor在节点间迁移
+func (s *RoleState) Snapshot() ([]byte, error) {
+	return json.Marshal(&roleSnapshot{RoleID: s.RoleID, Version: s.Version, Data: s.data, Dirty: s.dirty})
+}
+
+// restoreRoleState 从快照恢复状态,用户基础信息需重新加载
+func restoreRoleState(data []byte) (*RoleState, error) {
+	var snapshot roleSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.Data == nil {
+		snapshot.Data = make(map[string]json.RawMessage)
+	}
+	return &RoleState{RoleID: snapshot.RoleID, Version: snapshot.Version, data: snapshot.Data, dirty: snapshot.Dirty}, nil
+}
+
+func (s *RoleState) toModel() (*model.RoleState, error) {
+	data, err := json.Marshal(s.data)
+	if err != nil {
+		return nil, err
+	}
+	return &model.RoleState{UserID: s.RoleID, Version: s.Version, Data: data}, nil
+}
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"gorm.io/gorm"
+	"ppt/dao"
+	"ppt/model"
+)
+
+var (
+	AdminCache *Cache[uint64, any]
+)
+
+type AdminCacheT struct {
+	pgSql *gorm.DB
+}
+
+// InitAdminCache 后台账号权限缓存,角色或账号变更时需删除对应缓存,其他节点在缓存过期后生效
+func InitAdminCache() error {
+	AdminCache = NewCache[uint64, any](dao.AdminCacheDefaultExpiration, dao.AdminCacheDefaultCleanUp, &AdminCacheT{
+		pgSql: dao.PgDB,
+	}, false)
+	return nil
+}
+
+func (a *AdminCacheT) Load(userID uint64) (interface{}, error) {
+	return model.GetAdminAccess(a.pgSql, userID)
+}
@@ -0,0 +1,16 @@
+package code
+
+const (
+	AwardKindItem = "item" // 道具
+	AwardKindPet  = "pet"  // 宠物
+)
+
+const (
+	AwardKeySep      = ":" // 物品奖励key格式 kind:config_id
+	AwardPetCountMax = 100 // 单个宠物奖励最大数量
+)
+
+// IsGrantAwardKind 是否为物品类奖励
+func IsGrantAwardKind(kind string) bool {
+	return kind == AwardKindItem || kind == AwardKindPet
+}
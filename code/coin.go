@@ -5,3 +5,15 @@ const (
 	CoinTypeSilver = "silver" // 银币
 	CoinTypeCopper = "copper" // 铜币
 )
+
+var CoinTypes = []string{CoinTypeGold, CoinTypeSilver, CoinTypeCopper}
+
+// IsCoinType 是否为有效货币类型
+func IsCoinType(coinType string) bool {
+	for _, v := range CoinTypes {
+		if v == coinType {
+			return true
+		}
+	}
+	return false
+}
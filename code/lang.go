@@ -0,0 +1,19 @@
+package code
+
+const (
+	LangZh      = "zh" // 中文
+	LangEn      = "en" // 英文
+	LangDefault = LangEn
+)
+
+var Langs = []string{LangZh, LangEn} // 已配置语言
+
+// IsLang 是否为已配置语言
+func IsLang(lang string) bool {
+	for _, v := range Langs {
+		if v == lang {
+			return true
+		}
+	}
+	return false
+}
@@ -8,6 +8,15 @@ const (
 	MailDefaultOperator = "system"
 )
 
+// 邮件内置占位符变量
+const (
+	MailVarUserName   = "user_name"   // 玩家名
+	MailVarVipLevel   = "vip_level"   // VIP等级
+	MailVarExpireDate = "expire_date" // 邮件过期日期
+)
+
+var MailBuiltinVars = []string{MailVarUserName, MailVarVipLevel, MailVarExpireDate}
+
 const (
 	MailTypeSystem = 100 // 系统邮件
 )
@@ -0,0 +1,18 @@
+package config
+
+type MailboxConfig struct {
+	Capacity int    `json:"capacity"` // 邮箱容量,<=0时使用默认值
+	Policy   string `json:"policy"`   // 邮箱已满时的策略:block-阻塞,drop_oldest-丢弃最早消息,reject-拒绝
+}
+
+type ActorClusterConfig struct {
+	Enabled  bool  `json:"enabled"`   // 多节点部署时开启,角色actor归属由Redis租约决定
+	LeaseTTL int64 `json:"lease_ttl"` // 租约有效期(秒),节点宕机后租约在该时长内转移,<=0时使用默认值
+}
+
+type ActorConfig struct {
+	IdleTimeout   int64              `json:"idle_timeout"`   // 角色actor空闲回收时间(秒),<=0时使用默认值
+	FlushInterval int64              `json:"flush_interval"` // 角色状态定时写入间隔(秒),<=0时使用默认值
+	Mailbox       MailboxConfig      `json:"mailbox"`
+	Cluster       ActorClusterConfig `json:"cluster"`
+}
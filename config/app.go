@@ -21,3 +21,8 @@ func InitAppConfig() {
 func GetHttpPort() string {
 	return viper.GetString("server.port")
 }
+
+// GetTrustedProxies 返回可信反向代理的IP或CIDR列表,未配置时不信任任何代理,ClientIP取连接对端地址
+func GetTrustedProxies() []string {
+	return viper.GetStringSlice("server.trusted_proxies")
+}
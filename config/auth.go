@@ -0,0 +1,50 @@
+package config
+
+// JwtKey JWT签名密钥,kid写入token头部用于轮换
+type JwtKey struct {
+	Kid    string `json:"kid"`
+	Secret string `json:"secret"`
+}
+
+// JwtConfig JWT配置
+// 新token使用ActiveKid签名,Keys中其余密钥仅用于校验轮换前签发的token
+type JwtConfig struct {
+	Issuer     string   `json:"issuer"`
+	Audience   string   `json:"audience"`
+	ActiveKid  string   `json:"active_kid"`
+	Keys       []JwtKey `json:"keys"`
+	AccessTTL  int64    `json:"access_ttl"`  // access token有效期(秒)
+	RefreshTTL int64    `json:"refresh_ttl"` // refresh token有效期(秒)
+}
+
+// TotpConfig 两步验证配置
+type TotpConfig struct {
+	Issuer     string `json:"issuer"`      // 验证器App中显示的签发方
+	EncryptKey string `json:"encrypt_key"` // TOTP密钥加密存储使用的AES密钥(base64)
+}
+
+// SmtpConfig 验证码邮件发送配置
+type SmtpConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	UserName string `json:"user_name"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	TLS      bool   `json:"tls"` // true-直接使用TLS连接(465),false-使用STARTTLS(587)
+}
+
+// RegisterGuardConfig 注册防刷配置,数量限制为0时不限制
+type RegisterGuardConfig struct {
+	IPDailyMax     int64    `json:"ip_daily_max"`     // 单IP每日最多注册数
+	DeviceDailyMax int64    `json:"device_daily_max"` // 单设备指纹每日最多注册数
+	RequireDevice  bool     `json:"require_device"`   // 是否要求提供设备指纹
+	AllowCIDRs     []string `json:"allow_cidrs"`      // 白名单网段,不受数量限制
+	DenyCIDRs      []string `json:"deny_cidrs"`       // 黑名单网段,禁止注册
+}
+
+type AuthConfig struct {
+	Jwt      JwtConfig           `json:"jwt"`
+	Totp     TotpConfig          `json:"totp"`
+	Smtp     SmtpConfig          `json:"smtp"`
+	Register RegisterGuardConfig `json:"register"`
+}
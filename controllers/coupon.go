@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/middleware"
+	"ppt/model"
+	"ppt/service"
+	"ppt/util"
+)
+
+func CouponHandler(r *gin.Engine) {
+	coupon := r.Group("/coupon")
+	{
+		coupon.Use(util.AuthMiddleware())
+		coupon.POST("/list", CouponListHandler)
+		coupon.POST("/quote", CouponQuoteHandler)
+		coupon.POST("/stack_quote", CouponStackQuoteHandler)
+		coupon.POST("/redeem", CouponRedeemHandler)
+	}
+}
+
+func CouponAdminHandler(r *gin.Engine) {
+	couponAdmin := r.Group("/admin/coupon")
+	{
+		couponAdmin.Use(middleware.AdminAuth()...)
+		couponAdmin.POST("/template/create", middleware.RequirePermission(model.AdminPermCouponManage), CouponTemplateCreateHandler)
+		couponAdmin.POST("/template/list", middleware.RequirePermission(model.AdminPermCouponManage), CouponTemplateListHandler)
+		couponAdmin.POST("/grant", middleware.RequirePermission(model.AdminPermCouponGrant), CouponGrantHandler)
+	}
+}
+
+type CouponQuoteReq struct {
+	CouponID    string `form:"coupon_id" json:"coupon_id" binding:"required,uuid"`
+	OrderAmount int64  `form:"order_amount" json:"order_amount" binding:"required"`
+}
+
+type CouponStackQuoteReq struct {
+	CouponIDs   []string `json:"coupon_ids" binding:"required,min=1,dive,uuid"`
+	OrderAmount int64    `json:"order_amount" binding:"required"`
+}
+
+type CouponRedeemReq struct {
+	CouponQuoteReq
+	OrderID string `form:"order_id" json:"order_id" binding:"required"`
+}
+
+type CouponGrantReq struct {
+	TemplateID string   `json:"template_id" binding:"required"`
+	UserIDs    []uint64 `json:"user_ids" binding:"required"`
+	SourceID   string   `json:"source_id"` // 发放来源ID,相同来源重复发放会被忽略
+}
+
+func CouponListHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var lang string
+	if user := getMailUser(userID); user != nil {
+		lang = user.Lang
+	}
+	coupons, err := service.GetUserAvailableCoupons(userID, lang)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get coupons failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"coupons": coupons})
+}
+
+func CouponQuoteHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req CouponQuoteReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	quote, err := service.QuoteCoupon(userID, uuid.MustParse(req.CouponID), req.OrderAmount)
+	if err != nil {
+		writeCouponError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"quote": quote})
+}
+
+func CouponStackQuoteHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req CouponStackQuoteReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	couponIDs := make([]uuid.UUID, 0, len(req.CouponIDs))
+	for _, couponID := range req.CouponIDs {
+		couponIDs = append(couponIDs, uuid.MustParse(couponID))
+	}
+	result, err := service.EvaluateCouponStack(userID, couponIDs, req.OrderAmount)
+	if err != nil {
+		writeCouponError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+func CouponRedeemHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req CouponRedeemReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := service.RedeemCoupon(c.Request.Context(), userID, uuid.MustParse(req.CouponID), req.OrderID, req.OrderAmount)
+	if err != nil {
+		writeCouponError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"coupon": result.Coupon,
+		"awards": buildClaimAwards(result.Ledgers),
+		"items":  result.Inventories,
+	})
+}
+
+func CouponTemplateCreateHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req service.CouponTemplateParam
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	couponTemplate, err := service.CreateCouponTemplate(&req, claims.Name)
+	if err != nil {
+		writeCouponError(c, err)
+		return
+	}
+	middleware.SetAuditTarget(c, couponTemplate.ID)
+	c.JSON(http.StatusOK, gin.H{"template": couponTemplate})
+}
+
+func CouponTemplateListHandler(c *gin.Context) {
+	var req MailListReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = MailPageSizeDefault
+	}
+	if req.Size > MailPageSizeMax {
+		req.Size = MailPageSizeMax
+	}
+	couponTemplates, total, err := service.GetCouponTemplatesByPage(req.Page, req.Size)
+	if err != nil {
+		log.Error("CouponTemplateListHandler GetCouponTemplatesByPage error", zap.Any("req", req), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get templates failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"page":      req.Page,
+		"size":      req.Size,
+		"total":     total,
+		"templates": couponTemplates,
+	})
+}
+
+func CouponGrantHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req CouponGrantReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.UserIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_ids required"})
+		return
+	}
+	middleware.SetAuditTarget(c, req.TemplateID)
+	granted, err := service.GrantCoupons(req.TemplateID, req.UserIDs, req.SourceID, claims.Name)
+	if err != nil {
+		writeCouponError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"granted": granted})
+}
+
+func writeCouponError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrCouponTemplateInvalid), errors.Is(err, service.ErrCouponOrderInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrCouponTemplateNotFound), errors.Is(err, db.ErrCouponNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrCouponUnavailable), errors.Is(err, service.ErrCouponNotApplicable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, context.DeadlineExceeded):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server busy, please retry"})
+	default:
+		log.Error("coupon operation error", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "coupon operation failed"})
+	}
+}
@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"net/http"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	logindb "ppt/login/db"
+	"ppt/model"
+	"ppt/service"
+	"ppt/util"
+	"time"
+)
+
+const (
+	MailPageSizeDefault = 20
+	MailPageSizeMax     = 100
+)
+
+func MailHandler(r *gin.Engine) {
+	mail := r.Group("/mail")
+	{
+		mail.Use(util.AuthMiddleware())
+		mail.POST("/list", MailListHandler)
+		mail.POST("/counter", MailCounterHandler)
+		mail.POST("/read", MailReadHandler)
+		mail.POST("/claim", MailClaimHandler)
+		mail.POST("/claim_all", MailClaimAllHandler)
+		mail.POST("/delete", MailDeleteHandler)
+	}
+}
+
+type MailListReq struct {
+	Page int `form:"page" json:"page"`
+	Size int `form:"size" json:"size"`
+}
+
+type MailIDReq struct {
+	ID string `form:"id" json:"id" binding:"required"`
+}
+
+// MailItem 邮件列表项(已按用户语言解析模板)
+type MailItem struct {
+	ID              string                        `json:"id"`
+	TemplateID      string                        `json:"template_id"`
+	SenderName      string                        `json:"sender_name"`
+	Title           string                        `json:"title"`
+	Content         string                        `json:"content"`
+	Awards          json.RawMessage               `json:"awards,omitempty"`
+	ReadStatus      model.MailReadStatusType      `json:"read_status"`
+	AccessoryStatus model.MailAccessoryStatusType `json:"accessory_status"`
+	ExpiredTime     int64                         `json:"expired_time"`
+	CreateTime      int64                         `json:"create_time"`
+}
+
+func MailListHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req MailListReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = MailPageSizeDefault
+	}
+	if req.Size > MailPageSizeMax {
+		req.Size = MailPageSizeMax
+	}
+
+	mailDao := db.NewUserMailDao(dao.PgDB)
+	userMails, total, err := mailDao.GetUserMailsByPage(userID, time.Now().UnixMilli(), (req.Page-1)*req.Size, req.Size)
+	if err != nil {
+		log.Error("MailListHandler GetUserMailsByPage error", zap.Uint64("user_id", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get mails failed"})
+		return
+	}
+	items, err := buildMailItems(mailDao, getMailUser(userID), userMails)
+	if err != nil {
+		log.Error("MailListHandler buildMailItems error", zap.Uint64("user_id", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get mails failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"page":  req.Page,
+		"size":  req.Size,
+		"total": total,
+		"mails": items,
+	})
+}
+
+func MailCounterHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	counter, err := service.GetUserMailCounter(userID)
+	if err != nil {
+		log.Error("MailCounterHandler GetUserMailCounter error", zap.Uint64("user_id", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get mail counter failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": counter.Unread, "unclaimed": counter.Unclaimed})
+}
+
+func MailReadHandler(c *gin.Context) {
+	userID, userMail, ok := bindUserMail(c)
+	if !ok {
+		return
+	}
+	mailDao := db.NewUserMailDao(dao.PgDB)
+	if userMail.ReadStatus != model.MailReadStatusRead {
+		if err := mailDao.ReadMail(userMail.ID); err != nil {
+			log.Error("MailReadHandler ReadMail error", zap.Uint64("user_id", userID), zap.String("mail_id", userMail.ID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "read mail failed"})
+			return
+		}
+		userMail.ReadStatus = model.MailReadStatusRead
+		service.RefreshUserMailCounter(userID)
+	}
+	items, err := buildMailItems(mailDao, getMailUser(userID), []*model.UserMail{userMail})
+	if err != nil {
+		log.Error("MailReadHandler buildMailItems error", zap.Uint64("user_id", userID), zap.String("mail_id", userMail.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "read mail failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"mail": items[0]})
+}
+
+func MailClaimHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req MailIDReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := service.ClaimMailAccessory(c.Request.Context(), userID, req.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrMailNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "mail not found"})
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server busy, please retry"})
+			return
+		}
+		log.Error("MailClaimHandler ClaimMailAccessory error", zap.Uint64("user_id", userID), zap.String("mail_id", req.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "claim mail failed"})
+		return
+	}
+	service.RefreshUserMailCounter(userID)
+	c.JSON(http.StatusOK, gin.H{"id": req.ID, "awards": buildClaimAwards(result.Ledgers), "items": result.Inventories})
+}
+
+func MailClaimAllHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	// 先补偿此前入账失败的流水
+	if err := service.ApplyUserPendingCoinLedgers(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "claim mails failed"})
+		return
+	}
+	userMails, err := db.NewUserMailDao(dao.PgDB).GetUserUnReceivedMails(userID, time.Now().UnixMilli())
+	if err != nil {
+		log.Error("MailClaimAllHandler GetUserUnReceivedMails error", zap.Uint64("user_id", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "claim mails failed"})
+		return
+	}
+	claimed := make([]string, 0, len(userMails))
+	var ledgers []*model.UserCoinLedger
+	inventories := make([]*model.UserInventory, 0)
+	for _, userMail := range userMails {
+		result, err := service.ClaimMailAccessory(c.Request.Context(), userID, userMail.ID)
+		if err != nil {
+			log.Error("MailClaimAllHandler ClaimMailAccessory error", zap.Uint64("user_id", userID), zap.String("mail_id", userMail.ID), zap.Error(err))
+			continue
+		}
+		claimed = append(claimed, userMail.ID)
+		ledgers = append(ledgers, result.Ledgers...)
+		inventories = append(inventories, result.Inventories...)
+	}
+	if len(claimed) > 0 {
+		service.RefreshUserMailCounter(userID)
+	}
+	c.JSON(http.StatusOK, gin.H{"claimed": claimed, "awards": buildClaimAwards(ledgers), "items": inventories})
+}
+
+func MailDeleteHandler(c *gin.Context) {
+	userID, userMail, ok := bindUserMail(c)
+	if !ok {
+		return
+	}
+	if len(userMail.Awards) > 0 && userMail.AccessoryStatus == model.MailAccessoryStatusUnReceive {
+		c.JSON(http.StatusConflict, gin.H{"error": "mail accessory not received"})
+		return
+	}
+	if err := db.NewUserMailDao(dao.PgDB).DeleteMail(userMail.ID); err != nil {
+		log.Error("MailDeleteHandler DeleteMail error", zap.Uint64("user_id", userID), zap.String("mail_id", userMail.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete mail failed"})
+		return
+	}
+	service.RefreshUserMailCounter(userID)
+	c.JSON(http.StatusOK, gin.H{"id": userMail.ID})
+}
+
+// bindUserMail 解析请求邮件ID并校验邮件归属(失败时已写回响应)
+func bindUserMail(c *gin.Context) (uint64, *model.UserMail, bool) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return 0, nil, false
+	}
+	var req MailIDReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, nil, false
+	}
+	userMail, err := db.NewUserMailDao(dao.PgDB).GetUserMailByID(req.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "mail not found"})
+			return 0, nil, false
+		}
+		log.Error("bindUserMail GetUserMailByID error", zap.Uint64("user_id", userID), zap.String("mail_id", req.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get mail failed"})
+		return 0, nil, false
+	}
+	// 非本人邮件与不可见邮件统一按不存在处理
+	if userMail.UserID != userID || userMail.VisibleStatus != model.MailVisibleStatusDefault || userMail.ExpiredTime <= time.Now().UnixMilli() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "mail not found"})
+		return 0, nil, false
+	}
+	return userID, userMail, true
+}
+
+// buildMailItems 按用户语言解析邮件模板并渲染占位符
+// 用户语言缺失时使用模板默认语言
+func buildMailItems(mailDao *db.UserMailDao, user *model.User, userMails []*model.UserMail) ([]*MailItem, error) {
+	var lang string
+	if user != nil {
+		lang = user.Lang
+	}
+	templateIDs := make([]string, 0, len(userMails))
+	for _, userMail := range userMails {
+		templateIDs = append(templateIDs, userMail.TemplateID)
+	}
+	templates, err := mailDao.GetMailTemplatesByIDs(templateIDs)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*MailItem, 0, len(userMails))
+	for _, userMail := range userMails {
+		item := &MailItem{
+			ID:              userMail.ID,
+			TemplateID:      userMail.TemplateID,
+			ReadStatus:      userMail.ReadStatus,
+			AccessoryStatus: userMail.AccessoryStatus,
+			ExpiredTime:     userMail.ExpiredTime,
+			CreateTime:      userMail.CreateTime,
+		}
+		if len(userMail.Awards) > 0 {
+			item.Awards = json.RawMessage(userMail.Awards)
+		}
+		if mailTemplate, ok := templates[userMail.TemplateID]; ok {
+			defaultLang := mailTemplate.DefaultLang
+			if defaultLang == "" {
+				defaultLang = code.LangDefault
+			}
+			vars := model.BuildMailVars(user, userMail)
+			item.SenderName = model.GetLangText(mailTemplate.SenderName, lang, defaultLang)
+			item.Title = model.RenderMailText(model.GetLangText(mailTemplate.Title, lang, defaultLang), vars)
+			item.Content = model.RenderMailText(model.GetLangText(mailTemplate.Content, lang, defaultLang), vars)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// getMailUser 获取邮件渲染所需的用户信息(失败时返回nil,按模板默认语言展示)
+func getMailUser(userID uint64) *model.User {
+	user, err := logindb.GetUserCache(userID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// buildClaimAwards 汇总领取的货币奖励
+func buildClaimAwards(ledgers []*model.UserCoinLedger) map[string]int64 {
+	awards := make(map[string]int64, len(ledgers))
+	for _, ledger := range ledgers {
+		awards[ledger.CoinType] += ledger.Amount
+	}
+	return awards
+}
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"net/http"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/middleware"
+	"ppt/model"
+	"ppt/service"
+	"ppt/util"
+	"time"
+)
+
+func MailAdminHandler(r *gin.Engine) {
+	mailAdmin := r.Group("/admin/mail")
+	{
+		mailAdmin.Use(middleware.AdminAuth()...)
+		mailAdmin.POST("/broadcast", middleware.RequirePermission(model.AdminPermMailSend), MailBroadcastHandler)
+		mailAdmin.POST("/broadcast/progress", middleware.RequirePermission(model.AdminPermMailRead), MailBroadcastProgressHandler)
+		mailAdmin.POST("/broadcast/schedule", middleware.RequirePermission(model.AdminPermMailSend), MailBroadcastScheduleHandler)
+		mailAdmin.POST("/broadcast/cancel", middleware.RequirePermission(model.AdminPermMailSend), MailBroadcastCancelHandler)
+		mailAdmin.POST("/broadcast/revoke", middleware.RequirePermission(model.AdminPermMailSend), MailBroadcastRevokeHandler)
+		mailAdmin.POST("/archive", middleware.RequirePermission(model.AdminPermMailArchive), MailArchiveHandler)
+	}
+}
+
+type MailBroadcastReq struct {
+	TemplateID string              `json:"template_id" binding:"required"`
+	Audience   *model.MailAudience `json:"audience" binding:"required"`
+	model.MailSendParams
+}
+
+type MailBroadcastScheduleReq struct {
+	MailBroadcastReq
+	SendTime int64 `json:"send_time" binding:"required"` // 发送时间(毫秒)
+}
+
+func MailBroadcastHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req MailBroadcastReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, req.TemplateID)
+	broadcast, err := service.CreateMailBroadcast(req.TemplateID, req.Audience, &req.MailSendParams, claims.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrMailAudienceInvalid), errors.Is(err, service.ErrMailParamsInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrMailTemplateNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Error("MailBroadcastHandler CreateMailBroadcast error", zap.Any("req", req), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "create broadcast failed"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"broadcast": broadcast})
+}
+
+func MailBroadcastProgressHandler(c *gin.Context) {
+	var req MailIDReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	broadcast, err := service.GetMailBroadcast(req.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "broadcast not found"})
+			return
+		}
+		log.Error("MailBroadcastProgressHandler GetMailBroadcast error", zap.String("broadcast_id", req.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get broadcast failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"broadcast": broadcast})
+}
+
+func MailBroadcastScheduleHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req MailBroadcastScheduleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, req.TemplateID)
+	broadcast, err := service.ScheduleMailBroadcast(req.TemplateID, req.Audience, &req.MailSendParams, time.UnixMilli(req.SendTime), claims.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrMailAudienceInvalid), errors.Is(err, service.ErrMailScheduleTimeInvalid), errors.Is(err, service.ErrMailParamsInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrMailTemplateNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Error("MailBroadcastScheduleHandler ScheduleMailBroadcast error", zap.Any("req", req), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "schedule broadcast failed"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"broadcast": broadcast})
+}
+
+func MailBroadcastCancelHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req MailIDReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, req.ID)
+	if err := service.CancelMailBroadcast(req.ID, claims.Name); err != nil {
+		writeMailBroadcastError(c, "MailBroadcastCancelHandler", req.ID, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": req.ID})
+}
+
+func MailBroadcastRevokeHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req MailIDReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, req.ID)
+	revoked, err := service.RevokeMailBroadcast(req.ID, claims.Name)
+	if err != nil {
+		writeMailBroadcastError(c, "MailBroadcastRevokeHandler", req.ID, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": req.ID, "revoked": revoked})
+}
+
+type MailArchiveReq struct {
+	UserID     uint64 `form:"user_id" json:"user_id" binding:"required"`
+	TemplateID string `form:"template_id" json:"template_id"`
+}
+
+// MailArchiveHandler 查询用户已清理邮件的归档记录
+func MailArchiveHandler(c *gin.Context) {
+	var req MailArchiveReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if dao.CKSqlSession == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "archive unavailable"})
+		return
+	}
+	archives, err := db.GetUserMailArchives(dao.CKSqlSession, req.UserID, req.TemplateID, MailPageSizeMax)
+	if err != nil {
+		log.Error("MailArchiveHandler GetUserMailArchives error", zap.Any("req", req), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get archives failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"archives": archives})
+}
+
+func writeMailBroadcastError(c *gin.Context, handler, broadcastID string, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "broadcast not found"})
+	case errors.Is(err, service.ErrMailBroadcastStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Error(handler+" error", zap.String("broadcast_id", broadcastID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "update broadcast failed"})
+	}
+}
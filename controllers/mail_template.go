@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"ppt/log"
+	"ppt/middleware"
+	"ppt/model"
+	"ppt/service"
+)
+
+func MailTemplateAdminHandler(r *gin.Engine) {
+	mailTemplate := r.Group("/admin/mail/template")
+	{
+		mailTemplate.Use(middleware.AdminAuth()...)
+		mailTemplate.Use(middleware.RequirePermission(model.AdminPermMailTemplate))
+		mailTemplate.POST("/create", MailTemplateCreateHandler)
+		mailTemplate.POST("/update", MailTemplateUpdateHandler)
+		mailTemplate.POST("/list", MailTemplateListHandler)
+		mailTemplate.POST("/preview", MailTemplatePreviewHandler)
+		mailTemplate.POST("/delete", MailTemplateDeleteHandler)
+	}
+}
+
+type MailTemplateUpdateReq struct {
+	ID string `json:"id" binding:"required"`
+	service.MailTemplateParam
+}
+
+type MailTemplateListReq struct {
+	MailListReq
+	WithDeleted bool `form:"with_deleted" json:"with_deleted"`
+}
+
+type MailTemplatePreviewReq struct {
+	ID   string            `form:"id" json:"id" binding:"required"`
+	Lang string            `form:"lang" json:"lang"` // 为空时使用模板默认语言
+	Vars map[string]string `json:"vars"`             // 占位符示例值
+}
+
+func MailTemplateCreateHandler(c *gin.Context) {
+	var req service.MailTemplateParam
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	mailTemplate, err := service.CreateMailTemplate(&req)
+	if err != nil {
+		writeMailTemplateError(c, err)
+		return
+	}
+	middleware.SetAuditTarget(c, mailTemplate.ID)
+	c.JSON(http.StatusOK, gin.H{"template": mailTemplate})
+}
+
+func MailTemplateUpdateHandler(c *gin.Context) {
+	var req MailTemplateUpdateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, req.ID)
+	mailTemplate, err := service.UpdateMailTemplate(req.ID, &req.MailTemplateParam)
+	if err != nil {
+		writeMailTemplateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"template": mailTemplate})
+}
+
+func MailTemplateListHandler(c *gin.Context) {
+	var req MailTemplateListReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = MailPageSizeDefault
+	}
+	if req.Size > MailPageSizeMax {
+		req.Size = MailPageSizeMax
+	}
+	mailTemplates, total, err := service.GetMailTemplatesByPage(req.Page, req.Size, req.WithDeleted)
+	if err != nil {
+		log.Error("MailTemplateListHandler GetMailTemplatesByPage error", zap.Any("req", req), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "get templates failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"page":      req.Page,
+		"size":      req.Size,
+		"total":     total,
+		"templates": mailTemplates,
+	})
+}
+
+func MailTemplatePreviewHandler(c *gin.Context) {
+	var req MailTemplatePreviewReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	preview, err := service.PreviewMailTemplate(req.ID, req.Lang, req.Vars)
+	if err != nil {
+		writeMailTemplateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"preview": preview})
+}
+
+func MailTemplateDeleteHandler(c *gin.Context) {
+	var req MailIDReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, req.ID)
+	if err := service.DeleteMailTemplate(req.ID); err != nil {
+		writeMailTemplateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": req.ID})
+}
+
+func writeMailTemplateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrMailTemplateInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMailTemplateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMailTemplateDelivered):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Error("mail template admin error", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "mail template operation failed"})
+	}
+}
@@ -0,0 +1,11 @@
+package controllers
+
+import "github.com/gin-gonic/gin"
+
+func RegModelHandler(r *gin.Engine) {
+	MailHandler(r)
+	MailAdminHandler(r)
+	MailTemplateAdminHandler(r)
+	CouponHandler(r)
+	CouponAdminHandler(r)
+}
@@ -21,11 +21,44 @@ const (
 	MongoCollIPReg               = "ip_reg" // IP注冊表
 	UserMailExpiredKey           = "ppt:user:mail_expired"
 	UserMailExpiredKeyExpire     = 6 * time.Hour
-	UserMailExpiredDeleteDays    = 7                  // 过期删除天数
-	UserMailExpiredMaxDeleteDays = 15                 // 最大过期删除天数
-	UserIDKey                    = "ppt:user:user_id" // 用户UserID key
-	UserIDMin                    = 100000000          // 最小UserID
-	UserIDMax                    = 999999999          // 最大UserID
+	UserMailExpiredDeleteDays    = 7                          // 过期删除天数
+	UserMailExpiredMaxDeleteDays = 15                         // 最大过期删除天数
+	UserMailCounterKey           = "ppt:user:mail_counter:%d" // 用户邮件红点计数
+	UserMailExpireNoticeKey      = "ppt:user:mail_expire_at"  // 待通知邮件过期的用户(score为计数邮件中最早过期时间)
+	UserDynamicNoticeChannel     = "ppt:user:dynamic_notice"  // 用户动态通知频道
+	UserIDKey                    = "ppt:user:user_id"         // 用户UserID key
+	UserIDMin                    = 100000000                  // 最小UserID
+	UserIDMax                    = 999999999                  // 最大UserID
+	CouponExpiredKey             = "ppt:coupon:expired"       // 优惠券过期任务锁
+	CouponExpiredKeyExpire       = 50 * time.Minute
+	CouponExpireCursorKey        = "ppt:coupon:expire_cursor" // 优惠券过期任务游标
+	CouponExpireCursorExpire     = 24 * time.Hour
+	CouponExpireDirectMax        = 10000 // 过期数量不超过该值时直接更新,否则使用游标分批
+	CouponExpireBatchSize        = 5000
+	CouponEventRelayBatch        = 1000  // 每批发送的优惠券事件数
+	FunctionIDMin                = 10000 // 功能ID起始值
+	FunctionIDStep               = 1000  // 功能ID号段长度
+
+	RefreshTokenKey  = "ppt:user:refresh_token:%s"  // refresh token(以哈希为key)
+	RefreshFamilyKey = "ppt:user:refresh_family:%s" // refresh token轮换链当前有效token哈希
+	TokenRevokedKey  = "ppt:user:token_revoked:%s"  // 已吊销access token(以jti为key,保留至token过期)
+	MfaChallengeKey  = "ppt:user:mfa_challenge:%s"  // 登录两步验证挑战,值为UserID
+	TotpFailKey      = "ppt:user:totp_fail:%d"      // 两步验证失败次数
+
+	UserRefreshKey   = "ppt:user:refresh_families:%d"   // 用户全部refresh token轮换链(即登录会话)
+	VerifyCodeKey    = "ppt:user:verify_code:%s:%s"     // 邮箱验证码(用途:邮箱),hash:code_hash/attempts
+	VerifyCodeCdKey  = "ppt:user:verify_code_cd:%s:%s"  // 验证码重发冷却
+	VerifyEmailLimit = "ppt:user:verify_limit:email:%s" // 单邮箱验证码发送次数
+	VerifyIPLimit    = "ppt:user:verify_limit:ip:%s"    // 单IP验证码发送次数
+
+	UserSessionKey     = "ppt:user:session:%s"      // 登录会话(以轮换链FamilyID为会话ID)
+	UserSessionSeenKey = "ppt:user:session_seen:%s" // 会话最近活跃时间,与会话分开存储避免会话注销后被重新写入
+
+	RegisterDeviceKey = "ppt:user:reg_device:%s:%s" // 设备指纹每日注册数(日期:指纹哈希)
+	RegisterIPKey     = "ppt:user:reg_ip:%s:%s"     // IP每日注册数(日期:IP)
+
+	ActorLeaseKey = "ppt:actor:lease:%d" // 角色actor归属租约,值为持有节点ID
+	ActorInboxKey = "ppt:actor:inbox:%s" // 节点消息Stream,接收转发的角色消息及回复
 )
 
 var (
@@ -34,4 +67,34 @@ var (
 	UserCacheDefaultExpiration = time.Minute * 30
 	UserCacheDefaultCleanUp    = time.Minute * 30
 	UserMailExpiredDeleteBatch = 20000
+	UserCreditLedgerIDsMax     = 200 // 用户货币文档保留最近入账流水ID数
+	MailBroadcastBatchSize     = 1000
+	MailRevokeBatchSize        = 5000
+	MailBroadcastStaleDuration = time.Minute * 5 // 群发任务超过该时长未推进视为中断
+	UserMailCounterExpiration  = time.Hour       // 邮件红点计数最长缓存时间
+	UserMailExpireNoticeBatch  = int64(1000)     // 每批通知邮件过期的用户数
+	MfaChallengeExpiration     = 5 * time.Minute // 登录两步验证挑战有效期
+	TotpFailWindow             = 15 * time.Minute
+	TotpFailMax                = int64(5) // 窗口内最多失败次数,达到后锁定至窗口结束
+	VerifyCodeExpiration       = 10 * time.Minute
+	VerifyCodeCooldown         = time.Minute
+	VerifyCodeMaxAttempts      = int64(5) // 单个验证码最多校验次数,超过后作废
+	VerifyCodeLimitWindow      = time.Hour
+	VerifyCodeEmailLimit       = int64(10) // 窗口内单邮箱最多发送次数
+	VerifyCodeIPLimit          = int64(30) // 窗口内单IP最多发送次数
+
+	AdminCacheDefaultExpiration = time.Minute // 后台权限变更在其他节点的最长生效延迟
+	AdminCacheDefaultCleanUp    = time.Minute * 5
+	AdminAuditPayloadMax        = 64 << 10 // 审计日志记录的请求内容上限
+
+	UserSessionSeenInterval = time.Minute // 会话最近活跃时间的最小更新间隔
+	UserSessionDeviceMaxLen = 255
+
+	RegisterCountExpiration = 48 * time.Hour // 每日注册数保留时长
+
+	RoleActorAskTimeout = 5 * time.Second  // 角色actor内执行玩家状态变更的最长等待时间
+	ActorLeaseTTL       = 15 * time.Second // 节点宕机后租约最长在该时长后转移
+	ActorInboxMaxLen    = int64(10000)
+	ActorInboxReadCount = int64(100)
+	ActorInboxReadBlock = 2 * time.Second
 )
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/log"
+	"ppt/model"
+	"time"
+)
+
+// 无主时占有租约;持有者为本节点时续期;返回当前持有节点
+var acquireActorLeaseScript = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if not owner then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return ARGV[1]
+end
+if owner == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return owner
+`)
+
+// 持有者为本节点时续期,返回1;否则返回0
+var renewActorLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// 持有者为本节点时释放
+var releaseActorLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// ActorLeaseRedis 角色actor归属租约,同一角色同时只有一个节点持有
+// 与UserLockRedis不同,校验持有者与续期/释放在同一脚本中完成,不存在竞态窗口
+type ActorLeaseRedis struct {
+	client redis.UniversalClient
+}
+
+func NewActorLeaseRedis(client redis.UniversalClient) *ActorLeaseRedis {
+	return &ActorLeaseRedis{client: client}
+}
+
+// AcquireActorLease 获取租约,返回当前持有节点ID
+func (a *ActorLeaseRedis) AcquireActorLease(roleID uint64, nodeID string, ttl time.Duration) (string, error) {
+	key := fmt.Sprintf(dao.ActorLeaseKey, roleID)
+	owner, err := acquireActorLeaseScript.Run(dao.Ctx, a.client, []string{key}, nodeID, ttl.Milliseconds()).Text()
+	if err != nil {
+		log.Error("AcquireActorLease redis script error", zap.Uint64("role_id", roleID), zap.String("node_id", nodeID), zap.Error(err))
+		return "", err
+	}
+	return owner, nil
+}
+
+// RenewActorLeases 批量续期本节点持有的租约,返回已不再持有的角色
+func (a *ActorLeaseRedis) RenewActorLeases(roleIDs []uint64, nodeID string, ttl time.Duration) ([]uint64, error) {
+	if len(roleIDs) == 0 {
+		return nil, nil
+	}
+	pipe := a.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(roleIDs))
+	for i, roleID := range roleIDs {
+		cmds[i] = renewActorLeaseScript.Eval(dao.Ctx, pipe, []string{fmt.Sprintf(dao.ActorLeaseKey, roleID)}, nodeID, ttl.Milliseconds())
+	}
+	if _, err := pipe.Exec(dao.Ctx); err != nil {
+		log.Error("RenewActorLeases redis pipe exec error", zap.String("node_id", nodeID), zap.Int("leases", len(roleIDs)), zap.Error(err))
+		return nil, err
+	}
+	var lost []uint64
+	for i, cmd := range cmds {
+		if renewed, _ := cmd.Int64(); renewed == 0 {
+			lost = append(lost, roleIDs[i])
+		}
+	}
+	return lost, nil
+}
+
+// ReleaseActorLease 释放本节点持有的租约
+func (a *ActorLeaseRedis) ReleaseActorLease(roleID uint64, nodeID string) error {
+	key := fmt.Sprintf(dao.ActorLeaseKey, roleID)
+	if err := releaseActorLeaseScript.Run(dao.Ctx, a.client, []string{key}, nodeID).Err(); err != nil {
+		log.Error("ReleaseActorLease redis script error", zap.Uint64("role_id", roleID), zap.String("node_id", nodeID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ActorStreamRedis 节点间角色消息转发,每个节点读取自己的Stream
+type ActorStreamRedis struct {
+	client redis.UniversalClient
+}
+
+func NewActorStreamRedis(client redis.UniversalClient) *ActorStreamRedis {
+	return &ActorStreamRedis{client: client}
+}
+
+// SendActorEnvelope 发送消息至节点Stream
+func (s *ActorStreamRedis) SendActorEnvelope(nodeID string, env *model.ActorEnvelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Error("SendActorEnvelope json marshal error", zap.Any("envelope", env), zap.Error(err))
+		return err
+	}
+	err = s.client.XAdd(dao.Ctx, &redis.XAddArgs{
+		Stream: fmt.Sprintf(dao.ActorInboxKey, nodeID),
+		Values: map[string]interface{}{"data": data},
+		MaxLen: dao.ActorInboxMaxLen,
+		Approx: true,
+	}).Err()
+	if err != nil {
+		log.Error("SendActorEnvelope redis XAdd error", zap.String("node_id", nodeID), zap.String("request_id", env.RequestID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ReadActorEnvelopes 阻塞读取节点Stream中lastID之后的消息,返回新的lastID;lastID为$时从最新位置开始
+func (s *ActorStreamRedis) ReadActorEnvelopes(ctx context.Context, nodeID, lastID string) ([]*model.ActorEnvelope, string, error) {
+	streams, err := s.client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{fmt.Sprintf(dao.ActorInboxKey, nodeID), lastID},
+		Count:   dao.ActorInboxReadCount,
+		Block:   dao.ActorInboxReadBlock,
+	}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, lastID, nil
+		}
+		return nil, lastID, err
+	}
+	var envs []*model.ActorEnvelope
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			lastID = message.ID
+			data, _ := message.Values["data"].(string)
+			env := &model.ActorEnvelope{}
+			if err = json.Unmarshal([]byte(data), env); err != nil {
+				log.Error("ReadActorEnvelopes json unmarshal error", zap.String("node_id", nodeID), zap.String("message_id", message.ID), zap.Error(err))
+				continue
+			}
+			envs = append(envs, env)
+		}
+	}
+	return envs, lastID, nil
+}
+
+// DelActorInbox 删除节点Stream,节点退出时调用
+func (s *ActorStreamRedis) DelActorInbox(nodeID string) error {
+	return s.client.Del(dao.Ctx, fmt.Sprintf(dao.ActorInboxKey, nodeID)).Err()
+}
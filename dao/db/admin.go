@@ -0,0 +1,102 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ppt/model"
+	"time"
+)
+
+type AdminDao struct {
+	db *gorm.DB
+}
+
+func NewAdminDao(db *gorm.DB) *AdminDao {
+	return &AdminDao{db: db}
+}
+
+// SaveAdminRole 创建或更新角色
+func (a *AdminDao) SaveAdminRole(role *model.AdminRole) error {
+	return a.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"permissions": role.Permissions,
+			"description": role.Description,
+			"updated_at":  time.Now().UnixMilli(),
+		}),
+	}).Create(role).Error
+}
+
+// DeleteAdminRole 删除角色,返回是否存在
+func (a *AdminDao) DeleteAdminRole(name string) (bool, error) {
+	result := a.db.Where("name = ?", name).Delete(&model.AdminRole{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetAdminRoles 获取全部角色
+func (a *AdminDao) GetAdminRoles() ([]*model.AdminRole, error) {
+	var roles []*model.AdminRole
+	if err := a.db.Order("name asc").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetAdminRolesByNames 按名称获取角色
+func (a *AdminDao) GetAdminRolesByNames(names []string) ([]*model.AdminRole, error) {
+	var roles []*model.AdminRole
+	if err := a.db.Where("name in ?", names).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SaveAdminPrincipal 创建或更新后台账号
+func (a *AdminDao) SaveAdminPrincipal(principal *model.AdminPrincipal) error {
+	return a.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"roles":      principal.Roles,
+			"status":     principal.Status,
+			"operator":   principal.Operator,
+			"updated_at": time.Now().UnixMilli(),
+		}),
+	}).Create(principal).Error
+}
+
+// GetAdminPrincipals 获取全部后台账号
+func (a *AdminDao) GetAdminPrincipals() ([]*model.AdminPrincipal, error) {
+	var principals []*model.AdminPrincipal
+	if err := a.db.Order("user_id asc").Find(&principals).Error; err != nil {
+		return nil, err
+	}
+	return principals, nil
+}
+
+// CreateAdminAuditLog 写入审计日志
+func (a *AdminDao) CreateAdminAuditLog(auditLog *model.AdminAuditLog) error {
+	return a.db.Create(auditLog).Error
+}
+
+// GetAdminAuditLogsByPage 分页获取审计日志(按时间倒序),operatorID为0或target为空时不过滤
+func (a *AdminDao) GetAdminAuditLogsByPage(operatorID uint64, target string, offset, limit int) ([]*model.AdminAuditLog, int64, error) {
+	var total int64
+	var auditLogs []*model.AdminAuditLog
+	query := a.db.Model(&model.AdminAuditLog{})
+	if operatorID > 0 {
+		query = query.Where("operator_id = ?", operatorID)
+	}
+	if target != "" {
+		query = query.Where("target = ?", target)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return auditLogs, 0, nil
+	}
+	if err := query.Order("id desc").Offset(offset).Limit(limit).Find(&auditLogs).Error; err != nil {
+		return nil, 0, err
+	}
+	return auditLogs, total, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ppt/model"
+)
+
+// UpdateCoinLedgerApplied 标记货币流水已入账
+func UpdateCoinLedgerApplied(db *gorm.DB, id string) error {
+	return db.Model(&model.UserCoinLedger{}).Where("id = ? and status = ?", id, model.CoinLedgerStatusPending).
+		Update("status", model.CoinLedgerStatusApplied).Error
+}
+
+// ApplyCoinLedgerByTx 锁定流水行,未入账时调用apply入账并在同一事务中标记已入账
+// 流水行的入账状态是幂等依据,并发或重放的入账在行锁释放后读到已入账状态直接跳过;返回是否由本次调用入账
+func ApplyCoinLedgerByTx(db *gorm.DB, id string, apply func(ledger *model.UserCoinLedger) error) (bool, error) {
+	applied := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ledger := &model.UserCoinLedger{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(ledger).Error; err != nil {
+			return err
+		}
+		if ledger.Status == model.CoinLedgerStatusApplied {
+			return nil
+		}
+		if err := apply(ledger); err != nil {
+			return err
+		}
+		if err := UpdateCoinLedgerApplied(tx, id); err != nil {
+			return err
+		}
+		applied = true
+		return nil
+	})
+	return applied, err
+}
+
+// GetCoinLedgersBySource 获取指定来源写入的货币流水
+func GetCoinLedgersBySource(db *gorm.DB, sourceType model.CoinLedgerSourceType, sourceID string) ([]*model.UserCoinLedger, error) {
+	var ledgers []*model.UserCoinLedger
+	if err := db.Where("source_type = ? and source_id = ?", sourceType, sourceID).Order("coin_type").Find(&ledgers).Error; err != nil {
+		return nil, err
+	}
+	return ledgers, nil
+}
+
+// GetUserPendingCoinLedgers 获取用户待入账流水
+func GetUserPendingCoinLedgers(db *gorm.DB, userID uint64) ([]*model.UserCoinLedger, error) {
+	var ledgers []*model.UserCoinLedger
+	if err := db.Where("user_id = ? and status = ?", userID, model.CoinLedgerStatusPending).Order("create_time").Find(&ledgers).Error; err != nil {
+		return nil, err
+	}
+	return ledgers, nil
+}
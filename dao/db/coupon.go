@@ -0,0 +1,183 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ppt/model"
+	"time"
+)
+
+var (
+	ErrCouponNotFound    = errors.New("coupon not found")
+	ErrCouponUnavailable = errors.New("coupon unavailable")
+)
+
+type CouponDao struct {
+	db *gorm.DB
+}
+
+func NewCouponDao(db *gorm.DB) *CouponDao {
+	return &CouponDao{db: db}
+}
+
+// CreateCouponTemplate 创建优惠券模板
+func (c *CouponDao) CreateCouponTemplate(couponTemplate *model.CouponTemplate) error {
+	return c.db.Create(couponTemplate).Error
+}
+
+// GetCouponTemplateByID 获取优惠券模板
+func (c *CouponDao) GetCouponTemplateByID(id string) (*model.CouponTemplate, error) {
+	couponTemplate := &model.CouponTemplate{}
+	if err := c.db.First(couponTemplate, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return couponTemplate, nil
+}
+
+// GetCouponTemplatesByIDs 批量获取优惠券模板
+func (c *CouponDao) GetCouponTemplatesByIDs(ids []string) (map[string]*model.CouponTemplate, error) {
+	templates := make(map[string]*model.CouponTemplate, len(ids))
+	if len(ids) == 0 {
+		return templates, nil
+	}
+	var couponTemplates []*model.CouponTemplate
+	if err := c.db.Where("id in ?", ids).Find(&couponTemplates).Error; err != nil {
+		return nil, err
+	}
+	for _, couponTemplate := range couponTemplates {
+		templates[couponTemplate.ID] = couponTemplate
+	}
+	return templates, nil
+}
+
+// GetCouponTemplatesByPage 分页获取可用优惠券模板(按创建时间倒序)
+func (c *CouponDao) GetCouponTemplatesByPage(offset, limit int) ([]*model.CouponTemplate, int64, error) {
+	var total int64
+	var couponTemplates []*model.CouponTemplate
+	query := c.db.Model(&model.CouponTemplate{}).Where("status = ?", model.CouponTemplateStatusInUse)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return couponTemplates, 0, nil
+	}
+	if err := query.Order("created_at desc").Offset(offset).Limit(limit).Find(&couponTemplates).Error; err != nil {
+		return nil, 0, err
+	}
+	return couponTemplates, total, nil
+}
+
+// GrantUserCoupons 批量发放优惠券(同一用户同一模板同一来源只发放一次),返回实际发放数
+func (c *CouponDao) GrantUserCoupons(userCoupons []*model.UserCoupon) (int64, error) {
+	result := c.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "user_id"},
+			{Name: "template_id"},
+			{Name: "source_id"},
+		},
+		DoNothing: true,
+	}).CreateInBatches(&userCoupons, 1000)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// GetUserAvailableCoupons 获取用户可用优惠券(按过期时间升序)
+func (c *CouponDao) GetUserAvailableCoupons(userID uint64, now int64) ([]*model.UserCoupon, error) {
+	var userCoupons []*model.UserCoupon
+	if err := c.db.Where("user_id = ? and coupon_status = ? and expired_at > ?", userID, model.CouponStatusAvailable, now).
+		Order("expired_at asc").Find(&userCoupons).Error; err != nil {
+		return nil, err
+	}
+	return userCoupons, nil
+}
+
+// GetUserCoupon 获取用户指定优惠券
+func (c *CouponDao) GetUserCoupon(userID uint64, couponID uuid.UUID) (*model.UserCoupon, error) {
+	userCoupon := &model.UserCoupon{}
+	if err := c.db.Where("user_id = ? and coupon_id = ?", userID, couponID).First(userCoupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+	return userCoupon, nil
+}
+
+// GetUserCouponsByIDs 批量获取用户优惠券
+func (c *CouponDao) GetUserCouponsByIDs(userID uint64, couponIDs []uuid.UUID) ([]*model.UserCoupon, error) {
+	var userCoupons []*model.UserCoupon
+	if len(couponIDs) == 0 {
+		return userCoupons, nil
+	}
+	if err := c.db.Where("user_id = ? and coupon_id in ?", userID, couponIDs).Find(&userCoupons).Error; err != nil {
+		return nil, err
+	}
+	return userCoupons, nil
+}
+
+// RedeemUserCouponByTx 行锁下核销优惠券,状态由可用变更为成功使用
+// apply在同一事务中校验并发放优惠内容,返回需更新的核销字段;同一订单重复核销直接返回核销结果
+func (c *CouponDao) RedeemUserCouponByTx(userID uint64, couponID uuid.UUID, orderID string,
+	apply func(tx *gorm.DB, userCoupon *model.UserCoupon) (map[string]interface{}, error)) (*model.UserCoupon, error) {
+	userCoupon := &model.UserCoupon{}
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? and coupon_id = ?", userID, couponID).First(userCoupon).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCouponNotFound
+			}
+			return err
+		}
+		if userCoupon.CouponStatus != model.CouponStatusAvailable {
+			if userCoupon.CouponStatus == model.CouponStatusUsedSuccess && userCoupon.OrderID == orderID {
+				return nil
+			}
+			return ErrCouponUnavailable
+		}
+		updates, err := apply(tx, userCoupon)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UnixMilli()
+		updates["coupon_status"] = model.CouponStatusUsedSuccess
+		updates["order_id"] = orderID
+		updates["used_at"] = now
+		updates["updated_at"] = now
+		return tx.Model(&model.UserCoupon{}).Where("user_id = ? and coupon_id = ?", userID, couponID).Updates(updates).Error
+	}, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+	return c.GetUserCoupon(userID, couponID)
+}
+
+// UpdateUserCouponUsedFailed 核销失败时行锁下将可用优惠券标记为使用失败
+func (c *CouponDao) UpdateUserCouponUsedFailed(userID uint64, couponID uuid.UUID, orderID, reason string) (bool, error) {
+	var updated bool
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		userCoupon := &model.UserCoupon{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? and coupon_id = ?", userID, couponID).First(userCoupon).Error; err != nil {
+			return err
+		}
+		if userCoupon.CouponStatus != model.CouponStatusAvailable {
+			return nil
+		}
+		now := time.Now().UnixMilli()
+		if err := tx.Model(&model.UserCoupon{}).Where("user_id = ? and coupon_id = ?", userID, couponID).Updates(map[string]interface{}{
+			"coupon_status": model.CouponStatusUsedFailed,
+			"order_id":      orderID,
+			"fail_reason":   reason,
+			"used_at":       now,
+			"updated_at":    now,
+		}).Error; err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	return updated, err
+}
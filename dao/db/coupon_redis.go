@@ -0,0 +1,52 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/log"
+	"ppt/model"
+	"time"
+)
+
+func GetCouponExpiredLock(client redis.UniversalClient, key string, expireTime time.Duration) (bool, error) {
+	return client.SetNX(dao.Ctx, key, time.Now().UnixMilli(), expireTime).Result()
+}
+
+// GetCouponExpireCursor 获取未完成的过期任务游标(不存在时返回nil)
+func GetCouponExpireCursor(client redis.UniversalClient) (*model.CouponExpireCursor, error) {
+	data, err := client.Get(dao.Ctx, dao.CouponExpireCursorKey).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		log.Error("GetCouponExpireCursor redis Get error", zap.Error(err))
+		return nil, err
+	}
+	cursor := &model.CouponExpireCursor{}
+	if err = json.Unmarshal(data, cursor); err != nil {
+		log.Error("GetCouponExpireCursor json unmarshal error", zap.ByteString("cursor", data), zap.Error(err))
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// SetCouponExpireCursor 保存过期任务游标
+func SetCouponExpireCursor(client redis.UniversalClient, cursor *model.CouponExpireCursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	if err = client.Set(dao.Ctx, dao.CouponExpireCursorKey, data, dao.CouponExpireCursorExpire).Err(); err != nil {
+		log.Error("SetCouponExpireCursor redis Set error", zap.Any("cursor", cursor), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DelCouponExpireCursor 过期任务完成后清除游标
+func DelCouponExpireCursor(client redis.UniversalClient) error {
+	return client.Del(dao.Ctx, dao.CouponExpireCursorKey).Err()
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"ppt/code"
+	"ppt/model"
+)
+
+// GetInventoriesBySource 获取指定来源发放的物品实例
+func GetInventoriesBySource(db *gorm.DB, sourceType model.CoinLedgerSourceType, sourceID string) ([]*model.UserInventory, error) {
+	var inventories []*model.UserInventory
+	if err := db.Where("source_type = ? and source_id = ?", sourceType, sourceID).Order("kind, config_id, seq").Find(&inventories).Error; err != nil {
+		return nil, err
+	}
+	return inventories, nil
+}
+
+// GetUserInventories 获取用户物品实例
+func GetUserInventories(db *gorm.DB, userID uint64) ([]*model.UserInventory, error) {
+	var inventories []*model.UserInventory
+	if err := db.Where("user_id = ?", userID).Order("create_time").Find(&inventories).Error; err != nil {
+		return nil, err
+	}
+	return inventories, nil
+}
+
+// CreateAwardsByTx 按奖励JSON写入货币流水并发放物品实例(需在事务中调用)
+// 道具按数量堆叠为一个实例,宠物每只一个实例;mintID用于生成物品实例ID
+func CreateAwardsByTx(tx *gorm.DB, userID uint64, sourceType model.CoinLedgerSourceType, sourceID string, awardsData datatypes.JSON,
+	mintID func(kind string) (int64, error)) ([]*model.UserCoinLedger, []*model.UserInventory, error) {
+	awards, err := model.ParseMailAwards(awardsData)
+	if err != nil {
+		return nil, nil, err
+	}
+	grants, err := model.ParseMailGrants(awardsData)
+	if err != nil {
+		return nil, nil, err
+	}
+	ledgers := make([]*model.UserCoinLedger, 0, len(awards))
+	for _, award := range awards {
+		ledgers = append(ledgers, &model.UserCoinLedger{
+			UserID:     userID,
+			SourceType: sourceType,
+			SourceID:   sourceID,
+			CoinType:   award.CoinType,
+			Amount:     award.Amount,
+			Status:     model.CoinLedgerStatusPending,
+		})
+	}
+	inventories := make([]*model.UserInventory, 0, len(grants))
+	for _, grant := range grants {
+		count, instances := grant.Count, int64(1)
+		if grant.Kind == code.AwardKindPet {
+			count, instances = 1, grant.Count
+		}
+		for seq := int64(0); seq < instances; seq++ {
+			instanceID, err := mintID(grant.Kind)
+			if err != nil {
+				return nil, nil, err
+			}
+			inventories = append(inventories, &model.UserInventory{
+				InstanceID: instanceID,
+				UserID:     userID,
+				Kind:       grant.Kind,
+				ConfigID:   grant.ConfigID,
+				Count:      count,
+				SourceType: sourceType,
+				SourceID:   sourceID,
+				Seq:        int32(seq),
+			})
+		}
+	}
+	if len(ledgers) > 0 {
+		if err = tx.Create(&ledgers).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(inventories) > 0 {
+		if err = tx.Create(&inventories).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	return ledgers, inventories, nil
+}
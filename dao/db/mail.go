@@ -2,15 +2,19 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"ppt/code"
 	"ppt/dao"
 	"ppt/log"
 	model2 "ppt/model"
 	"time"
 )
 
+var ErrMailNotFound = errors.New("mail not found")
+
 type UserMailDao struct {
 	db *gorm.DB
 }
@@ -44,6 +48,59 @@ func (m *UserMailDao) ReceiveMailAccessory(id string) error {
 	return nil
 }
 
+// ClaimMailAccessoryByTx 事务领取邮件附件,写入货币流水并发放物品实例
+// 仅附件未领取时更新状态并发放,重复领取返回首次领取生成的流水及物品;mintID用于生成物品实例ID
+func (m *UserMailDao) ClaimMailAccessoryByTx(userID uint64, id string, now int64, mintID func(kind string) (int64, error)) ([]*model2.UserCoinLedger, []*model2.UserInventory, error) {
+	var ledgers []*model2.UserCoinLedger
+	var inventories []*model2.UserInventory
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		userMail := &model2.UserMail{}
+		if err := tx.Where("id = ? and user_id = ?", id, userID).First(userMail).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrMailNotFound
+			}
+			return err
+		}
+		result := tx.Model(&model2.UserMail{}).
+			Where("id = ? and user_id = ? and accessory_status = ? and visible_status = ? and expired_time > ?",
+				id, userID, model2.MailAccessoryStatusUnReceive, model2.MailVisibleStatusDefault, now).
+			Updates(map[string]interface{}{
+				"read_status":      model2.MailReadStatusRead,
+				"accessory_status": model2.MailAccessoryStatusReceived,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 并发领取时行锁释放后重新读取最新状态
+			if err := tx.Where("id = ?", id).First(userMail).Error; err != nil {
+				return err
+			}
+			if userMail.AccessoryStatus != model2.MailAccessoryStatusReceived {
+				// 邮件不可见或已过期
+				return ErrMailNotFound
+			}
+			var err error
+			if ledgers, err = GetCoinLedgersBySource(tx, model2.CoinLedgerSourceMail, id); err != nil {
+				return err
+			}
+			inventories, err = GetInventoriesBySource(tx, model2.CoinLedgerSourceMail, id)
+			return err
+		}
+
+		var err error
+		ledgers, inventories, err = CreateAwardsByTx(tx, userID, model2.CoinLedgerSourceMail, id, userMail.Awards, mintID)
+		if err != nil {
+			log.Error("ClaimMailAccessoryByTx CreateAwardsByTx error", zap.String("mail_id", id), zap.ByteString("awards", userMail.Awards), zap.Error(err))
+		}
+		return err
+	}, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ledgers, inventories, nil
+}
+
 // GetUserMails 获取用户所有可见邮件(未删除/未撤销/未过期)
 func (m *UserMailDao) GetUserMails(userID uint64) ([]*model2.UserMail, error) {
 	var userMails []*model2.UserMail
@@ -53,15 +110,57 @@ func (m *UserMailDao) GetUserMails(userID uint64) ([]*model2.UserMail, error) {
 	return userMails, nil
 }
 
+// GetUserMailsByPage 分页获取用户可见邮件(按创建时间倒序)
+func (m *UserMailDao) GetUserMailsByPage(userID uint64, now int64, offset, limit int) ([]*model2.UserMail, int64, error) {
+	var total int64
+	var userMails []*model2.UserMail
+	query := m.db.Model(&model2.UserMail{}).Where("user_id = ? and visible_status = ? and expired_time > ?", userID, model2.MailVisibleStatusDefault, now)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return userMails, 0, nil
+	}
+	if err := query.Order("create_time desc").Offset(offset).Limit(limit).Find(&userMails).Error; err != nil {
+		return nil, 0, err
+	}
+	return userMails, total, nil
+}
+
+// GetUserUnReceivedMails 获取用户附件未领取的可见邮件
+func (m *UserMailDao) GetUserUnReceivedMails(userID uint64, now int64) ([]*model2.UserMail, error) {
+	var userMails []*model2.UserMail
+	if err := m.db.Where("user_id = ? and visible_status = ? and accessory_status = ? and expired_time > ?", userID, model2.MailVisibleStatusDefault, model2.MailAccessoryStatusUnReceive, now).
+		Order("create_time asc").Find(&userMails).Error; err != nil {
+		return nil, err
+	}
+	return userMails, nil
+}
+
+// DeleteMail 删除邮件(仅修改可见性状态)
+func (m *UserMailDao) DeleteMail(id string) error {
+	return m.db.Model(&model2.UserMail{}).Where("id = ?", id).Update("visible_status", model2.MailVisibleStatusDeleted).Error
+}
+
+// GetMailTemplatesByIDs 批量获取邮件模板
+func (m *UserMailDao) GetMailTemplatesByIDs(ids []string) (map[string]*model2.MailTemplate, error) {
+	templates := make(map[string]*model2.MailTemplate, len(ids))
+	if len(ids) == 0 {
+		return templates, nil
+	}
+	var mailTemplates []*model2.MailTemplate
+	if err := m.db.Where("id in ?", ids).Find(&mailTemplates).Error; err != nil {
+		return nil, err
+	}
+	for _, mailTemplate := range mailTemplates {
+		templates[mailTemplate.ID] = mailTemplate
+	}
+	return templates, nil
+}
+
 // CreateMailsInBatch 批量插入邮件
 func (m *UserMailDao) CreateMailsInBatch(userMail []*model2.UserMail) error {
-	return m.db.Clauses(clause.OnConflict{
-		Columns: []clause.Column{
-			{Name: "user_id"},
-			{Name: "template_id"},
-		},
-		DoNothing: true,
-	}).CreateInBatches(&userMail, 1000).Error
+	return m.db.CreateInBatches(&userMail, 1000).Error
 }
 
 // CreateMailsByFirstOrCreate 插入邮件
@@ -109,6 +208,64 @@ func (m *UserMailDao) DeleteUserMailsByExpiredTimeAndBatch(now time.Time, limit
 	return userMails, nil
 }
 
+// RevokeMailsByBroadcastAndBatch 批量撤销群发邮件,返回被撤销邮件的用户UserID
+func (m *UserMailDao) RevokeMailsByBroadcastAndBatch(broadcastID, operator string, limit int32) ([]uint64, error) {
+	var userIDs []uint64
+	raw := `
+		WITH batch_revoke AS (
+			SELECT id FROM user_mail WHERE broadcast_id = ? and visible_status = ? LIMIT ?
+		)
+		UPDATE user_mail SET visible_status = ?, operator = ?, update_time = ? WHERE id IN (SELECT id FROM batch_revoke) RETURNING user_id
+	`
+	if err := m.db.Raw(raw, broadcastID, model2.MailVisibleStatusDefault, limit, model2.MailVisibleStatusRevoke, operator, time.Now().UnixMilli()).Scan(&userIDs).Error; err != nil {
+		log.Error("UserMailDao.RevokeMailsByBroadcastAndBatch", zap.String("broadcast_id", broadcastID), zap.Error(err))
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// CountUserMailCounter 统计用户未读及附件未领取的可见邮件数
+func (m *UserMailDao) CountUserMailCounter(userID uint64, now int64) (*model2.UserMailCounter, error) {
+	counter := &model2.UserMailCounter{}
+	raw := `
+		SELECT
+			COUNT(*) FILTER (WHERE read_status = ?) AS unread,
+			COUNT(*) FILTER (WHERE accessory_status = ? AND awards IS NOT NULL AND awards::text NOT IN ('null', '{}', '[]')) AS unclaimed,
+			COALESCE(MIN(expired_time) FILTER (WHERE read_status = ? OR accessory_status = ?), 0) AS next_expire
+		FROM user_mail WHERE user_id = ? and visible_status = ? and expired_time > ?
+	`
+	if err := m.db.Raw(raw, model2.MailReadStatusUnRead, model2.MailAccessoryStatusUnReceive, model2.MailReadStatusUnRead, model2.MailAccessoryStatusUnReceive,
+		userID, model2.MailVisibleStatusDefault, now).Scan(counter).Error; err != nil {
+		log.Error("UserMailDao.CountUserMailCounter", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	return counter, nil
+}
+
+// ArchiveAndDeleteExpiredMails 批量删除过期邮件,归档成功后才提交删除
+func (m *UserMailDao) ArchiveAndDeleteExpiredMails(now time.Time, limit int32, archive func(userMails []*model2.UserMail) error) ([]*model2.UserMail, error) {
+	var userMails []*model2.UserMail
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		userMails, err = NewUserMailDao(tx).DeleteUserMailsByExpiredTimeAndBatch(now, limit)
+		if err != nil {
+			return err
+		}
+		if len(userMails) == 0 {
+			return nil
+		}
+		if err = archive(userMails); err != nil {
+			log.Error("UserMailDao.ArchiveAndDeleteExpiredMails archive error", zap.Int("batch_size", len(userMails)), zap.Error(err))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userMails, nil
+}
+
 // UpdateUserMailByTx UserMail事务更新
 func (m *UserMailDao) UpdateUserMailByTx(userID uint64, updates map[string]interface{}) error {
 	tx := m.db.Begin()
@@ -140,7 +297,57 @@ func (m *UserMailDao) UpdateUserMailByTransaction(userID uint64, updates map[str
 	}, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 }
 
+// GetMailTemplateByID 获取邮件模板
+func (m *UserMailDao) GetMailTemplateByID(id string) (*model2.MailTemplate, error) {
+	mailTemplate := &model2.MailTemplate{}
+	if err := m.db.First(mailTemplate, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return mailTemplate, nil
+}
+
 // CreateMailTemplate 创建邮件模板
 func (m *UserMailDao) CreateMailTemplate(mailTemplate *model2.MailTemplate) error {
 	return m.db.Create(mailTemplate).Error
 }
+
+// GetMailTemplatesByPage 分页获取邮件模板(按创建时间倒序)
+func (m *UserMailDao) GetMailTemplatesByPage(offset, limit int, withDeleted bool) ([]*model2.MailTemplate, int64, error) {
+	var total int64
+	var mailTemplates []*model2.MailTemplate
+	query := m.db.Model(&model2.MailTemplate{})
+	if !withDeleted {
+		query = query.Where("status <> ?", code.MailTemplateStatusDeleted)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return mailTemplates, 0, nil
+	}
+	if err := query.Order("created_at desc").Offset(offset).Limit(limit).Find(&mailTemplates).Error; err != nil {
+		return nil, 0, err
+	}
+	return mailTemplates, total, nil
+}
+
+// UpdateMailTemplateUndelivered 更新未投递过的邮件模板,已有用户邮件引用时不更新
+func (m *UserMailDao) UpdateMailTemplateUndelivered(id string, updates map[string]interface{}) (bool, error) {
+	result := m.db.Model(&model2.MailTemplate{}).
+		Where("id = ? and status <> ? and not exists (select 1 from user_mail where template_id = ?)", id, code.MailTemplateStatusDeleted, id).
+		Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteMailTemplate 删除邮件模板(仅修改状态)
+func (m *UserMailDao) DeleteMailTemplate(id string) (bool, error) {
+	result := m.db.Model(&model2.MailTemplate{}).Where("id = ? and status <> ?", id, code.MailTemplateStatusDeleted).
+		Update("status", code.MailTemplateStatusDeleted)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
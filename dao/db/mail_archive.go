@@ -0,0 +1,24 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"ppt/model"
+)
+
+// InsertUserMailArchives 批量写入邮件归档
+func InsertUserMailArchives(ckDB *gorm.DB, archives []*model.UserMailArchive) error {
+	return ckDB.CreateInBatches(&archives, 5000).Error
+}
+
+// GetUserMailArchives 查询用户邮件归档(templateID为空时查询全部)
+func GetUserMailArchives(ckDB *gorm.DB, userID uint64, templateID string, limit int) ([]*model.UserMailArchive, error) {
+	var archives []*model.UserMailArchive
+	query := ckDB.Where("user_id = ?", userID)
+	if templateID != "" {
+		query = query.Where("template_id = ?", templateID)
+	}
+	if err := query.Order("create_time desc").Limit(limit).Find(&archives).Error; err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
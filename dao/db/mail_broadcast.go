@@ -0,0 +1,110 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ppt/model"
+	"time"
+)
+
+var ErrMailBroadcastCursorMoved = errors.New("mail broadcast cursor moved")
+
+type MailBroadcastDao struct {
+	db *gorm.DB
+}
+
+func NewMailBroadcastDao(db *gorm.DB) *MailBroadcastDao {
+	return &MailBroadcastDao{db: db}
+}
+
+// CreateMailBroadcast 创建群发任务
+func (m *MailBroadcastDao) CreateMailBroadcast(broadcast *model.MailBroadcast) error {
+	return m.db.Create(broadcast).Error
+}
+
+// GetMailBroadcastByID 获取群发任务
+func (m *MailBroadcastDao) GetMailBroadcastByID(id string) (*model.MailBroadcast, error) {
+	broadcast := &model.MailBroadcast{}
+	if err := m.db.First(broadcast, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return broadcast, nil
+}
+
+// UpdateMailBroadcastStatus 更新群发状态(仅当前状态为fromStatus时生效)
+func (m *MailBroadcastDao) UpdateMailBroadcastStatus(id string, fromStatus, toStatus model.MailBroadcastStatusType, errMsg string) (bool, error) {
+	updates := map[string]interface{}{"status": toStatus}
+	if errMsg != "" {
+		updates["err_msg"] = errMsg
+	}
+	if toStatus == model.MailBroadcastStatusFinished || toStatus == model.MailBroadcastStatusFailed {
+		updates["finish_time"] = time.Now().UnixMilli()
+	}
+	result := m.db.Model(&model.MailBroadcast{}).Where("id = ? and status = ?", id, fromStatus).Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CloseMailBroadcast 取消/撤销群发任务(仅当前状态属于fromStatuses时生效)
+func (m *MailBroadcastDao) CloseMailBroadcast(id string, fromStatuses []model.MailBroadcastStatusType, toStatus model.MailBroadcastStatusType, operator string) (bool, error) {
+	result := m.db.Model(&model.MailBroadcast{}).Where("id = ? and status in ?", id, fromStatuses).Updates(map[string]interface{}{
+		"status":         toStatus,
+		"close_operator": operator,
+		"close_time":     time.Now().UnixMilli(),
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// DeliverMailBatchByTx 事务投递一批邮件并推进游标
+// 游标已被其它任务推进时返回ErrMailBroadcastCursorMoved,本批次回滚;同一群发任务对同一用户至多投递一封
+func (m *MailBroadcastDao) DeliverMailBatchByTx(id string, lastUserID, newLastUserID uint64, userMails []*model.UserMail) (int64, error) {
+	var delivered int64
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "broadcast_id"},
+				{Name: "user_id"},
+			},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "broadcast_id <> ''"}}},
+			DoNothing:   true,
+		}).CreateInBatches(&userMails, 1000)
+		if result.Error != nil {
+			return result.Error
+		}
+		delivered = result.RowsAffected
+
+		result = tx.Model(&model.MailBroadcast{}).
+			Where("id = ? and last_user_id = ? and status = ?", id, lastUserID, model.MailBroadcastStatusRunning).
+			Updates(map[string]interface{}{
+				"last_user_id": newLastUserID,
+				"delivered":    gorm.Expr("delivered + ?", delivered),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMailBroadcastCursorMoved
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return delivered, nil
+}
+
+// GetStaleMailBroadcasts 获取长时间未推进的待发送/发送中任务
+func (m *MailBroadcastDao) GetStaleMailBroadcasts(before int64) ([]*model.MailBroadcast, error) {
+	var broadcasts []*model.MailBroadcast
+	statuses := []model.MailBroadcastStatusType{model.MailBroadcastStatusPending, model.MailBroadcastStatusRunning}
+	if err := m.db.Where("status in ? and update_time < ?", statuses, before).Find(&broadcasts).Error; err != nil {
+		return nil, err
+	}
+	return broadcasts, nil
+}
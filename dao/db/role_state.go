@@ -0,0 +1,49 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ppt/model"
+	"time"
+)
+
+type RoleStateDao struct {
+	db *gorm.DB
+}
+
+func NewRoleStateDao(db *gorm.DB) *RoleStateDao {
+	return &RoleStateDao{db: db}
+}
+
+// GetRoleState 获取角色状态(不存在时返回nil)
+func (r *RoleStateDao) GetRoleState(userID uint64) (*model.RoleState, error) {
+	var states []*model.RoleState
+	if err := r.db.Where("user_id = ?", userID).Limit(1).Find(&states).Error; err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return nil, nil
+	}
+	return states[0], nil
+}
+
+// SaveRoleState 按版本写入角色状态,state.Version为已读取的版本(0表示尚未持久化),写入后版本加1
+// 版本已被其他节点更新时返回false
+func (r *RoleStateDao) SaveRoleState(state *model.RoleState) (bool, error) {
+	if state.Version == 0 {
+		result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.RoleState{
+			UserID:  state.UserID,
+			Version: 1,
+			Data:    state.Data,
+		})
+		return result.RowsAffected > 0, result.Error
+	}
+	result := r.db.Model(&model.RoleState{}).
+		Where("user_id = ? and version = ?", state.UserID, state.Version).
+		Updates(map[string]interface{}{
+			"data":       state.Data,
+			"version":    state.Version + 1,
+			"updated_at": time.Now().UnixMilli(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
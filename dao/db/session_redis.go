@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/log"
+	"ppt/model"
+	"time"
+)
+
+// SetUserSession 创建登录会话
+func SetUserSession(client redis.UniversalClient, session *model.UserSession, ttl time.Duration) error {
+	sessionKey := fmt.Sprintf(dao.UserSessionKey, session.SessionID)
+	_, err := client.TxPipelined(dao.Ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(dao.Ctx, sessionKey, session)
+		pipe.Expire(dao.Ctx, sessionKey, ttl)
+		pipe.Set(dao.Ctx, fmt.Sprintf(dao.UserSessionSeenKey, session.SessionID), session.CreatedAt, ttl)
+		return nil
+	})
+	if err != nil {
+		log.Error("SetUserSession redis TxPipelined error", zap.Uint64("user_id", session.UserID), zap.String("session_id", session.SessionID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// TouchUserSession 刷新token时延长会话有效期并更新最近活跃时间,会话已注销时不会重建
+func TouchUserSession(client redis.UniversalClient, sessionID string, ttl time.Duration) error {
+	_, err := client.Pipelined(dao.Ctx, func(pipe redis.Pipeliner) error {
+		pipe.Expire(dao.Ctx, fmt.Sprintf(dao.UserSessionKey, sessionID), ttl)
+		pipe.Set(dao.Ctx, fmt.Sprintf(dao.UserSessionSeenKey, sessionID), time.Now().UnixMilli(), ttl)
+		return nil
+	})
+	if err != nil {
+		log.Error("TouchUserSession redis Pipelined error", zap.String("session_id", sessionID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetUserSessions 获取用户全部有效会话,并清理已过期的会话索引
+func GetUserSessions(client redis.UniversalClient, userID uint64) ([]*model.UserSession, error) {
+	userKey := fmt.Sprintf(dao.UserRefreshKey, userID)
+	sessionIDs, err := client.SMembers(dao.Ctx, userKey).Result()
+	if err != nil {
+		log.Error("GetUserSessions redis SMembers error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+	pipe := client.Pipeline()
+	sessionCmds := make([]*redis.MapStringStringCmd, len(sessionIDs))
+	seenCmds := make([]*redis.StringCmd, len(sessionIDs))
+	for i, sessionID := range sessionIDs {
+		sessionCmds[i] = pipe.HGetAll(dao.Ctx, fmt.Sprintf(dao.UserSessionKey, sessionID))
+		seenCmds[i] = pipe.Get(dao.Ctx, fmt.Sprintf(dao.UserSessionSeenKey, sessionID))
+	}
+	if _, err = pipe.Exec(dao.Ctx); err != nil && !errors.Is(err, redis.Nil) {
+		log.Error("GetUserSessions redis pipe exec error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	sessions := make([]*model.UserSession, 0, len(sessionIDs))
+	var expiredIDs []interface{}
+	for i, sessionID := range sessionIDs {
+		if len(sessionCmds[i].Val()) == 0 {
+			expiredIDs = append(expiredIDs, sessionID)
+			continue
+		}
+		session := &model.UserSession{}
+		if err = sessionCmds[i].Scan(session); err != nil {
+			log.Error("GetUserSessions redis Scan error", zap.String("session_id", sessionID), zap.Error(err))
+			return nil, err
+		}
+		session.LastSeen, _ = seenCmds[i].Int64()
+		sessions = append(sessions, session)
+	}
+	if len(expiredIDs) > 0 {
+		client.SRem(dao.Ctx, userKey, expiredIDs...)
+	}
+	return sessions, nil
+}
+
+// DelUserSession 注销会话,同时使会话下的refresh token失效
+func DelUserSession(client redis.UniversalClient, userID uint64, sessionID string) error {
+	if err := DelRefreshFamily(client, sessionID); err != nil {
+		return err
+	}
+	if err := client.SRem(dao.Ctx, fmt.Sprintf(dao.UserRefreshKey, userID), sessionID).Err(); err != nil {
+		log.Error("DelUserSession redis SRem error", zap.Uint64("user_id", userID), zap.String("session_id", sessionID), zap.Error(err))
+		return err
+	}
+	return nil
+}
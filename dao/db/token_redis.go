@@ -0,0 +1,167 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/log"
+	"ppt/model"
+	"time"
+)
+
+// SetRefreshToken 保存refresh token并设为所属轮换链的当前token
+func SetRefreshToken(client redis.UniversalClient, tokenHash string, token *model.RefreshToken, ttl time.Duration) error {
+	tokenKey := fmt.Sprintf(dao.RefreshTokenKey, tokenHash)
+	userKey := fmt.Sprintf(dao.UserRefreshKey, token.UserID)
+	_, err := client.TxPipelined(dao.Ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(dao.Ctx, tokenKey, token)
+		pipe.Expire(dao.Ctx, tokenKey, ttl)
+		pipe.Set(dao.Ctx, fmt.Sprintf(dao.RefreshFamilyKey, token.FamilyID), tokenHash, ttl)
+		pipe.SAdd(dao.Ctx, userKey, token.FamilyID)
+		pipe.Expire(dao.Ctx, userKey, ttl)
+		return nil
+	})
+	if err != nil {
+		log.Error("SetRefreshToken redis TxPipelined error", zap.Uint64("user_id", token.UserID), zap.String("family_id", token.FamilyID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetRefreshToken 获取refresh token(不存在或已过期时返回nil)
+func GetRefreshToken(client redis.UniversalClient, tokenHash string) (*model.RefreshToken, error) {
+	result := client.HGetAll(dao.Ctx, fmt.Sprintf(dao.RefreshTokenKey, tokenHash))
+	if err := result.Err(); err != nil {
+		log.Error("GetRefreshToken redis HGetAll error", zap.Error(err))
+		return nil, err
+	}
+	if len(result.Val()) == 0 {
+		return nil, nil
+	}
+	token := &model.RefreshToken{}
+	if err := result.Scan(token); err != nil {
+		log.Error("GetRefreshToken redis Scan error", zap.Error(err))
+		return nil, err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken 标记refresh token已轮换,仅首次标记返回true
+func RotateRefreshToken(client redis.UniversalClient, tokenHash string) (bool, error) {
+	rotated, err := client.HIncrBy(dao.Ctx, fmt.Sprintf(dao.RefreshTokenKey, tokenHash), "rotated", 1).Result()
+	if err != nil {
+		log.Error("RotateRefreshToken redis HIncrBy error", zap.Error(err))
+		return false, err
+	}
+	return rotated == 1, nil
+}
+
+// GetRefreshFamilyCurrent 获取轮换链当前有效token哈希(轮换链已失效时返回空)
+func GetRefreshFamilyCurrent(client redis.UniversalClient, familyID string) (string, error) {
+	tokenHash, err := client.Get(dao.Ctx, fmt.Sprintf(dao.RefreshFamilyKey, familyID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", nil
+		}
+		log.Error("GetRefreshFamilyCurrent redis Get error", zap.String("family_id", familyID), zap.Error(err))
+		return "", err
+	}
+	return tokenHash, nil
+}
+
+// DelRefreshFamily 使整条轮换链及对应登录会话失效
+func DelRefreshFamily(client redis.UniversalClient, familyID string) error {
+	familyKey := fmt.Sprintf(dao.RefreshFamilyKey, familyID)
+	tokenHash, err := GetRefreshFamilyCurrent(client, familyID)
+	if err != nil {
+		return err
+	}
+	keys := []string{familyKey, fmt.Sprintf(dao.UserSessionKey, familyID), fmt.Sprintf(dao.UserSessionSeenKey, familyID)}
+	if tokenHash != "" {
+		keys = append(keys, fmt.Sprintf(dao.RefreshTokenKey, tokenHash))
+	}
+	if err = client.Del(dao.Ctx, keys...).Err(); err != nil {
+		log.Error("DelRefreshFamily redis Del error", zap.String("family_id", familyID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DelUserRefreshFamilies 使用户全部refresh token轮换链及登录会话失效(如重置密码后)
+func DelUserRefreshFamilies(client redis.UniversalClient, userID uint64) error {
+	userKey := fmt.Sprintf(dao.UserRefreshKey, userID)
+	familyIDs, err := client.SMembers(dao.Ctx, userKey).Result()
+	if err != nil {
+		log.Error("DelUserRefreshFamilies redis SMembers error", zap.Uint64("user_id", userID), zap.Error(err))
+		return err
+	}
+	for _, familyID := range familyIDs {
+		if err = DelRefreshFamily(client, familyID); err != nil {
+			return err
+		}
+	}
+	return client.Del(dao.Ctx, userKey).Err()
+}
+
+// SetMfaChallenge 保存登录两步验证挑战
+func SetMfaChallenge(client redis.UniversalClient, challenge string, userID uint64) error {
+	if err := client.Set(dao.Ctx, fmt.Sprintf(dao.MfaChallengeKey, challenge), userID, dao.MfaChallengeExpiration).Err(); err != nil {
+		log.Error("SetMfaChallenge redis Set error", zap.Uint64("user_id", userID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetMfaChallenge 获取挑战对应的UserID(不存在或已过期时返回0)
+func GetMfaChallenge(client redis.UniversalClient, challenge string) (uint64, error) {
+	userID, err := client.Get(dao.Ctx, fmt.Sprintf(dao.MfaChallengeKey, challenge)).Uint64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		log.Error("GetMfaChallenge redis Get error", zap.Error(err))
+		return 0, err
+	}
+	return userID, nil
+}
+
+// DelMfaChallenge 删除挑战,仅首次删除返回true(挑战只能使用一次)
+func DelMfaChallenge(client redis.UniversalClient, challenge string) (bool, error) {
+	deleted, err := client.Del(dao.Ctx, fmt.Sprintf(dao.MfaChallengeKey, challenge)).Result()
+	if err != nil {
+		log.Error("DelMfaChallenge redis Del error", zap.Error(err))
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+// GetTotpFailCount 获取两步验证失败次数
+func GetTotpFailCount(client redis.UniversalClient, userID uint64) (int64, error) {
+	count, err := client.Get(dao.Ctx, fmt.Sprintf(dao.TotpFailKey, userID)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Error("GetTotpFailCount redis Get error", zap.Uint64("user_id", userID), zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
+
+// IncrTotpFailCount 累加两步验证失败次数,窗口从首次失败开始计算
+func IncrTotpFailCount(client redis.UniversalClient, userID uint64) (int64, error) {
+	key := fmt.Sprintf(dao.TotpFailKey, userID)
+	count, err := client.Incr(dao.Ctx, key).Result()
+	if err != nil {
+		log.Error("IncrTotpFailCount redis Incr error", zap.Uint64("user_id", userID), zap.Error(err))
+		return 0, err
+	}
+	if count == 1 {
+		client.Expire(dao.Ctx, key, dao.TotpFailWindow)
+	}
+	return count, nil
+}
+
+// ResetTotpFailCount 验证成功后清除失败次数
+func ResetTotpFailCount(client redis.UniversalClient, userID uint64) error {
+	return client.Del(dao.Ctx, fmt.Sprintf(dao.TotpFailKey, userID)).Err()
+}
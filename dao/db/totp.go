@@ -0,0 +1,94 @@
+package db
+
+import (
+	"encoding/json"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ppt/model"
+	"time"
+)
+
+type UserTotpDao struct {
+	db *gorm.DB
+}
+
+func NewUserTotpDao(db *gorm.DB) *UserTotpDao {
+	return &UserTotpDao{db: db}
+}
+
+// GetUserTotp 获取用户两步验证(不存在时返回nil)
+func (t *UserTotpDao) GetUserTotp(userID uint64) (*model.UserTotp, error) {
+	var userTotps []*model.UserTotp
+	if err := t.db.Where("user_id = ?", userID).Limit(1).Find(&userTotps).Error; err != nil {
+		return nil, err
+	}
+	if len(userTotps) == 0 {
+		return nil, nil
+	}
+	return userTotps[0], nil
+}
+
+// SaveUserTotpPending 保存待确认的密钥,已启用时不覆盖并返回false
+func (t *UserTotpDao) SaveUserTotpPending(userID uint64, secretEnc string) (bool, error) {
+	result := t.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"secret_enc":     secretEnc,
+			"recovery_codes": nil,
+			"last_used_step": 0,
+			"updated_at":     time.Now().UnixMilli(),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{clause.Eq{Column: "user_totp.status", Value: model.UserTotpStatusPending}}},
+	}).Create(&model.UserTotp{UserID: userID, SecretEnc: secretEnc, Status: model.UserTotpStatusPending})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// EnableUserTotp 确认启用两步验证并保存恢复码哈希,仅待确认状态可启用
+func (t *UserTotpDao) EnableUserTotp(userID uint64, step int64, recoveryHashes []string) (bool, error) {
+	recoveryCodes, err := json.Marshal(recoveryHashes)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UnixMilli()
+	result := t.db.Model(&model.UserTotp{}).
+		Where("user_id = ? and status = ?", userID, model.UserTotpStatusPending).
+		Updates(map[string]interface{}{
+			"status":         model.UserTotpStatusEnabled,
+			"recovery_codes": recoveryCodes,
+			"last_used_step": step,
+			"enabled_at":     now,
+			"updated_at":     now,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// UseUserTotpStep 记录已使用的时间步,时间步不大于上次使用值(验证码重放)时返回false
+func (t *UserTotpDao) UseUserTotpStep(userID uint64, step int64) (bool, error) {
+	result := t.db.Model(&model.UserTotp{}).
+		Where("user_id = ? and status = ? and last_used_step < ?", userID, model.UserTotpStatusEnabled, step).
+		Updates(map[string]interface{}{"last_used_step": step, "updated_at": time.Now().UnixMilli()})
+	return result.RowsAffected > 0, result.Error
+}
+
+// UseUserRecoveryCode 消耗一个恢复码,恢复码不存在或已使用时返回false
+func (t *UserTotpDao) UseUserRecoveryCode(userID uint64, codeHash string) (bool, error) {
+	hashJSON, err := json.Marshal([]string{codeHash})
+	if err != nil {
+		return false, err
+	}
+	result := t.db.Model(&model.UserTotp{}).
+		Where("user_id = ? and status = ? and recovery_codes @> ?::jsonb", userID, model.UserTotpStatusEnabled, string(hashJSON)).
+		Updates(map[string]interface{}{
+			"recovery_codes": gorm.Expr("recovery_codes - ?::text", codeHash),
+			"updated_at":     time.Now().UnixMilli(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteUserTotp 关闭两步验证
+func (t *UserTotpDao) DeleteUserTotp(userID uint64) error {
+	return t.db.Where("user_id = ?", userID).Delete(&model.UserTotp{}).Error
+}
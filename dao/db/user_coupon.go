@@ -1,33 +1,41 @@
 package db
 
 import (
-	"github.com/google/uuid"
+	"encoding/json"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"ppt/log"
 	"ppt/model"
 	"time"
 )
 
-// BatchUpdateExpiredCoupons 使用游标批量更新过期优惠券(超大数据)
-func BatchUpdateExpiredCoupons(db *gorm.DB, batchSize int) error {
-	var cursorID uint64
-	var cursorCouponID uuid.UUID
-	currentTime := time.Now()
+// CountExpiredCoupons 统计截止时间前已过期但仍为可用状态的优惠券数
+func CountExpiredCoupons(db *gorm.DB, cutoff int64) (int64, error) {
+	var count int64
+	if err := db.Model(&model.UserCoupon{}).Where("expired_at <= ? AND coupon_status = ?", cutoff, model.CouponStatusAvailable).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
 
-	log.Info("Begin BatchUpdateExpiredCoupons", zap.String("current_time", currentTime.Format(time.RFC3339)))
+// BatchUpdateExpiredCoupons 使用游标批量更新过期优惠券(超大数据)
+// 从cursor位置继续处理,每批更新后回调onBatch(用于发送事件及保存游标),回调失败时中止
+func BatchUpdateExpiredCoupons(db *gorm.DB, batchSize int, cursor *model.CouponExpireCursor,
+	onBatch func(cursor *model.CouponExpireCursor, expired []*model.UserCoupon) error) error {
+	log.Info("Begin BatchUpdateExpiredCoupons", zap.Int64("cutoff", cursor.Cutoff), zap.Uint64("cursor_user_id", cursor.UserID), zap.String("cursor_coupon_id", cursor.CouponID.String()))
 
 	for {
 		var userCoupons []model.UserCoupon
 
 		// 构建基础查询：查询未过期的记录
 		query := db.Where("expired_at <= ? AND coupon_status = ?",
-			currentTime.UnixMilli(), model.CouponStatusAvailable)
+			cursor.Cutoff, model.CouponStatusAvailable)
 
 		// 如果有游标，添加游标条件（ID > 上一批最后一条记录的ID）
-		if cursorID != 0 {
+		if cursor.UserID != 0 {
 			query = query.Where("(user_id, coupon_id) > (?, ?)",
-				cursorID, cursorCouponID)
+				cursor.UserID, cursor.CouponID)
 		}
 
 		// 按主键排序并限制批次大小
@@ -45,75 +53,125 @@ func BatchUpdateExpiredCoupons(db *gorm.DB, batchSize int) error {
 		}
 
 		// 批量更新当前批次的优惠券状态
-		if err := updateCurrentBatch(db, userCoupons); err != nil {
+		expired, err := updateCurrentBatch(db, userCoupons)
+		if err != nil {
 			return err
 		}
 
 		// 更新游标位置
 		lastCoupon := userCoupons[len(userCoupons)-1]
-		cursorID = lastCoupon.UserID
-		cursorCouponID = lastCoupon.CouponID
+		cursor.UserID = lastCoupon.UserID
+		cursor.CouponID = lastCoupon.CouponID
+		if err = onBatch(cursor, expired); err != nil {
+			return err
+		}
 
-		log.Info("Current process info", zap.Int("cur_batch_size", len(userCoupons)), zap.Uint64("cur_cursor_user_id", cursorID), zap.String("cur_cursor_coupon_id", cursorCouponID.String()))
+		log.Info("Current process info", zap.Int("cur_batch_size", len(userCoupons)), zap.Int("cur_expired", len(expired)), zap.Uint64("cur_cursor_user_id", cursor.UserID), zap.String("cur_cursor_coupon_id", cursor.CouponID.String()))
 	}
 
 	log.Info("End BatchUpdateExpiredCoupons")
 	return nil
 }
 
-// updateCurrentBatch 更新当前批次的优惠券状态
-func updateCurrentBatch(db *gorm.DB, coupons []model.UserCoupon) error {
+// updateCurrentBatch 更新当前批次的优惠券状态,返回实际过期的优惠券
+// 仅更新仍为可用状态的优惠券,避免覆盖查询后被核销的记录;过期事件在同一事务写入outbox
+func updateCurrentBatch(db *gorm.DB, coupons []model.UserCoupon) ([]*model.UserCoupon, error) {
 	// 提取所有要更新的优惠券ID
-	couponIDs := make([]struct {
-		UserID   uint64
-		CouponID uuid.UUID
-	}, 0, len(coupons))
-
+	couponIDs := make([][]interface{}, 0, len(coupons))
 	for _, coupon := range coupons {
-		couponIDs = append(couponIDs, struct {
-			UserID   uint64
-			CouponID uuid.UUID
-		}{
-			UserID:   coupon.UserID,
-			CouponID: coupon.CouponID,
-		})
+		couponIDs = append(couponIDs, []interface{}{coupon.UserID, coupon.CouponID})
 	}
 
 	// 执行批量更新
-	result := db.Model(&model.UserCoupon{}).
-		Where("(user_id, coupon_id) IN ?", couponIDs).
-		Updates(map[string]interface{}{
-			"coupon_status": model.CouponStatusExpired,
-			"updated_at":    time.Now().UnixMilli(),
-		})
-
-	if result.Error != nil {
-		return result.Error
+	var expired []*model.UserCoupon
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&expired).
+			Clauses(clause.Returning{Columns: expiredCouponColumns()}).
+			Where("(user_id, coupon_id) IN ? AND coupon_status = ?", couponIDs, model.CouponStatusAvailable).
+			Updates(map[string]interface{}{
+				"coupon_status": model.CouponStatusExpired,
+				"updated_at":    time.Now().UnixMilli(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		return createCouponExpiredOutbox(tx, expired)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	log.Info("updateCurrentBatch success", zap.Int64("success_updated", result.RowsAffected))
-	return nil
+	log.Info("updateCurrentBatch success", zap.Int("success_updated", len(expired)))
+	return expired, nil
 }
 
-// DirectedUpdateExpiredCoupons 批量更新方法(数据量不大)
-func DirectedUpdateExpiredCoupons(db *gorm.DB) error {
-	currentTime := time.Now()
+// DirectedUpdateExpiredCoupons 批量更新方法(数据量不大),返回实际过期的优惠券
+func DirectedUpdateExpiredCoupons(db *gorm.DB, cutoff int64) ([]*model.UserCoupon, error) {
+	log.Info("Begin DirectedUpdateExpiredCoupons", zap.Int64("cutoff", cutoff))
+
+	// 直接使用UPDATE语句批量更新，避免数据迁移;过期事件在同一事务写入outbox
+	var expired []*model.UserCoupon
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&expired).
+			Clauses(clause.Returning{Columns: expiredCouponColumns()}).
+			Where("expired_at <= ? AND coupon_status = ?",
+				cutoff, model.CouponStatusAvailable).
+			Updates(map[string]interface{}{
+				"coupon_status": model.CouponStatusExpired,
+				"updated_at":    time.Now().UnixMilli(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		return createCouponExpiredOutbox(tx, expired)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	log.Info("Begin DirectedUpdateExpiredCoupons", zap.String("current_time", currentTime.Format(time.RFC3339)))
+	log.Info("End DirectedUpdateExpiredCoupons", zap.Int("success_updated", len(expired)))
+	return expired, nil
+}
 
-	// 直接使用UPDATE语句批量更新，避免数据迁移
-	result := db.Model(&model.UserCoupon{}).
-		Where("expired_at <= ? AND coupon_status = ?",
-			currentTime.UnixMilli(), model.CouponStatusAvailable).
-		Updates(map[string]interface{}{
-			"coupon_status": model.CouponStatusExpired,
-			"updated_at":    currentTime.UnixMilli(),
-		})
+// createCouponExpiredOutbox 写入优惠券过期事件outbox
+func createCouponExpiredOutbox(tx *gorm.DB, expired []*model.UserCoupon) error {
+	if len(expired) == 0 {
+		return nil
+	}
+	eventTime := time.Now().UnixMilli()
+	outbox := make([]*model.CouponEventOutbox, 0, len(expired))
+	for _, userCoupon := range expired {
+		payload, err := json.Marshal(model.NewCouponExpiredEvent(userCoupon, eventTime))
+		if err != nil {
+			return err
+		}
+		outbox = append(outbox, &model.CouponEventOutbox{UserID: userCoupon.UserID, Payload: payload})
+	}
+	return tx.CreateInBatches(&outbox, 1000).Error
+}
 
-	if result.Error != nil {
-		return result.Error
+// GetCouponEventOutbox 按写入顺序获取待发送的优惠券事件
+func GetCouponEventOutbox(db *gorm.DB, limit int) ([]*model.CouponEventOutbox, error) {
+	var outbox []*model.CouponEventOutbox
+	if err := db.Order("id").Limit(limit).Find(&outbox).Error; err != nil {
+		log.Error("GetCouponEventOutbox error", zap.Error(err))
+		return nil, err
 	}
+	return outbox, nil
+}
 
-	log.Info("End DirectedUpdateExpiredCoupons", zap.Int64("success_updated", result.RowsAffected))
+// DeleteCouponEventOutbox 删除已发送的优惠券事件
+func DeleteCouponEventOutbox(db *gorm.DB, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := db.Where("id in ?", ids).Delete(&model.CouponEventOutbox{}).Error; err != nil {
+		log.Error("DeleteCouponEventOutbox error", zap.Int("count", len(ids)), zap.Error(err))
+		return err
+	}
 	return nil
 }
+
+func expiredCouponColumns() []clause.Column {
+	return []clause.Column{{Name: "user_id"}, {Name: "coupon_id"}, {Name: "template_id"}, {Name: "coupon_type"}, {Name: "expired_at"}}
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -23,6 +24,65 @@ func UpdateUserBalance(mongoClient *mongo.Client, userID uint64, amount int64) (
 	return result["balance"].(int64), nil
 }
 
+// UpdateUserCoinByLedger 按流水增加用户货币,由ApplyCoinLedgerByTx在流水行锁内调用
+// 幂等以PG流水状态为准;ledger_ids仅保留最近入账的流水,用于覆盖货币已增加但PG事务提交失败后的重试
+func UpdateUserCoinByLedger(mongoClient *mongo.Client, userID uint64, ledgerID, coinType string, amount int64) error {
+	userCredit := mongoClient.Database(dao.MongoDBPPT).Collection(dao.MongoCollUserCredit)
+	filter := bson.M{"user_id": userID, "ledger_ids": bson.M{"$ne": ledgerID}}
+	update := bson.M{
+		"$inc":  bson.M{coinType: amount},
+		"$push": bson.M{"ledger_ids": bson.M{"$each": []string{ledgerID}, "$slice": -dao.UserCreditLedgerIDsMax}},
+	}
+	result, err := userCredit.UpdateOne(dao.Ctx, filter, update)
+	if err != nil {
+		log.Error("UpdateUserCoinByLedger update error", zap.Uint64("user_id", userID), zap.String("ledger_id", ledgerID), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	// 流水已生效或用户货币文档不存在
+	count, err := userCredit.CountDocuments(dao.Ctx, bson.M{"user_id": userID})
+	if err != nil {
+		log.Error("UpdateUserCoinByLedger count error", zap.Uint64("user_id", userID), zap.Error(err))
+		return err
+	}
+	if count > 0 {
+		log.Info("UpdateUserCoinByLedger ledger already applied", zap.Uint64("user_id", userID), zap.String("ledger_id", ledgerID))
+		return nil
+	}
+	_, err = userCredit.InsertOne(dao.Ctx, bson.M{"user_id": userID, coinType: amount, "ledger_ids": []string{ledgerID}})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// 并发创建,重试一次
+			return UpdateUserCoinByLedger(mongoClient, userID, ledgerID, coinType, amount)
+		}
+		log.Error("UpdateUserCoinByLedger insert error", zap.Uint64("user_id", userID), zap.String("ledger_id", ledgerID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetUserCoin 获取用户指定货币数量,文档不存在时返回0
+func GetUserCoin(mongoClient *mongo.Client, userID uint64, coinType string) (int64, error) {
+	userCredit := mongoClient.Database(dao.MongoDBPPT).Collection(dao.MongoCollUserCredit)
+	findOpts := options.FindOne().SetProjection(bson.M{coinType: 1})
+	var result bson.M
+	if err := userCredit.FindOne(dao.Ctx, bson.M{"user_id": userID}, findOpts).Decode(&result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	switch amount := result[coinType].(type) {
+	case int64:
+		return amount, nil
+	case int32:
+		return int64(amount), nil
+	}
+	return 0, nil
+}
+
 func UpdateUserLogin(mongoClient *mongo.Client, userID uint64, loginTime int64, loginIP string) error {
 	userLogin := mongoClient.Database(dao.MongoDBPPT).Collection(dao.MongoCollUserLogin)
 	res, err := userLogin.InsertOne(dao.Ctx, map[string]interface{}{
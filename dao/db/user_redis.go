@@ -295,3 +295,135 @@ func GenerateMultipleUserIDs(client redis.UniversalClient, num int) ([]uint64, e
 func GetUserMailExpiredLock(client redis.UniversalClient, key string, expireTime time.Duration) (bool, error) {
 	return client.SetNX(dao.Ctx, key, time.Now().UnixMilli(), expireTime).Result()
 }
+
+// GetUserMailCounter 获取用户邮件红点计数缓存(未缓存时返回nil)
+func GetUserMailCounter(client redis.UniversalClient, userID uint64) (*model.UserMailCounter, error) {
+	key := fmt.Sprintf(dao.UserMailCounterKey, userID)
+	result, err := client.HGetAll(dao.Ctx, key).Result()
+	if err != nil {
+		log.Error("GetUserMailCounter redis HGetAll error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	counter := &model.UserMailCounter{}
+	if counter.Unread, err = strconv.ParseInt(result["unread"], 10, 64); err != nil {
+		log.Error("GetUserMailCounter ParseInt unread error", zap.Uint64("user_id", userID), zap.Any("counter", result), zap.Error(err))
+		return nil, err
+	}
+	if counter.Unclaimed, err = strconv.ParseInt(result["unclaimed"], 10, 64); err != nil {
+		log.Error("GetUserMailCounter ParseInt unclaimed error", zap.Uint64("user_id", userID), zap.Any("counter", result), zap.Error(err))
+		return nil, err
+	}
+	return counter, nil
+}
+
+// SetUserMailCounter 缓存用户邮件红点计数,最早有邮件过期时缓存随之失效,并登记该时间以便过期时通知
+func SetUserMailCounter(client redis.UniversalClient, userID uint64, counter *model.UserMailCounter) error {
+	key := fmt.Sprintf(dao.UserMailCounterKey, userID)
+	expireAt := time.Now().Add(dao.UserMailCounterExpiration)
+	if counter.NextExpire > 0 && counter.NextExpire < expireAt.UnixMilli() {
+		expireAt = time.UnixMilli(counter.NextExpire)
+	}
+	pipe := client.TxPipeline()
+	pipe.HSet(dao.Ctx, key, "unread", counter.Unread, "unclaimed", counter.Unclaimed)
+	pipe.ExpireAt(dao.Ctx, key, expireAt)
+	if counter.NextExpire > 0 {
+		pipe.ZAdd(dao.Ctx, dao.UserMailExpireNoticeKey, redis.Z{Score: float64(counter.NextExpire), Member: userID})
+	}
+	if _, err := pipe.Exec(dao.Ctx); err != nil {
+		log.Error("SetUserMailCounter pipe exec error", zap.Uint64("user_id", userID), zap.Any("counter", counter), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DelUserMailCounters 批量清除用户邮件红点计数缓存
+func DelUserMailCounters(client redis.UniversalClient, userIDs []uint64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	pipe := client.Pipeline()
+	for _, userID := range userIDs {
+		pipe.Del(dao.Ctx, fmt.Sprintf(dao.UserMailCounterKey, userID))
+	}
+	if _, err := pipe.Exec(dao.Ctx); err != nil {
+		log.Error("DelUserMailCounters pipe exec error", zap.Int("user_count", len(userIDs)), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// PopUserMailExpireNotices 取出计数邮件已到期的用户,多实例并发取出时每个用户只返回一次
+func PopUserMailExpireNotices(client redis.UniversalClient, now int64, limit int64) ([]uint64, error) {
+	members, err := client.ZRangeByScore(dao.Ctx, dao.UserMailExpireNoticeKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: limit,
+	}).Result()
+	if err != nil {
+		log.Error("PopUserMailExpireNotices redis ZRangeByScore error", zap.Error(err))
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	pipe := client.Pipeline()
+	results := make([]*redis.IntCmd, len(members))
+	for i, member := range members {
+		results[i] = pipe.ZRem(dao.Ctx, dao.UserMailExpireNoticeKey, member)
+	}
+	if _, err = pipe.Exec(dao.Ctx); err != nil {
+		log.Error("PopUserMailExpireNotices pipe exec error", zap.Int("member_count", len(members)), zap.Error(err))
+		return nil, err
+	}
+	userIDs := make([]uint64, 0, len(members))
+	for i, member := range members {
+		if results[i].Val() == 0 {
+			continue
+		}
+		userID, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			log.Error("PopUserMailExpireNotices ParseUint error", zap.String("member", member), zap.Error(err))
+			continue
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+// AllocFunctionIDSegment 分配功能ID号段,返回号段起始ID
+func AllocFunctionIDSegment(client redis.UniversalClient, key string, step int64) (int64, error) {
+	if err := client.SetNX(dao.Ctx, key, dao.FunctionIDMin, 0).Err(); err != nil {
+		log.Error("AllocFunctionIDSegment redis SetNX error", zap.String("key", key), zap.Error(err))
+		return 0, err
+	}
+	maxID, err := client.IncrBy(dao.Ctx, key, step).Result()
+	if err != nil {
+		log.Error("AllocFunctionIDSegment redis IncrBy error", zap.String("key", key), zap.Error(err))
+		return 0, err
+	}
+	return maxID - step, nil
+}
+
+// IncrRegisterCount 原子累加每日注册数并返回累加后的值
+func IncrRegisterCount(client redis.UniversalClient, key string) (int64, error) {
+	pipe := client.TxPipeline()
+	incr := pipe.Incr(dao.Ctx, key)
+	pipe.Expire(dao.Ctx, key, dao.RegisterCountExpiration)
+	if _, err := pipe.Exec(dao.Ctx); err != nil {
+		log.Error("IncrRegisterCount pipe exec error", zap.String("key", key), zap.Error(err))
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// DecrRegisterCount 注册被拦截或失败时归还已累加的注册数
+func DecrRegisterCount(client redis.UniversalClient, key string) error {
+	if err := client.Decr(dao.Ctx, key).Err(); err != nil {
+		log.Error("DecrRegisterCount redis Decr error", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
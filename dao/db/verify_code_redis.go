@@ -0,0 +1,89 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/log"
+)
+
+var (
+	ErrVerifyCodeNotFound = errors.New("verify code not found or expired")
+	ErrVerifyCodeMismatch = errors.New("verify code mismatch")
+	ErrVerifyCodeExceeded = errors.New("verify code attempts exceeded")
+)
+
+// SetVerifyCode 保存验证码哈希,覆盖同用途未使用的旧验证码
+func SetVerifyCode(client redis.UniversalClient, purpose, email, codeHash string) error {
+	key := fmt.Sprintf(dao.VerifyCodeKey, purpose, email)
+	_, err := client.TxPipelined(dao.Ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(dao.Ctx, key)
+		pipe.HSet(dao.Ctx, key, "code_hash", codeHash, "attempts", 0)
+		pipe.Expire(dao.Ctx, key, dao.VerifyCodeExpiration)
+		return nil
+	})
+	if err != nil {
+		log.Error("SetVerifyCode redis TxPipelined error", zap.String("purpose", purpose), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ConsumeVerifyCode 校验并消耗验证码
+// 每次校验先累加尝试次数,超过上限后验证码作废;校验成功后删除,并发校验时仅一次成功
+func ConsumeVerifyCode(client redis.UniversalClient, purpose, email, codeHash string) error {
+	key := fmt.Sprintf(dao.VerifyCodeKey, purpose, email)
+	storedHash, err := client.HGet(dao.Ctx, key, "code_hash").Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return ErrVerifyCodeNotFound
+		}
+		log.Error("ConsumeVerifyCode redis HGet error", zap.String("purpose", purpose), zap.Error(err))
+		return err
+	}
+	attempts, err := client.HIncrBy(dao.Ctx, key, "attempts", 1).Result()
+	if err != nil {
+		log.Error("ConsumeVerifyCode redis HIncrBy error", zap.String("purpose", purpose), zap.Error(err))
+		return err
+	}
+	if attempts > dao.VerifyCodeMaxAttempts {
+		client.Del(dao.Ctx, key)
+		return ErrVerifyCodeExceeded
+	}
+	if storedHash != codeHash {
+		if attempts == dao.VerifyCodeMaxAttempts {
+			client.Del(dao.Ctx, key)
+			return ErrVerifyCodeExceeded
+		}
+		return ErrVerifyCodeMismatch
+	}
+	deleted, err := client.Del(dao.Ctx, key).Result()
+	if err != nil {
+		log.Error("ConsumeVerifyCode redis Del error", zap.String("purpose", purpose), zap.Error(err))
+		return err
+	}
+	if deleted == 0 {
+		return ErrVerifyCodeNotFound
+	}
+	return nil
+}
+
+// AcquireVerifyCodeCooldown 获取重发冷却,冷却期内返回false
+func AcquireVerifyCodeCooldown(client redis.UniversalClient, purpose, email string) (bool, error) {
+	return client.SetNX(dao.Ctx, fmt.Sprintf(dao.VerifyCodeCdKey, purpose, email), 1, dao.VerifyCodeCooldown).Result()
+}
+
+// IncrVerifyCodeLimit 累加窗口内发送次数,窗口从首次发送开始计算
+func IncrVerifyCodeLimit(client redis.UniversalClient, key string) (int64, error) {
+	count, err := client.Incr(dao.Ctx, key).Result()
+	if err != nil {
+		log.Error("IncrVerifyCodeLimit redis Incr error", zap.String("key", key), zap.Error(err))
+		return 0, err
+	}
+	if count == 1 {
+		client.Expire(dao.Ctx, key, dao.VerifyCodeLimitWindow)
+	}
+	return count, nil
+}
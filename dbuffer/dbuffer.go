@@ -1,14 +1,15 @@
 package dbuffer
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"ppt/login/db"
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
 	"sync"
 )
 
-var ctx = context.Background()
-
 const (
 	UUID_TYPE_ITEMID = iota
 	UUID_TYPE_PETID
@@ -17,18 +18,29 @@ const (
 
 var DBuffer *DoubleBuffer
 
+var (
+	ErrFunctionTypeUnknown = errors.New("unknown function id type")
+	ErrFunctionIDNotReady  = errors.New("function id segment not ready")
+)
+
+// SegmentAllocator 分配新号段,返回号段起始ID及长度
+type SegmentAllocator func(idType int) (int64, int64, error)
+
 type BasicID struct {
 	MaxID int64
 	Step  int64
 }
 
+// FunctionUUID 单类型双buffer号段,全部字段在Mutex保护下读写
 type FunctionUUID struct {
 	Type        int
 	Buffer1     BasicID
 	Buffer2     BasicID
 	UseBuffer1  bool
 	Offset      int64
-	IsNewIDSync bool
+	IsNewIDSync bool // 备用号段已就绪
+	isSyncing   bool // 备用号段分配中
+	alloc       SegmentAllocator
 	sync.Mutex
 	syncMutex sync.Mutex
 }
@@ -37,18 +49,35 @@ type DoubleBuffer struct {
 	Functions []*FunctionUUID
 }
 
-func InitDBuffer() {
-	DBuffer = &DoubleBuffer{
+func InitDBuffer() error {
+	buffer, err := NewDoubleBuffer(getFunctionMaxID)
+	if err != nil {
+		return err
+	}
+	DBuffer = buffer
+	return nil
+}
+
+// NewDoubleBuffer 按类型初始化双buffer号段,alloc用于分配号段
+func NewDoubleBuffer(alloc SegmentAllocator) (*DoubleBuffer, error) {
+	buffer := &DoubleBuffer{
 		Functions: []*FunctionUUID{},
 	}
 	for idType := UUID_TYPE_ITEMID; idType < UUID_TYPE_MAX; idType++ {
-		DBuffer.Functions = append(DBuffer.Functions, initFunctionID(idType))
+		function, err := initFunctionID(idType, alloc)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Functions = append(buffer.Functions, function)
 	}
+	return buffer, nil
 }
 
-func initFunctionID(id int) *FunctionUUID {
-	idType := formatFunctionType(id)
-	maxID, step := db.GetFunctionMaxID(idType)
+func initFunctionID(id int, alloc SegmentAllocator) (*FunctionUUID, error) {
+	maxID, step, err := alloc(id)
+	if err != nil {
+		return nil, err
+	}
 	return &FunctionUUID{
 		Type: id,
 		Buffer1: BasicID{
@@ -56,69 +85,110 @@ func initFunctionID(id int) *FunctionUUID {
 			Step:  step,
 		},
 		UseBuffer1: true,
+		alloc:      alloc,
+	}, nil
+}
+
+// getFunctionMaxID 从Redis分配新号段
+func getFunctionMaxID(id int) (int64, int64, error) {
+	maxID, err := db.AllocFunctionIDSegment(dao.RedisDB, formatFunctionType(id), dao.FunctionIDStep)
+	if err != nil {
+		return 0, 0, err
 	}
+	return maxID, dao.FunctionIDStep, nil
 }
 
 func formatFunctionType(idType int) string {
 	return fmt.Sprintf("global_uuid_%d", idType)
 }
 
-func GetUUIDByType(id int) (newID int64) {
-	var useFunction *FunctionUUID
-	var curStep int64
-	var isSwitch bool
-	for _, function := range DBuffer.Functions {
-		if function.Type != id {
-			continue
+func GetUUIDByType(id int) (int64, error) {
+	return DBuffer.GetUUID(id)
+}
+
+// GetUUID 从当前号段取号,用完时切换至备用号段;备用号段未就绪时返回ErrFunctionIDNotReady,不复用已用号段
+func (b *DoubleBuffer) GetUUID(id int) (int64, error) {
+	var function *FunctionUUID
+	for _, f := range b.Functions {
+		if f.Type == id {
+			function = f
+			break
 		}
+	}
+	if function == nil {
+		return 0, fmt.Errorf("%w: %d", ErrFunctionTypeUnknown, id)
+	}
 
-		function.Lock()
-		if function.UseBuffer1 {
-			newID = function.Buffer1.MaxID + function.Offset
-			curStep = function.Buffer1.Step
-			if newID >= function.Buffer1.MaxID+function.Buffer1.Step-1 {
-				isSwitch = true
-				curStep = function.Buffer2.Step
-			}
-		} else {
-			newID = function.Buffer2.MaxID + function.Offset
-			curStep = function.Buffer2.Step
-			if newID >= function.Buffer2.MaxID+function.Buffer2.Step-1 {
-				isSwitch = true
-				curStep = function.Buffer1.Step
+	function.Lock()
+	current, standby := function.buffers()
+	if function.Offset >= current.Step {
+		if !function.IsNewIDSync {
+			startSync := function.startSync()
+			function.Unlock()
+			if startSync {
+				go SyncNewID(function)
 			}
+			return 0, fmt.Errorf("%w: %d", ErrFunctionIDNotReady, id)
 		}
-		if isSwitch {
-			function.UseBuffer1 = !function.UseBuffer1
-			function.Offset = 0
-			function.IsNewIDSync = false
-		} else {
-			function.Offset++
-		}
-		function.Unlock()
+		function.UseBuffer1 = !function.UseBuffer1
+		function.Offset = 0
+		function.IsNewIDSync = false
+		current = standby
+	}
+	newID := current.MaxID + function.Offset
+	function.Offset++
+	startSync := function.Offset >= current.Step/2 && function.startSync()
+	function.Unlock()
+
+	if startSync {
+		go SyncNewID(function)
+	}
+	return newID, nil
+}
 
-		useFunction = function
-		break
+// buffers 返回当前及备用号段,调用方需持有锁
+func (f *FunctionUUID) buffers() (*BasicID, *BasicID) {
+	if f.UseBuffer1 {
+		return &f.Buffer1, &f.Buffer2
 	}
-	if useFunction.Offset >= (curStep/2) && !useFunction.IsNewIDSync {
-		go SyncNewID(useFunction)
+	return &f.Buffer2, &f.Buffer1
+}
+
+// startSync 备用号段未就绪且未在分配时标记为分配中,调用方需持有锁
+func (f *FunctionUUID) startSync() bool {
+	if f.IsNewIDSync || f.isSyncing {
+		return false
 	}
-	return
+	f.isSyncing = true
+	return true
 }
 
+// SyncNewID 为备用号段分配新号段;备用号段就绪前不会切换,分配期间无需持有锁
 func SyncNewID(function *FunctionUUID) {
 	function.syncMutex.Lock()
 	defer function.syncMutex.Unlock()
-	if !function.IsNewIDSync {
-		idType := formatFunctionType(function.Type)
-		maxID, step := db.GetFunctionMaxID(idType)
-		if function.UseBuffer1 {
-			function.Buffer2.MaxID = maxID
-			function.Buffer2.Step = step
-		} else {
-			function.Buffer1.MaxID = maxID
-			function.Buffer1.Step = step
-		}
-		function.IsNewIDSync = true
+
+	function.Lock()
+	synced := function.IsNewIDSync
+	if synced {
+		function.isSyncing = false
+	}
+	function.Unlock()
+	if synced {
+		return
+	}
+	maxID, step, err := function.alloc(function.Type)
+
+	function.Lock()
+	defer function.Unlock()
+	function.isSyncing = false
+	if err != nil {
+		// 下次取号时重试
+		log.Error("SyncNewID alloc segment error", zap.Int("type", function.Type), zap.Error(err))
+		return
 	}
+	_, standby := function.buffers()
+	standby.MaxID = maxID
+	standby.Step = step
+	function.IsNewIDSync = true
 }
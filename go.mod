@@ -44,7 +44,9 @@ require (
 	github.com/panjf2000/ants/v2 v2.11.2
 	github.com/pquerna/otp v1.5.0
 	github.com/prometheus/client_golang v1.23.0
+	github.com/robfig/cron/v3 v3.0.1
 	go.uber.org/zap v1.27.0
+	golang.org/x/crypto v0.38.0
 	gorm.io/datatypes v1.2.5
 	gorm.io/driver/clickhouse v0.6.1
 	gorm.io/driver/postgres v1.5.9
@@ -134,7 +136,6 @@ require (
 	github.com/prometheus/common v0.65.0 // indirect
 	github.com/prometheus/procfs v0.16.1 // indirect
 	github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475 // indirect
-	github.com/robfig/cron/v3 v3.0.1 // indirect
 	github.com/segmentio/asm v1.2.0 // indirect
 	github.com/shiena/ansicolor v0.0.0-20151119151921-a422bbe96644 // indirect
 	github.com/shopspring/decimal v1.4.0 // indirect
@@ -150,7 +151,6 @@ require (
 	go.opentelemetry.io/otel/metric v1.35.0 // indirect
 	go.opentelemetry.io/otel/trace v1.35.0 // indirect
 	golang.org/x/arch v0.0.0-20210923205945-b76863e36670 // indirect
-	golang.org/x/crypto v0.38.0 // indirect
 	golang.org/x/net v0.40.0 // indirect
 	golang.org/x/sync v0.14.0 // indirect
 	golang.org/x/time v0.8.0 // indirect
@@ -138,6 +138,16 @@ func (sara *SaramaAsyncClient) SendMessage(key, value []byte) {
 	return
 }
 
+// SendTopicMessage 异步发送消息至指定topic
+func (sara *SaramaAsyncClient) SendTopicMessage(topic string, key, value []byte) {
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.ByteEncoder(key),
+		Value: sarama.ByteEncoder(value),
+	}
+	sara.producer.Input() <- msg
+}
+
 func (sara *SaramaAsyncClient) Close() {
 	if sara.producer != nil {
 		sara.producer.Close()
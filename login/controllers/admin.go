@@ -1,21 +1,174 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 	"net/http"
+	"ppt/log"
+	"ppt/middleware"
+	"ppt/model"
+	"ppt/service"
 	"ppt/util"
+	"strconv"
 )
 
+const (
+	adminPageSizeDefault = 20
+	adminPageSizeMax     = 100
+)
+
+// AdminHandler 后台账号及角色管理,首个管理员需直接写入admin_principal表
 func AdminHandler(r *gin.Engine) {
 	admin := r.Group("/admin")
 	{
-		admin.Use(util.AuthMiddleware())
+		admin.Use(middleware.AdminAuth()...)
 		admin.POST("/", AdminMain)
+		admin.POST("/role/save", middleware.RequirePermission(model.AdminPermAdminManage), AdminRoleSaveHandler)
+		admin.POST("/role/delete", middleware.RequirePermission(model.AdminPermAdminManage), AdminRoleDeleteHandler)
+		admin.POST("/role/list", middleware.RequirePermission(model.AdminPermAdminManage), AdminRoleListHandler)
+		admin.POST("/principal/save", middleware.RequirePermission(model.AdminPermAdminManage), AdminPrincipalSaveHandler)
+		admin.POST("/principal/list", middleware.RequirePermission(model.AdminPermAdminManage), AdminPrincipalListHandler)
+		admin.POST("/audit/list", middleware.RequirePermission(model.AdminPermAdminAuditLog), AdminAuditListHandler)
 	}
 }
 
+type AdminRoleSave struct {
+	Name        string   `json:"name" binding:"required"`
+	Permissions []string `json:"permissions" binding:"required"`
+	Description string   `json:"description"`
+}
+
+type AdminRoleDelete struct {
+	Name string `form:"name" json:"name" binding:"required"`
+}
+
+type AdminPrincipalSave struct {
+	UserID uint64                     `json:"user_id" binding:"required"`
+	Roles  []string                   `json:"roles"`
+	Status model.AdminPrincipalStatus `json:"status"`
+}
+
+type AdminAuditList struct {
+	OperatorID uint64 `form:"operator_id" json:"operator_id"`
+	Target     string `form:"target" json:"target"`
+	Page       int    `form:"page" json:"page"`
+	Size       int    `form:"size" json:"size"`
+}
+
+// AdminMain 当前后台账号的角色及权限
 func AdminMain(c *gin.Context) {
+	access, _ := middleware.GetContextAdminAccess(c)
+	permissions := make([]string, 0, len(access.Permissions))
+	for perm := range access.Permissions {
+		permissions = append(permissions, perm)
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"Data": "This is admin page.",
+		"user_id":     access.UserID,
+		"roles":       access.Roles,
+		"permissions": permissions,
 	})
 }
+
+func AdminRoleSaveHandler(c *gin.Context) {
+	var req AdminRoleSave
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, "role:"+req.Name)
+	role, err := service.SaveAdminRole(req.Name, req.Permissions, req.Description)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": role})
+}
+
+func AdminRoleDeleteHandler(c *gin.Context) {
+	var req AdminRoleDelete
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, "role:"+req.Name)
+	if err := service.DeleteAdminRole(req.Name); err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": req.Name})
+}
+
+func AdminRoleListHandler(c *gin.Context) {
+	roles, err := service.GetAdminRoles()
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func AdminPrincipalSaveHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req AdminPrincipalSave
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	middleware.SetAuditTarget(c, "user:"+strconv.FormatUint(req.UserID, 10))
+	principal, err := service.SaveAdminPrincipal(req.UserID, req.Roles, req.Status, claims.UserID, claims.Name)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"principal": principal})
+}
+
+func AdminPrincipalListHandler(c *gin.Context) {
+	principals, err := service.GetAdminPrincipals()
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"principals": principals})
+}
+
+func AdminAuditListHandler(c *gin.Context) {
+	var req AdminAuditList
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = adminPageSizeDefault
+	}
+	if req.Size > adminPageSizeMax {
+		req.Size = adminPageSizeMax
+	}
+	auditLogs, total, err := service.GetAdminAuditLogsByPage(req.OperatorID, req.Target, req.Page, req.Size)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"logs": auditLogs, "total": total})
+}
+
+func writeAdminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrAdminRoleInvalid), errors.Is(err, service.ErrAdminPermissionInvalid),
+		errors.Is(err, service.ErrAdminPrincipalInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAdminRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		log.Error("admin operation error", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "admin operation failed"})
+	}
+}
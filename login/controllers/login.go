@@ -1,27 +1,42 @@
 package controllers
 
 import (
-	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"net/http"
-	"ppt/dao"
-	commonDB "ppt/dao/db"
 	"ppt/log"
-	"ppt/login/db"
+	"ppt/service"
 	"ppt/util"
 )
 
-var ctx = context.Background()
-
 func LoginHandler(r *gin.Engine) {
 	acc := r.Group("/account")
 	{
 		acc.GET("/login", LoginGetHandler)
 		acc.POST("/registry", AccRegistryHandler)
 		acc.POST("/login", AccLoginHandler)
+		acc.POST("/login/2fa", AccLoginMfaHandler)
+		acc.POST("/refresh", AccRefreshHandler)
+		acc.POST("/logout", AccLogoutHandler)
+		acc.POST("/email/verify/send", EmailVerifySendHandler)
+		acc.POST("/email/verify", EmailVerifyHandler)
+		acc.POST("/password/forgot", PasswordForgotHandler)
+		acc.POST("/password/reset", PasswordResetHandler)
+	}
+	session := acc.Group("/session")
+	{
+		session.Use(util.AuthMiddleware())
+		session.POST("/list", SessionListHandler)
+		session.POST("/revoke", SessionRevokeHandler)
+		session.POST("/revoke_all", SessionRevokeAllHandler)
+	}
+	totp := acc.Group("/2fa")
+	{
+		totp.Use(util.AuthMiddleware())
+		totp.POST("/enroll", TotpEnrollHandler)
+		totp.POST("/confirm", TotpConfirmHandler)
+		totp.POST("/disable", TotpDisableHandler)
 	}
 }
 
@@ -30,9 +45,13 @@ func LoginGetHandler(c *gin.Context) {
 }
 
 type UserRegistration struct {
-	Name  string `form:"name" json:"name" binding:"required"`
-	Pass  string `form:"pass" json:"pass" binding:"required"`
-	Email string `form:"email" json:"email" binding:"required"`
+	Name    string `form:"name" json:"name" binding:"required"`
+	Pass    string `form:"pass" json:"pass" binding:"required"`
+	Email   string `form:"email" json:"email" binding:"required"`
+	BrandID int32  `form:"brand_id" json:"brand_id"`
+	Channel string `form:"channel" json:"channel"`
+	Lang    string `form:"lang" json:"lang"`
+	Device  string `form:"device_id" json:"device_id"` // 设备指纹,为空时读取X-Device-ID请求头
 }
 
 func AccRegistryHandler(c *gin.Context) {
@@ -42,64 +61,151 @@ func AccRegistryHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	existed, err := db.WhetherUserNameRegistered(userReg.Name)
+	device := userReg.Device
+	if device == "" {
+		device = c.GetHeader("X-Device-ID")
+	}
+	user, err := service.RegisterAccount(&service.AccountRegisterParam{
+		Name:     userReg.Name,
+		Password: userReg.Pass,
+		Email:    userReg.Email,
+		BrandID:  userReg.BrandID,
+		Channel:  userReg.Channel,
+		Lang:     userReg.Lang,
+		IP:       c.ClientIP(),
+		Device:   device,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeAccountError(c, err)
 		return
 	}
-	if !existed {
-		log.Info("AccRegistryHandler user name", zap.String("name", userReg.Name), zap.String("email", userReg.Email))
-		c.JSON(http.StatusOK, gin.H{"regResponse": "Acc has already registered"})
+	c.JSON(http.StatusOK, gin.H{"account": service.NewAccountInfo(user)})
+}
+
+func writeAccountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrAccountNameInvalid), errors.Is(err, service.ErrAccountEmailInvalid),
+		errors.Is(err, service.ErrAccountPasswordInvalid), errors.Is(err, service.ErrAccountParamInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAccountNameExists), errors.Is(err, service.ErrAccountEmailExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAccountLoginFailed), errors.Is(err, service.ErrRefreshTokenInvalid),
+		errors.Is(err, service.ErrMfaChallengeInvalid), errors.Is(err, service.ErrTotpCodeInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTotpNotEnabled), errors.Is(err, service.ErrTotpNotEnrolled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTotpAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRegisterDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRegisterLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrVerifyCodeInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTotpLocked), errors.Is(err, service.ErrVerifyCodeTooFrequent):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMailerUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		log.Error("account operation error", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "account operation failed"})
+	}
+}
+
+type AccountLogin struct {
+	Name   string `form:"name" json:"name" binding:"required"`
+	Pass   string `form:"pass" json:"pass" binding:"required"`
+	Device string `form:"device" json:"device"` // 设备名称,为空时使用User-Agent
+}
+
+type AccountLoginMfa struct {
+	MfaToken     string `form:"mfa_token" json:"mfa_token" binding:"required"`
+	Code         string `form:"code" json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `form:"recovery_code" json:"recovery_code"`
+	Device       string `form:"device" json:"device"`
+}
+
+type AccountRefresh struct {
+	RefreshToken string `form:"refresh_token" json:"refresh_token" binding:"required"`
+}
+
+func AccLoginHandler(c *gin.Context) {
+	var accLogin AccountLogin
+	if err := c.ShouldBind(&accLogin); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	err = db.RegUserName(userReg.Name)
+	result, err := service.LoginAccount(accLogin.Name, accLogin.Pass, newLoginClient(c, accLogin.Device))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeAccountError(c, err)
 		return
 	}
-	userID, err := commonDB.GenerateUserID(dao.RedisDB)
+	writeLoginResult(c, result)
+}
+
+func AccLoginMfaHandler(c *gin.Context) {
+	var req AccountLoginMfa
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := service.LoginAccountMfa(req.MfaToken, req.Code, req.RecoveryCode, newLoginClient(c, req.Device))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "user_id is invalid"})
+		writeAccountError(c, err)
 		return
 	}
-	if UserID := db.GenerateUserID(); UserID > 0 {
+	writeLoginResult(c, result)
+}
+
+func newLoginClient(c *gin.Context, device string) *service.LoginClient {
+	if device == "" {
+		device = c.GetHeader("User-Agent")
+	}
+	return &service.LoginClient{Device: device, IP: c.ClientIP()}
+}
+
+// writeLoginResult 开启两步验证的账号仅返回mfa_token
+func writeLoginResult(c *gin.Context, result *service.LoginResult) {
+	if result.MfaToken != "" {
 		c.JSON(http.StatusOK, gin.H{
-			"loginResult": "Welcome aboard " + userReg.Name,
-			"userID":      UserID,
+			"mfa_required": true,
+			"mfa_token":    result.MfaToken,
 		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"loginResult": "Sorry, something went wrong ",
-		"userID":      userID,
+		"account": service.NewAccountInfo(result.User),
+		"tokens":  result.Tokens,
 	})
 }
 
-type PlayerLogin struct {
-	Name string `form:"name" json:"name" binding:"required"`
-	ID   int64  `form:"id" json:"id" binding:"required"`
+func AccRefreshHandler(c *gin.Context) {
+	var req AccountRefresh
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := service.RefreshAuthTokens(req.RefreshToken)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
 }
 
-func AccLoginHandler(c *gin.Context) {
-	var playerLogin PlayerLogin
-	if err := c.ShouldBind(&playerLogin); err != nil {
+func AccLogoutHandler(c *gin.Context) {
+	var req AccountRefresh
+	if err := c.ShouldBind(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-	}
-	_, err := db.GetRedis().HGet(ctx, "table_acc", playerLogin.Name).Result()
-	if errors.Is(err, redis.Nil) {
-		c.JSON(http.StatusOK, gin.H{
-			"loginResult": "Not registry",
-		})
 		return
-	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	// access token可选,携带有效token时一并吊销
+	accessClaims, _ := util.ParseBearerToken(c)
+	if err := service.LogoutAccount(req.RefreshToken, accessClaims); err != nil {
+		writeAccountError(c, err)
 		return
 	}
-	//token := util.FormatTokenKey(playerLogin.Name)
-	token, _ := util.GenerateToken(playerLogin.ID, playerLogin.Name)
-	db.UpdateToken(playerLogin.ID, token)
-	c.JSON(http.StatusOK, gin.H{
-		"loginResult": "Welcome back " + playerLogin.Name,
-		"token":       token,
-	})
+	c.JSON(http.StatusOK, gin.H{})
 }
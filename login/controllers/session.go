@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ppt/service"
+	"ppt/util"
+)
+
+type SessionRevoke struct {
+	SessionID string `form:"session_id" json:"session_id" binding:"required"`
+}
+
+type SessionRevokeAll struct {
+	KeepCurrent bool `form:"keep_current" json:"keep_current"` // 是否保留当前会话
+}
+
+func SessionListHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	sessions, err := service.GetUserSessions(claims.UserID, claims.SessionID)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func SessionRevokeHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req SessionRevoke
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := service.RevokeUserSession(userID, req.SessionID); err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"session_id": req.SessionID})
+}
+
+func SessionRevokeAllHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req SessionRevokeAll
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	keepSessionID := ""
+	if req.KeepCurrent {
+		keepSessionID = claims.SessionID
+	}
+	revoked, err := service.RevokeUserSessions(claims.UserID, keepSessionID)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
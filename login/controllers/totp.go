@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ppt/service"
+	"ppt/util"
+)
+
+type TotpConfirmReq struct {
+	Code string `form:"code" json:"code" binding:"required"`
+}
+
+type TotpDisableReq struct {
+	Code         string `form:"code" json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `form:"recovery_code" json:"recovery_code"`
+}
+
+func TotpEnrollHandler(c *gin.Context) {
+	claims, ok := util.GetContextClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	enrollment, err := service.EnrollTotp(claims.UserID, claims.Name)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enrollment": enrollment})
+}
+
+func TotpConfirmHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req TotpConfirmReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recoveryCodes, err := service.ConfirmTotp(userID, req.Code)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+func TotpDisableHandler(c *gin.Context) {
+	userID, ok := util.GetContextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	var req TotpDisableReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := service.DisableTotp(userID, req.Code, req.RecoveryCode); err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ppt/service"
+)
+
+type EmailVerifySend struct {
+	Email string `form:"email" json:"email" binding:"required"`
+}
+
+type EmailVerify struct {
+	Email string `form:"email" json:"email" binding:"required"`
+	Code  string `form:"code" json:"code" binding:"required"`
+}
+
+type PasswordReset struct {
+	Email   string `form:"email" json:"email" binding:"required"`
+	Code    string `form:"code" json:"code" binding:"required"`
+	NewPass string `form:"new_pass" json:"new_pass" binding:"required"`
+}
+
+// EmailVerifySendHandler 发送邮箱验证码,邮箱是否注册均返回成功
+func EmailVerifySendHandler(c *gin.Context) {
+	var req EmailVerifySend
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := service.SendAccountEmailVerify(req.Email, c.ClientIP()); err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func EmailVerifyHandler(c *gin.Context) {
+	var req EmailVerify
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := service.VerifyAccountEmail(req.Email, req.Code); err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// PasswordForgotHandler 发送找回密码验证码,邮箱是否注册均返回成功
+func PasswordForgotHandler(c *gin.Context) {
+	var req EmailVerifySend
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := service.RequestPasswordReset(req.Email, c.ClientIP()); err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func PasswordResetHandler(c *gin.Context) {
+	var req PasswordReset
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := service.ResetAccountPassword(req.Email, req.Code, req.NewPass); err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"errors"
+	"fmt"
 	_ "github.com/astaxie/beego/cache"
 	_ "github.com/astaxie/beego/cache/redis"
 	_ "github.com/go-sql-driver/mysql"
@@ -14,31 +15,7 @@ import (
 	"time"
 )
 
-// RegAccountInfo 注册用户账号
-func RegAccountInfo(name string, user model.User) error {
-	//user.AccId = get_account_id()
-	// set cache info & mark registered
-	//SetLoginCache(user)
-	//redisCache.Command("HSET", regHash, name, 1)
-	//_, insertErr := o.Insert(&user)
-	//if insertErr != nil {
-	//	fmt.Println("mys insert err, ", insertErr)
-	//}
-
-	var err error
-	now := time.Now().UnixMilli()
-	exists := false
-	if exists, err = dao.RedisDB.HSetNX(dao.Ctx, dao.UserNameRegisterKey, name, now).Result(); err != nil {
-		log.Error("SetAccountInfo HSetNX user name error", zap.Uint64("user_id", user.UserID), zap.String("user_name", name))
-		return err
-	}
-	if !exists {
-		log.Warn("SetAccountInfo HSetNX user name already exists", zap.Uint64("user_id", user.UserID), zap.String("user_name", name))
-		return errors.New("user name already exists")
-	}
-
-	return nil
-}
+var ErrUserNameExists = errors.New("user name already exists")
 
 // WhetherUserNameRegistered 用户Name是否已注册(true-已注册,false-未注册)
 func WhetherUserNameRegistered(name string) (bool, error) {
@@ -50,29 +27,24 @@ func WhetherUserNameRegistered(name string) (bool, error) {
 	return exists, nil
 }
 
-// RegUserName 注册账户名
+// RegUserName 占用账户名,已被占用时返回ErrUserNameExists
 func RegUserName(name string) error {
-	tx := func(tx *redis.Tx) error {
-		exists, err := tx.SIsMember(dao.Ctx, dao.UserNameRegisterKey, name).Result()
-		if err != nil {
-			log.Error("RegUserName SIsMember error", zap.Error(err))
-			return err
-		}
-		if exists {
-			log.Warn("RegUserName SIsMember already exists", zap.String("user_name", name))
-			return errors.New("user name already exists")
-		}
-		_, err = tx.SAdd(dao.Ctx, dao.UserNameRegisterKey, name).Result()
-		if err != nil {
-			log.Error("RegUserName SAdd  error", zap.String("user_name", name), zap.Error(err))
-			return err
-		}
-		return nil
+	added, err := dao.RedisDB.SAdd(dao.Ctx, dao.UserNameRegisterKey, name).Result()
+	if err != nil {
+		log.Error("RegUserName SAdd error", zap.String("user_name", name), zap.Error(err))
+		return err
 	}
+	if added == 0 {
+		log.Warn("RegUserName user name already exists", zap.String("user_name", name))
+		return ErrUserNameExists
+	}
+	return nil
+}
 
-	err := dao.RedisDB.Watch(dao.Ctx, tx, dao.UserNameRegisterKey)
-	if err != nil {
-		log.Error("RegUserName Watch error", zap.String("user_name", name), zap.Error(err))
+// UnregUserName 释放账户名(注册失败时回滚)
+func UnregUserName(name string) error {
+	if err := dao.RedisDB.SRem(dao.Ctx, dao.UserNameRegisterKey, name).Err(); err != nil {
+		log.Error("UnregUserName SRem error", zap.String("user_name", name), zap.Error(err))
 		return err
 	}
 	return nil
@@ -84,6 +56,11 @@ func SetUserCache(user model.User) error {
 	return nil
 }
 
+// DelUserCache 删除用户缓存,用户数据变更后调用
+func DelUserCache(userID uint64) {
+	cache.UserCache.Delete(userID)
+}
+
 // GetUserCache 获取用户缓存
 func GetUserCache(userID uint64) (*model.User, error) {
 	userAny, err := cache.UserCache.Get(userID)
@@ -91,6 +68,47 @@ func GetUserCache(userID uint64) (*model.User, error) {
 		log.Error("GetUserCache Get user cache error", zap.Uint64("user_id", userID), zap.Error(err))
 		return nil, err
 	}
-	userCache := userAny.(model.User)
-	return &userCache, nil
+	switch userCache := userAny.(type) {
+	case *model.User:
+		return userCache, nil
+	case model.User:
+		return &userCache, nil
+	}
+	log.Error("GetUserCache user cache type assertion error", zap.Uint64("user_id", userID), zap.Any("user_cache", userAny))
+	return nil, errors.New("user cache type assertion error")
+}
+
+// RevokeAccessToken 吊销access token,记录保留至token过期
+func RevokeAccessToken(tokenID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	if err := dao.RedisDB.Set(dao.Ctx, fmt.Sprintf(dao.TokenRevokedKey, tokenID), time.Now().UnixMilli(), ttl).Err(); err != nil {
+		log.Error("RevokeAccessToken redis Set error", zap.String("token_id", tokenID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked access token已吊销或所属会话已注销时返回true
+// 会话有效时按间隔刷新会话最近活跃时间,seenTTL与会话有效期一致
+func IsAccessTokenRevoked(tokenID, sessionID string, seenTTL time.Duration) (bool, error) {
+	seenKey := fmt.Sprintf(dao.UserSessionSeenKey, sessionID)
+	pipe := dao.RedisDB.Pipeline()
+	revokedCmd := pipe.Exists(dao.Ctx, fmt.Sprintf(dao.TokenRevokedKey, tokenID))
+	sessionCmd := pipe.Exists(dao.Ctx, fmt.Sprintf(dao.UserSessionKey, sessionID))
+	seenCmd := pipe.Get(dao.Ctx, seenKey)
+	if _, err := pipe.Exec(dao.Ctx); err != nil && !errors.Is(err, redis.Nil) {
+		log.Error("IsAccessTokenRevoked redis pipe exec error", zap.String("token_id", tokenID), zap.String("session_id", sessionID), zap.Error(err))
+		return false, err
+	}
+	if revokedCmd.Val() > 0 || sessionCmd.Val() == 0 {
+		return true, nil
+	}
+	now := time.Now()
+	lastSeen, _ := seenCmd.Int64()
+	if now.Sub(time.UnixMilli(lastSeen)) >= dao.UserSessionSeenInterval {
+		dao.RedisDB.Set(dao.Ctx, seenKey, now.UnixMilli(), seenTTL)
+	}
+	return false, nil
 }
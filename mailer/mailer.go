@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Message 待发送邮件(纯文本)
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 邮件发送接口
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+var defaultMailer atomic.Value
+
+// SetMailer 设置全局邮件发送实现
+func SetMailer(m Mailer) {
+	defaultMailer.Store(&m)
+}
+
+// GetMailer 获取全局邮件发送实现,未设置时返回nil
+func GetMailer() Mailer {
+	m, _ := defaultMailer.Load().(*Mailer)
+	if m == nil {
+		return nil
+	}
+	return *m
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMailer 内存邮件发送实现,仅记录邮件,仅用于测试
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(_ context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages 已发送的全部邮件
+func (m *MemoryMailer) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Message(nil), m.messages...)
+}
+
+// Last 发送给指定地址的最后一封邮件,不存在时返回nil
+func (m *MemoryMailer) Last(to string) *Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"ppt/config"
+	"strings"
+	"time"
+)
+
+const smtpDialTimeout = 10 * time.Second
+
+// SMTPMailer SMTP邮件发送实现
+type SMTPMailer struct {
+	cfg *config.SmtpConfig
+}
+
+func NewSMTPMailer(cfg *config.SmtpConfig) (*SMTPMailer, error) {
+	if cfg.Host == "" || cfg.Port == 0 || cfg.From == "" {
+		return nil, errors.New("smtp config requires host, port and from")
+	}
+	return &SMTPMailer{cfg: cfg}, nil
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") {
+		return fmt.Errorf("invalid recipient %q", msg.To)
+	}
+	addr := net.JoinHostPort(s.cfg.Host, fmt.Sprint(s.cfg.Port))
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	var err error
+	if s.cfg.TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.cfg.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !s.cfg.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err = client.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if s.cfg.UserName != "" {
+		if err = client.Auth(smtp.PlainAuth("", s.cfg.UserName, s.cfg.Password, s.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	if err = client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(BuildMessage(s.cfg.From, msg)); err != nil {
+		_ = w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// BuildMessage 生成RFC 5322格式的纯文本邮件内容
+func BuildMessage(from string, msg *Message) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + msg.To + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
@@ -1,25 +1,32 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/judwhite/go-svc"
 	"go.uber.org/zap"
 	"net/http"
+	"ppt/actor"
 	pptCache "ppt/cache"
 	"ppt/config"
 	"ppt/dao"
+	"ppt/dbuffer"
 	"ppt/kafka"
 	"ppt/log"
+	"ppt/mailer"
 	"ppt/monitor"
 	"ppt/mq"
 	"ppt/nacos/wrapper"
 	"ppt/router"
+	"ppt/service"
 	"ppt/timer"
+	"ppt/util"
 	"runtime/debug"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type WaitGroupWrapper struct {
@@ -63,11 +70,50 @@ func (s *program) Init(env svc.Environment) error {
 		return err
 	}
 
+	authCfg, err := wrapper.GetNacosAuthConfig()
+	if err != nil {
+		log.Error("GetNacosAuthConfig error", zap.Error(err))
+		return err
+	}
+
+	actorCfg, err := wrapper.GetNacosActorConfig()
+	if err != nil {
+		log.Error("GetNacosActorConfig error", zap.Error(err))
+		return err
+	}
+
+	if err = util.InitJwtKeys(&authCfg.Jwt); err != nil {
+		log.Error("ppt init jwt keys error", zap.Error(err))
+		return err
+	}
+
+	if err = util.InitTotpConfig(&authCfg.Totp); err != nil {
+		log.Error("ppt init totp config error", zap.Error(err))
+		return err
+	}
+
+	if err = service.InitRegisterGuard(&authCfg.Register); err != nil {
+		log.Error("ppt init register guard error", zap.Error(err))
+		return err
+	}
+
+	if smtpMailer, mailerErr := mailer.NewSMTPMailer(&authCfg.Smtp); mailerErr != nil {
+		// 未配置SMTP时不设置邮件发送实现,验证码相关接口返回503
+		log.Error("ppt init smtp mailer error, verify code mails disabled", zap.Error(mailerErr))
+	} else {
+		mailer.SetMailer(smtpMailer)
+	}
+
 	if err = dao.InitRedis(&dbCfg.RedisConfig); err != nil {
 		log.Error("ppt init redis error", zap.Error(err))
 		return err
 	}
 
+	if err = dbuffer.InitDBuffer(); err != nil {
+		log.Error("ppt init dbuffer error", zap.Error(err))
+		return err
+	}
+
 	if err = dao.InitPg(&dbCfg.PgConfig); err != nil {
 		log.Error("ppt init pg error", zap.Error(err))
 		return err
@@ -78,11 +124,21 @@ func (s *program) Init(env svc.Environment) error {
 		return err
 	}
 
+	if err = dao.InitClickHouse(&dbCfg.CKConfig); err != nil {
+		log.Error("ppt init clickhouse error", zap.Error(err))
+		return err
+	}
+
 	if err = pptCache.InitUserCache(); err != nil {
 		log.Error("ppt cache init user error", zap.Error(err))
 		return err
 	}
 
+	if err = pptCache.InitAdminCache(); err != nil {
+		log.Error("ppt cache init admin error", zap.Error(err))
+		return err
+	}
+
 	if err = kafka.InitKafkaSarama(&dbCfg.KafkaConfig); err != nil {
 		log.Error("ppt init kafka error", zap.Error(err))
 		return err
@@ -98,6 +154,14 @@ func (s *program) Init(env svc.Environment) error {
 		return err
 	}
 
+	service.InitMailService()
+
+	if err = actor.InitRoleActorManager(actorCfg, service.NewRoleStateStore()); err != nil {
+		log.Error("ppt init role actor manager error", zap.Error(err))
+		return err
+	}
+	service.InitRoleCluster(&actorCfg.Cluster)
+
 	if err = timer.InitTimer(); err != nil {
 		log.Error("ppt init timer error", zap.Error(err))
 		return err
@@ -130,12 +194,16 @@ func (s *program) Start() error {
 
 func (s *program) Stop() error {
 	s.httpServer.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	_ = actor.RoleCluster.Stop(ctx)
+	cancel()
 	mq.CloseAsynq()
 	mq.CloseAsynqServer()
 
 	dao.CloseRedis()
 	dao.ClosePg()
 	dao.CloseMongo()
+	dao.CloseClickHouse()
 	kafka.CloseSaramaKafka()
 	return nil
 }
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"ppt/dao"
+	"ppt/log"
+	"ppt/model"
+	"ppt/service"
+	"ppt/util"
+)
+
+const (
+	ContextKeyAdminAccess     = "AdminAccess"
+	ContextKeyAuditPermission = "AuditPermission"
+	ContextKeyAuditTarget     = "AuditTarget"
+)
+
+// AdminAuth 后台路由组通用中间件:认证、审计、两步验证及后台账号校验
+// 审计在认证之后执行,未通过两步验证或权限校验的请求同样记录
+func AdminAuth() []gin.HandlerFunc {
+	return []gin.HandlerFunc{util.AuthMiddleware(), AdminAudit(), util.RequireMFA(), RequireAdmin()}
+}
+
+// RequireAdmin 要求为启用的后台账号,需在AuthMiddleware之后使用
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := util.GetContextUserID(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+			return
+		}
+		access, err := service.GetAdminAccess(userID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "auth unavailable"})
+			return
+		}
+		if !access.IsAdmin() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Set(ContextKeyAdminAccess, access)
+		c.Next()
+	}
+}
+
+// RequirePermission 要求后台账号拥有指定权限,需在RequireAdmin之后使用
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ContextKeyAuditPermission, perm)
+		access, ok := GetContextAdminAccess(c)
+		if !ok || !access.HasPermission(perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied: " + perm})
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetContextAdminAccess 获取后台账号权限
+func GetContextAdminAccess(c *gin.Context) (*model.AdminAccess, bool) {
+	accessAny, exists := c.Get(ContextKeyAdminAccess)
+	if !exists {
+		return nil, false
+	}
+	access, ok := accessAny.(*model.AdminAccess)
+	return access, ok
+}
+
+// SetAuditTarget 设置审计日志的操作对象
+func SetAuditTarget(c *gin.Context, target string) {
+	c.Set(ContextKeyAuditTarget, target)
+}
+
+// AdminAudit 记录后台操作审计日志(操作人、权限、对象、请求内容及响应状态),需在AuthMiddleware之后使用
+func AdminAudit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := util.GetContextClaims(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		payload := readAuditPayload(c)
+		c.Next()
+
+		auditLog := &model.AdminAuditLog{
+			OperatorID:   claims.UserID,
+			OperatorName: claims.Name,
+			Permission:   c.GetString(ContextKeyAuditPermission),
+			Method:       c.Request.Method,
+			Path:         c.FullPath(),
+			Target:       c.GetString(ContextKeyAuditTarget),
+			Payload:      payload,
+			Status:       c.Writer.Status(),
+			IP:           c.ClientIP(),
+		}
+		if auditLog.Path == "" {
+			auditLog.Path = c.Request.URL.Path
+		}
+		if err := service.CreateAdminAuditLog(auditLog); err != nil {
+			log.Error("AdminAudit CreateAdminAuditLog error", zap.Any("audit_log", auditLog), zap.Error(err))
+		}
+	}
+}
+
+// readAuditPayload 读取请求内容后还原请求体;非JSON内容按字符串记录,超出上限时仅记录长度
+func readAuditPayload(c *gin.Context) []byte {
+	if c.Request.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) == 0 {
+		return nil
+	}
+	var payload any
+	switch {
+	case len(body) > dao.AdminAuditPayloadMax:
+		payload = gin.H{"truncated": true, "size": len(body)}
+	case json.Valid(body):
+		return body
+	default:
+		payload = string(body)
+	}
+	data, _ := json.Marshal(payload)
+	return data
+}
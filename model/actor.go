@@ -0,0 +1,22 @@
+package model
+
+import (
+	"encoding/json"
+)
+
+const (
+	ActorEnvelopeRequest = "request"
+	ActorEnvelopeReply   = "reply"
+)
+
+// ActorEnvelope 节点间转发的角色actor消息及回复
+type ActorEnvelope struct {
+	Kind      string          `json:"kind"`
+	RequestID string          `json:"request_id"`
+	RoleID    uint64          `json:"role_id"`
+	MsgType   string          `json:"msg_type,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	ReplyTo   string          `json:"reply_to,omitempty"` // 发起请求的节点ID
+	Deadline  int64           `json:"deadline,omitempty"` // 请求截止时间(毫秒),超过后不再处理
+}
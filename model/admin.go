@@ -0,0 +1,129 @@
+package model
+
+import (
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// 后台权限定义,格式为 资源:操作;"*"表示全部权限,"资源:*"表示资源下全部操作
+const (
+	AdminPermAll           = "*"
+	AdminPermMailSend      = "mail:send"     // 群发、定时、取消及撤销邮件
+	AdminPermMailRead      = "mail:read"     // 查看群发进度
+	AdminPermMailArchive   = "mail:archive"  // 归档邮件
+	AdminPermMailTemplate  = "mail:template" // 管理邮件模板
+	AdminPermCouponGrant   = "coupon:grant"  // 发放优惠券
+	AdminPermCouponManage  = "coupon:manage" // 管理优惠券模板
+	AdminPermAdminManage   = "admin:manage"  // 管理后台角色及账号
+	AdminPermAdminAuditLog = "admin:audit"   // 查看审计日志
+)
+
+type AdminPrincipalStatus int32
+
+const (
+	AdminPrincipalStatusDisabled AdminPrincipalStatus = iota // 0 = 已停用
+	AdminPrincipalStatusEnabled                              // 1 = 正常
+)
+
+// AdminRole 后台角色
+type AdminRole struct {
+	Name        string                      `gorm:"column:name;size:64;primaryKey" json:"name"`
+	Permissions datatypes.JSONSlice[string] `gorm:"column:permissions;type:jsonb;not null;comment:权限列表" json:"permissions"`
+	Description string                      `gorm:"column:description;size:255;not null;default:''" json:"description"`
+	CreatedAt   int64                       `gorm:"column:created_at;autoCreateTime:milli" json:"created_at"`
+	UpdatedAt   int64                       `gorm:"column:updated_at;autoUpdateTime:milli" json:"updated_at"`
+}
+
+func (AdminRole) TableName() string {
+	return "admin_role"
+}
+
+// AdminPrincipal 后台账号,关联玩家账号UserID
+type AdminPrincipal struct {
+	UserID    uint64                      `gorm:"column:user_id;type:bigint;primaryKey" json:"user_id"`
+	Roles     datatypes.JSONSlice[string] `gorm:"column:roles;type:jsonb;not null;comment:角色列表" json:"roles"`
+	Status    AdminPrincipalStatus        `gorm:"column:status;type:integer;not null;default:1" json:"status"`
+	Operator  string                      `gorm:"column:operator;size:255;not null;default:'';comment:最后修改人" json:"operator"`
+	CreatedAt int64                       `gorm:"column:created_at;autoCreateTime:milli" json:"created_at"`
+	UpdatedAt int64                       `gorm:"column:updated_at;autoUpdateTime:milli" json:"updated_at"`
+}
+
+func (AdminPrincipal) TableName() string {
+	return "admin_principal"
+}
+
+// AdminAuditLog 后台操作审计日志
+type AdminAuditLog struct {
+	BaseModel
+	OperatorID   uint64         `gorm:"column:operator_id;not null;index:idx_admin_audit_operator" json:"operator_id"`
+	OperatorName string         `gorm:"column:operator_name;size:255;not null" json:"operator_name"`
+	Permission   string         `gorm:"column:permission;size:64;not null;default:''" json:"permission"`
+	Method       string         `gorm:"column:method;size:16;not null" json:"method"`
+	Path         string         `gorm:"column:path;size:255;not null;index:idx_admin_audit_path" json:"path"`
+	Target       string         `gorm:"column:target;size:255;not null;default:'';index:idx_admin_audit_target;comment:操作对象" json:"target"`
+	Payload      datatypes.JSON `gorm:"column:payload;type:jsonb;comment:请求内容" json:"payload"`
+	Status       int            `gorm:"column:status;not null;comment:HTTP状态码" json:"status"`
+	IP           string         `gorm:"column:ip;size:64;not null;default:''" json:"ip"`
+}
+
+func (AdminAuditLog) TableName() string {
+	return "admin_audit_log"
+}
+
+// AdminAccess 后台账号生效的角色及权限
+type AdminAccess struct {
+	UserID      uint64
+	Roles       []string
+	Permissions map[string]struct{}
+}
+
+// IsAdmin 是否为启用的后台账号
+func (a *AdminAccess) IsAdmin() bool {
+	return a != nil && len(a.Roles) > 0
+}
+
+// HasPermission 是否拥有权限,支持"*"及"资源:*"通配
+func (a *AdminAccess) HasPermission(perm string) bool {
+	if a == nil {
+		return false
+	}
+	if _, ok := a.Permissions[AdminPermAll]; ok {
+		return true
+	}
+	if _, ok := a.Permissions[perm]; ok {
+		return true
+	}
+	if resource, _, found := strings.Cut(perm, ":"); found {
+		_, ok := a.Permissions[resource+":*"]
+		return ok
+	}
+	return false
+}
+
+func MigrateAdmin(db *gorm.DB) error {
+	return db.AutoMigrate(&AdminRole{}, &AdminPrincipal{}, &AdminAuditLog{})
+}
+
+// GetAdminAccess 获取后台账号生效的角色及权限,非后台账号或已停用时返回空角色
+func GetAdminAccess(pgDB *gorm.DB, userID uint64) (*AdminAccess, error) {
+	access := &AdminAccess{UserID: userID, Permissions: map[string]struct{}{}}
+	var principals []*AdminPrincipal
+	if err := pgDB.Where("user_id = ? and status = ?", userID, AdminPrincipalStatusEnabled).Limit(1).Find(&principals).Error; err != nil {
+		return nil, err
+	}
+	if len(principals) == 0 || len(principals[0].Roles) == 0 {
+		return access, nil
+	}
+	var roles []*AdminRole
+	if err := pgDB.Where("name in ?", []string(principals[0].Roles)).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		access.Roles = append(access.Roles, role.Name)
+		for _, perm := range role.Permissions {
+			access.Permissions[perm] = struct{}{}
+		}
+	}
+	return access, nil
+}
@@ -1,5 +1,7 @@
 package model
 
+import "encoding/json"
+
 type PptAsynqTaskType int32
 
 const (
@@ -11,4 +13,5 @@ const (
 type PptAsynqTask struct {
 	TaskID   string           `json:"task_id"`
 	TaskType PptAsynqTaskType `json:"task_type"`
+	Payload  json.RawMessage  `json:"payload,omitempty"`
 }
@@ -0,0 +1,23 @@
+package model
+
+// RefreshToken 服务端保存的refresh token
+// 每次刷新都会签发同一FamilyID下的新token,旧token标记为已轮换;已轮换token再次使用视为泄露,整条轮换链失效
+type RefreshToken struct {
+	UserID    uint64 `redis:"user_id" json:"user_id"`
+	Name      string `redis:"name" json:"name"`
+	FamilyID  string `redis:"family_id" json:"family_id"`
+	CreatedAt int64  `redis:"created_at" json:"created_at"`
+	MFA       bool   `redis:"mfa" json:"mfa"`         // 登录时是否通过两步验证
+	Rotated   int64  `redis:"rotated" json:"rotated"` // 大于0表示已被轮换
+}
+
+// UserSession 登录会话,每次登录创建,刷新token时沿用;会话删除后其下access token立即失效
+type UserSession struct {
+	SessionID string `redis:"session_id" json:"session_id"`
+	UserID    uint64 `redis:"user_id" json:"user_id"`
+	Device    string `redis:"device" json:"device"`
+	IP        string `redis:"ip" json:"ip"`
+	MFA       bool   `redis:"mfa" json:"mfa"`
+	CreatedAt int64  `redis:"created_at" json:"created_at"`
+	LastSeen  int64  `redis:"-" json:"last_seen"`
+}
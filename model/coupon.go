@@ -2,6 +2,8 @@ package model
 
 import (
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // CouponType 优惠券类型枚举
@@ -18,23 +20,128 @@ type CouponStatus int
 const (
 	CouponStatusAvailable   CouponStatus = iota * 100 // 0 = 可用
 	CouponStatusUsedSuccess                           // 100 = 成功使用
-	CouponStatusUsedFailed                            // 110 = 使用失败
-	CouponStatusExpired                               // 200 = 已过期
-	CouponStatusDeleted                               // 300 = 已删除
+	CouponStatusUsedFailed                            // 200 = 使用失败
+	CouponStatusExpired                               // 300 = 已过期
+	CouponStatusDeleted                               // 400 = 已删除
+)
+
+// CouponTemplateStatus 优惠券模板状态
+type CouponTemplateStatus int32
+
+const (
+	CouponTemplateStatusInUse   CouponTemplateStatus = 0  // 使用中
+	CouponTemplateStatusDeleted CouponTemplateStatus = 10 // 已删除
 )
 
 // UserCoupon 用户优惠券表结构
 type UserCoupon struct {
 	BaseModel
-	UserID       uint64       `gorm:"column:user_id;type:bigint;not null;primaryKey", json:"user_id"`
-	CouponID     uuid.UUID    `gorm:"column:coupon_id;type:uuid;not null;primaryKey", json:"coupon_id"`
-	CouponType   CouponType   `gorm:"column:coupon_type;type:integer;not null", json:"coupon_type"`
-	CouponStatus CouponStatus `gorm:"column:coupon_status;type:integer;not null;default:0", json:"coupon_status"`
-	ReceivedAt   int64        `gorm:"column:received_at;not null;", json:"received_at"`
-	ExpiredAt    int64        `gorm:"column:expired_at;not null", json:"expired_at"`
+	UserID       uint64       `gorm:"column:user_id;type:bigint;not null;primaryKey;uniqueIndex:idx_user_coupon_source" json:"user_id"`
+	CouponID     uuid.UUID    `gorm:"column:coupon_id;type:uuid;not null;primaryKey" json:"coupon_id"`
+	TemplateID   string       `gorm:"column:template_id;type:uuid;not null;default:'00000000-0000-0000-0000-000000000000';uniqueIndex:idx_user_coupon_source;comment:优惠券模板ID" json:"template_id"`
+	SourceID     string       `gorm:"column:source_id;size:64;not null;default:'';uniqueIndex:idx_user_coupon_source;comment:发放来源ID" json:"source_id"`
+	CouponType   CouponType   `gorm:"column:coupon_type;type:integer;not null" json:"coupon_type"`
+	CouponStatus CouponStatus `gorm:"column:coupon_status;type:integer;not null;default:0;index:idx_user_coupon_expire,priority:1" json:"coupon_status"`
+	ReceivedAt   int64        `gorm:"column:received_at;not null;" json:"received_at"`
+	ExpiredAt    int64        `gorm:"column:expired_at;not null;index:idx_user_coupon_expire,priority:2" json:"expired_at"`
+	OrderID      string       `gorm:"column:order_id;size:64;comment:使用订单ID" json:"order_id,omitempty"`
+	OrderAmount  int64        `gorm:"column:order_amount;not null;default:0;comment:使用订单金额" json:"order_amount,omitempty"`
+	Discount     int64        `gorm:"column:discount;not null;default:0;comment:优惠金额" json:"discount,omitempty"`
+	UsedAt       int64        `gorm:"column:used_at;not null;default:0;comment:使用时间" json:"used_at,omitempty"`
+	FailReason   string       `gorm:"column:fail_reason;comment:使用失败原因" json:"fail_reason,omitempty"`
+	Operator     string       `gorm:"column:operator;not null;default:'';comment:发放人" json:"operator"`
 }
 
 // TableName 设置表名
 func (UserCoupon) TableName() string {
 	return "user_coupons"
 }
+
+// MigrateUserCoupon 迁移用户优惠券表
+// 模板化之前发放的存量优惠券模板ID为空UUID,来源ID回填为优惠券ID,保证新增唯一索引在存量数据上可以创建
+func MigrateUserCoupon(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if migrator.HasTable(&UserCoupon{}) && !migrator.HasColumn(&UserCoupon{}, "SourceID") {
+		if err := migrator.AddColumn(&UserCoupon{}, "SourceID"); err != nil {
+			return err
+		}
+		if err := db.Model(&UserCoupon{}).Where("source_id = ?", "").UpdateColumn("source_id", gorm.Expr("coupon_id::text")).Error; err != nil {
+			return err
+		}
+	}
+	return db.AutoMigrate(&UserCoupon{})
+}
+
+// CouponTemplate 优惠券模板(门槛、优惠内容及有效期)
+type CouponTemplate struct {
+	BaseModel
+	Name       datatypes.JSON       `gorm:"not null;type:jsonb;column:name;comment:名称" json:"name"`
+	CouponType CouponType           `gorm:"not null;type:integer;column:coupon_type;comment:优惠券类型" json:"coupon_type"`
+	Threshold  int64                `gorm:"not null;default:0;column:threshold;comment:订单金额门槛" json:"threshold"`
+	Discount   int64                `gorm:"not null;default:0;column:discount;comment:满减金额" json:"discount"`
+	Gifts      datatypes.JSON       `gorm:"type:jsonb;column:gifts;comment:满赠奖励" json:"gifts"`
+	ValidStart int64                `gorm:"not null;default:0;column:valid_start;comment:生效时间" json:"valid_start"`
+	ValidEnd   int64                `gorm:"not null;column:valid_end;comment:失效时间" json:"valid_end"`
+	ValidDays  int32                `gorm:"not null;default:0;column:valid_days;comment:领取后有效天数(0表示以失效时间为准)" json:"valid_days"`
+	CampaignID string               `gorm:"not null;default:'';size:64;column:campaign_id;comment:活动ID(同一活动不可叠加)" json:"campaign_id"`
+	ExclGroup  string               `gorm:"not null;default:'';size:64;column:excl_group;comment:互斥组(同组不可叠加)" json:"excl_group"`
+	Status     CouponTemplateStatus `gorm:"not null;type:integer;default:0;column:status;comment:模板状态" json:"status"`
+	Operator   string               `gorm:"not null;column:operator;comment:操作人" json:"operator"`
+}
+
+func (CouponTemplate) TableName() string {
+	return "coupon_template"
+}
+
+func MigrateCouponTemplate(db *gorm.DB) error {
+	return db.AutoMigrate(&CouponTemplate{})
+}
+
+// CouponExpireCursor 过期优惠券处理游标(按user_id, coupon_id推进)
+type CouponExpireCursor struct {
+	Cutoff   int64     `json:"cutoff"` // 本轮处理的过期截止时间
+	UserID   uint64    `json:"user_id"`
+	CouponID uuid.UUID `json:"coupon_id"`
+}
+
+// CouponExpiredEvent 优惠券过期事件
+type CouponExpiredEvent struct {
+	EventType  string     `json:"event_type"`
+	UserID     uint64     `json:"user_id"`
+	CouponID   uuid.UUID  `json:"coupon_id"`
+	TemplateID string     `json:"template_id"`
+	CouponType CouponType `json:"coupon_type"`
+	ExpiredAt  int64      `json:"expired_at"`
+	EventTime  int64      `json:"event_time"`
+}
+
+const CouponEventTypeExpired = "coupon_expired"
+
+// NewCouponExpiredEvent 由过期优惠券生成过期事件
+func NewCouponExpiredEvent(userCoupon *UserCoupon, eventTime int64) *CouponExpiredEvent {
+	return &CouponExpiredEvent{
+		EventType:  CouponEventTypeExpired,
+		UserID:     userCoupon.UserID,
+		CouponID:   userCoupon.CouponID,
+		TemplateID: userCoupon.TemplateID,
+		CouponType: userCoupon.CouponType,
+		ExpiredAt:  userCoupon.ExpiredAt,
+		EventTime:  eventTime,
+	}
+}
+
+// CouponEventOutbox 待发送的优惠券事件,与优惠券状态变更在同一事务写入,发送至Kafka后删除
+type CouponEventOutbox struct {
+	ID         int64          `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
+	UserID     uint64         `gorm:"not null;column:user_id;comment:用户UserID(消息key)" json:"user_id"`
+	Payload    datatypes.JSON `gorm:"not null;type:jsonb;column:payload;comment:事件内容" json:"payload"`
+	CreateTime int64          `gorm:"autoCreateTime:milli;column:create_time;comment:创建时间" json:"create_time"`
+}
+
+func (CouponEventOutbox) TableName() string {
+	return "coupon_event_outbox"
+}
+
+func MigrateCouponEventOutbox(db *gorm.DB) error {
+	return db.AutoMigrate(&CouponEventOutbox{})
+}
@@ -0,0 +1,24 @@
+package model
+
+import "gorm.io/gorm"
+
+// UserInventory 用户背包物品实例(同一来源同一配置按序号唯一,保证幂等)
+type UserInventory struct {
+	InstanceID int64                `gorm:"primaryKey;autoIncrement:false;column:instance_id;comment:实例ID" json:"instance_id"`
+	UserID     uint64               `gorm:"not null;index;column:user_id;comment:用户UserID" json:"user_id"`
+	Kind       string               `gorm:"not null;size:16;uniqueIndex:idx_inventory_source;column:kind;comment:物品类型" json:"kind"`
+	ConfigID   int64                `gorm:"not null;uniqueIndex:idx_inventory_source;column:config_id;comment:配置ID" json:"config_id"`
+	Count      int64                `gorm:"not null;column:count;comment:数量" json:"count"`
+	SourceType CoinLedgerSourceType `gorm:"not null;size:32;uniqueIndex:idx_inventory_source;column:source_type;comment:来源类型" json:"source_type"`
+	SourceID   string               `gorm:"not null;size:64;uniqueIndex:idx_inventory_source;column:source_id;comment:来源ID" json:"source_id"`
+	Seq        int32                `gorm:"not null;default:0;uniqueIndex:idx_inventory_source;column:seq;comment:同一来源序号" json:"-"`
+	CreateTime int64                `gorm:"autoCreateTime:milli;column:create_time;comment:创建时间" json:"create_time"`
+}
+
+func (UserInventory) TableName() string {
+	return "user_inventory"
+}
+
+func MigrateUserInventory(db *gorm.DB) error {
+	return db.AutoMigrate(&UserInventory{})
+}
@@ -0,0 +1,40 @@
+package model
+
+import "gorm.io/gorm"
+
+// CoinLedgerSourceType 货币流水来源
+type CoinLedgerSourceType string
+
+const (
+	CoinLedgerSourceMail   CoinLedgerSourceType = "mail"   // 邮件附件
+	CoinLedgerSourceCoupon CoinLedgerSourceType = "coupon" // 优惠券满赠
+)
+
+// CoinLedgerStatusType 货币流水状态
+type CoinLedgerStatusType int32
+
+const (
+	CoinLedgerStatusPending CoinLedgerStatusType = 0  // 待入账
+	CoinLedgerStatusApplied CoinLedgerStatusType = 10 // 已入账
+)
+
+// UserCoinLedger 用户货币流水(同一来源同一货币唯一,保证幂等)
+type UserCoinLedger struct {
+	BaseModel
+	UserID     uint64               `gorm:"not null;index;column:user_id;comment:用户UserID" json:"user_id"`
+	SourceType CoinLedgerSourceType `gorm:"not null;size:32;uniqueIndex:idx_coin_ledger_source;column:source_type;comment:来源类型" json:"source_type"`
+	SourceID   string               `gorm:"not null;size:64;uniqueIndex:idx_coin_ledger_source;column:source_id;comment:来源ID" json:"source_id"`
+	CoinType   string               `gorm:"not null;size:32;uniqueIndex:idx_coin_ledger_source;column:coin_type;comment:货币类型" json:"coin_type"`
+	Amount     int64                `gorm:"not null;column:amount;comment:变动数量" json:"amount"`
+	Status     CoinLedgerStatusType `gorm:"not null;type:integer;default:0;column:status;comment:入账状态" json:"status"`
+	CreateTime int64                `gorm:"autoCreateTime:milli;column:create_time;comment:创建时间" json:"create_time"`
+	UpdateTime int64                `gorm:"autoUpdateTime:milli;column:update_time;comment:更新时间" json:"update_time"`
+}
+
+func (UserCoinLedger) TableName() string {
+	return "user_coin_ledger"
+}
+
+func MigrateUserCoinLedger(db *gorm.DB) error {
+	return db.AutoMigrate(&UserCoinLedger{})
+}
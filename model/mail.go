@@ -1,21 +1,29 @@
 package model
 
 import (
+	"encoding/json"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"ppt/code"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // MailTemplate 邮件模板
 type MailTemplate struct {
 	BaseModel
-	SenderID   uint64         `gorm:"not null;column:sender_id;comment:发送者ID" json:"sender_id"`
-	SenderName datatypes.JSON `gorm:"not null;type:jsonb;column:sender_name;comment:发送者" json:"sender_name"`
-	Title      datatypes.JSON `gorm:"not null;type:jsonb;column:title;comment:标题" json:"title"`
-	Content    datatypes.JSON `gorm:"not null;type:jsonb;column:content;comment:内容" json:"content"`
-	Awards     datatypes.JSON `gorm:"type:jsonb;column:awards;comment:附件奖励" json:"awards"`
-	Type       int32          `gorm:"not null;column:type;comment:邮件类型" json:"type"`
-	Status     int32          `gorm:"not null;column:status;comment:邮件状态" json:"status"`
-	ValidDays  int32          `gorm:"column:valid_days;comment:有效天数" json:"valid_days"`
+	SenderID    uint64         `gorm:"not null;column:sender_id;comment:发送者ID" json:"sender_id"`
+	SenderName  datatypes.JSON `gorm:"not null;type:jsonb;column:sender_name;comment:发送者" json:"sender_name"`
+	Title       datatypes.JSON `gorm:"not null;type:jsonb;column:title;comment:标题" json:"title"`
+	Content     datatypes.JSON `gorm:"not null;type:jsonb;column:content;comment:内容" json:"content"`
+	Awards      datatypes.JSON `gorm:"type:jsonb;column:awards;comment:附件奖励" json:"awards"`
+	Type        int32          `gorm:"not null;column:type;comment:邮件类型" json:"type"`
+	Status      int32          `gorm:"not null;column:status;comment:邮件状态" json:"status"`
+	ValidDays   int32          `gorm:"column:valid_days;comment:有效天数" json:"valid_days"`
+	DefaultLang string         `gorm:"not null;size:16;default:'en';column:default_lang;comment:默认语言" json:"default_lang"`
 }
 
 func (MailTemplate) TableName() string {
@@ -29,9 +37,11 @@ func MigrateMailTemplate(db *gorm.DB) error {
 // UserMail 用户邮件
 type UserMail struct {
 	BaseModel
-	UserID          uint64                  `gorm:"not null;column:user_id;comment:用户UserID" json:"user_id"`
+	UserID          uint64                  `gorm:"not null;uniqueIndex:idx_user_mail_broadcast_user,priority:2,where:broadcast_id <> '';column:user_id;comment:用户UserID" json:"user_id"`
 	TemplateID      string                  `gorm:"not null;column:template_id;comment:邮件模板ID" json:"template_id"`
+	BroadcastID     string                  `gorm:"index;uniqueIndex:idx_user_mail_broadcast_user,priority:1;column:broadcast_id;comment:群发任务ID" json:"broadcast_id"`
 	Awards          datatypes.JSON          `gorm:"column:awards;comment:奖励附件" json:"awards"`
+	Params          datatypes.JSON          `gorm:"type:jsonb;column:params;comment:个性化变量" json:"params"`
 	ExpiredTime     int64                   `gorm:"not null;column:expired_time;comment:过期时间" json:"expired_time"`
 	ReadStatus      MailReadStatusType      `gorm:"not null;type:integer;default:0;column:read_status;comment:读取状态" json:"read_status"`
 	AccessoryStatus MailAccessoryStatusType `gorm:"not null;type:integer;default:0;column:accessory_status;comment:附件状态" json:"accessory_status"`
@@ -46,5 +56,150 @@ func (UserMail) TableName() string {
 }
 
 func MigrateUserMail(db *gorm.DB) error {
+	// 同一模板允许多次发送给同一用户,移除早期的(user_id, template_id)唯一索引
+	if db.Migrator().HasIndex(&UserMail{}, "idx_user_mail_user_template") {
+		if err := db.Migrator().DropIndex(&UserMail{}, "idx_user_mail_user_template"); err != nil {
+			return err
+		}
+	}
 	return db.AutoMigrate(&UserMail{})
 }
+
+// UserMailCounter 用户邮件红点计数
+type UserMailCounter struct {
+	Unread     int64 `json:"unread" gorm:"column:unread"`       // 未读邮件数
+	Unclaimed  int64 `json:"unclaimed" gorm:"column:unclaimed"` // 附件未领取邮件数
+	NextExpire int64 `json:"-" gorm:"column:next_expire"`       // 计入计数的邮件中最早过期时间
+}
+
+// GetLangText 获取多语言JSON中指定语言文本(缺失时回退默认语言)
+func GetLangText(data datatypes.JSON, lang, defaultLang string) string {
+	if len(data) == 0 {
+		return ""
+	}
+	langMap := make(map[string]string)
+	if err := json.Unmarshal(data, &langMap); err != nil {
+		return ""
+	}
+	if text, ok := langMap[lang]; ok {
+		return text
+	}
+	return langMap[defaultLang]
+}
+
+var mailVarRegexp = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// RenderMailText 替换文本中的{{var}}占位符(未提供的变量保持原样)
+func RenderMailText(text string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(text, "{{") {
+		return text
+	}
+	return mailVarRegexp.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := mailVarRegexp.FindStringSubmatch(placeholder)[1]
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// IsMailVarName 是否为合法的自定义变量名(内置变量名不可覆盖)
+func IsMailVarName(name string) bool {
+	if !mailVarRegexp.MatchString("{{" + name + "}}") {
+		return false
+	}
+	for _, v := range code.MailBuiltinVars {
+		if v == name {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildMailVars 组装邮件渲染变量(发送时的个性化变量及用户内置变量)
+func BuildMailVars(user *User, userMail *UserMail) map[string]string {
+	vars := make(map[string]string)
+	if len(userMail.Params) > 0 {
+		_ = json.Unmarshal(userMail.Params, &vars)
+	}
+	if user != nil {
+		vars[code.MailVarUserName] = user.Username
+		vars[code.MailVarVipLevel] = strconv.Itoa(int(user.VipLevel))
+	}
+	vars[code.MailVarExpireDate] = time.UnixMilli(userMail.ExpiredTime).Format(time.DateOnly)
+	return vars
+}
+
+// MailAward 邮件附件奖励项
+type MailAward struct {
+	CoinType string `json:"coin_type"`
+	Amount   int64  `json:"amount"`
+}
+
+// ParseMailAwards 解析邮件附件货币奖励(忽略未知货币类型、物品奖励及非正数量)
+func ParseMailAwards(data datatypes.JSON) ([]*MailAward, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	awardsMap := make(map[string]int64)
+	if err := json.Unmarshal(data, &awardsMap); err != nil {
+		return nil, err
+	}
+	awards := make([]*MailAward, 0, len(awardsMap))
+	for coinType, amount := range awardsMap {
+		if !code.IsCoinType(coinType) || amount <= 0 {
+			continue
+		}
+		awards = append(awards, &MailAward{CoinType: coinType, Amount: amount})
+	}
+	sort.Slice(awards, func(i, j int) bool {
+		return awards[i].CoinType < awards[j].CoinType
+	})
+	return awards, nil
+}
+
+// MailGrant 邮件附件物品奖励项(道具/宠物)
+type MailGrant struct {
+	Kind     string `json:"kind"`
+	ConfigID int64  `json:"config_id"`
+	Count    int64  `json:"count"`
+}
+
+// ParseMailGrantKey 解析物品奖励key(kind:config_id)
+func ParseMailGrantKey(key string) (string, int64, bool) {
+	kind, configIDStr, ok := strings.Cut(key, code.AwardKeySep)
+	if !ok || !code.IsGrantAwardKind(kind) {
+		return "", 0, false
+	}
+	configID, err := strconv.ParseInt(configIDStr, 10, 64)
+	if err != nil || configID <= 0 {
+		return "", 0, false
+	}
+	return kind, configID, true
+}
+
+// ParseMailGrants 解析邮件附件物品奖励(忽略货币及非正数量)
+func ParseMailGrants(data datatypes.JSON) ([]*MailGrant, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	awardsMap := make(map[string]int64)
+	if err := json.Unmarshal(data, &awardsMap); err != nil {
+		return nil, err
+	}
+	grants := make([]*MailGrant, 0, len(awardsMap))
+	for key, count := range awardsMap {
+		kind, configID, ok := ParseMailGrantKey(key)
+		if !ok || count <= 0 {
+			continue
+		}
+		grants = append(grants, &MailGrant{Kind: kind, ConfigID: configID, Count: count})
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].Kind != grants[j].Kind {
+			return grants[i].Kind < grants[j].Kind
+		}
+		return grants[i].ConfigID < grants[j].ConfigID
+	})
+	return grants, nil
+}
@@ -0,0 +1,47 @@
+package model
+
+import "gorm.io/gorm"
+
+// UserMailArchive 用户邮件归档(ClickHouse)
+type UserMailArchive struct {
+	ID              string `gorm:"id" json:"id"`
+	UserID          uint64 `gorm:"user_id" json:"user_id"`
+	TemplateID      string `gorm:"template_id" json:"template_id"`
+	BroadcastID     string `gorm:"broadcast_id" json:"broadcast_id"`
+	Awards          string `gorm:"awards;comment:附件奖励" json:"awards"`
+	ReadStatus      int32  `gorm:"read_status;comment:读取状态" json:"read_status"`
+	AccessoryStatus int32  `gorm:"accessory_status;comment:附件状态" json:"accessory_status"`
+	VisibleStatus   int32  `gorm:"visible_status;comment:可见性状态" json:"visible_status"`
+	Operator        string `gorm:"operator;comment:操作人" json:"operator"`
+	ExpiredTime     int64  `gorm:"expired_time;type:Int64;comment:过期时间" json:"expired_time"`
+	CreateTime      int64  `gorm:"create_time;type:Int64;comment:创建时间" json:"create_time"`
+	UpdateTime      int64  `gorm:"update_time;type:Int64;comment:更新时间" json:"update_time"`
+	ArchiveTime     int64  `gorm:"archive_time;type:Int64;comment:归档时间" json:"archive_time"`
+}
+
+func (UserMailArchive) TableName() string {
+	return "user_mail_archive"
+}
+
+func MigrateUserMailArchive(sqlSession *gorm.DB) error {
+	return sqlSession.Set("gorm:table_options", "ENGINE=ReplacingMergeTree(archive_time) PARTITION BY toYYYYMM(toDateTime(intDiv(create_time, 1000))) ORDER BY (user_id, template_id, id) SETTINGS index_granularity = 8192").AutoMigrate(&UserMailArchive{})
+}
+
+// NewUserMailArchive 用户邮件转归档记录
+func NewUserMailArchive(userMail *UserMail, archiveTime int64) *UserMailArchive {
+	return &UserMailArchive{
+		ID:              userMail.ID,
+		UserID:          userMail.UserID,
+		TemplateID:      userMail.TemplateID,
+		BroadcastID:     userMail.BroadcastID,
+		Awards:          string(userMail.Awards),
+		ReadStatus:      int32(userMail.ReadStatus),
+		AccessoryStatus: int32(userMail.AccessoryStatus),
+		VisibleStatus:   int32(userMail.VisibleStatus),
+		Operator:        userMail.Operator,
+		ExpiredTime:     userMail.ExpiredTime,
+		CreateTime:      userMail.CreateTime,
+		UpdateTime:      userMail.UpdateTime,
+		ArchiveTime:     archiveTime,
+	}
+}
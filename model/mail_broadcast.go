@@ -0,0 +1,95 @@
+package model
+
+import (
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// MailAudienceType 邮件受众类型
+type MailAudienceType string
+
+const (
+	MailAudienceAll     MailAudienceType = "all"      // 全部用户
+	MailAudienceUserIDs MailAudienceType = "user_ids" // 指定用户
+	MailAudienceBrand   MailAudienceType = "brand"    // 指定品牌
+	MailAudienceChannel MailAudienceType = "channel"  // 指定渠道
+	MailAudienceLang    MailAudienceType = "lang"     // 指定语言
+	MailAudienceActive  MailAudienceType = "active"   // 活跃用户
+)
+
+// MailAudience 邮件受众选择器
+type MailAudience struct {
+	Type       MailAudienceType `json:"type"`
+	UserIDs    []uint64         `json:"user_ids,omitempty"`
+	BrandID    int32            `json:"brand_id,omitempty"`
+	Channel    string           `json:"channel,omitempty"`
+	Lang       string           `json:"lang,omitempty"`
+	ActiveDate string           `json:"active_date,omitempty"` // 活跃日期(yyyy-mm-dd),默认当天
+}
+
+// MailSendParams 发送时的个性化变量,UserParams中的用户变量覆盖公共变量
+type MailSendParams struct {
+	Params     map[string]string            `json:"params,omitempty"`
+	UserParams map[uint64]map[string]string `json:"user_params,omitempty"`
+}
+
+// GetUserParams 获取指定用户的渲染变量
+func (p *MailSendParams) GetUserParams(userID uint64) map[string]string {
+	userParams := p.UserParams[userID]
+	if len(p.Params) == 0 {
+		return userParams
+	}
+	if len(userParams) == 0 {
+		return p.Params
+	}
+	params := make(map[string]string, len(p.Params)+len(userParams))
+	for k, v := range p.Params {
+		params[k] = v
+	}
+	for k, v := range userParams {
+		params[k] = v
+	}
+	return params
+}
+
+// MailBroadcastStatusType 群发状态定义
+type MailBroadcastStatusType int32
+
+const (
+	MailBroadcastStatusPending  MailBroadcastStatusType = 0  // 待发送
+	MailBroadcastStatusRunning  MailBroadcastStatusType = 10 // 发送中
+	MailBroadcastStatusFinished MailBroadcastStatusType = 20 // 已完成
+	MailBroadcastStatusFailed   MailBroadcastStatusType = 30 // 发送失败
+	MailBroadcastStatusSchedule MailBroadcastStatusType = 40 // 定时待发送
+	MailBroadcastStatusCanceled MailBroadcastStatusType = 50 // 已取消(定时发送前)
+	MailBroadcastStatusRevoked  MailBroadcastStatusType = 60 // 已撤销
+)
+
+// MailBroadcast 邮件群发任务(按UserID游标分批投递,可断点续发)
+type MailBroadcast struct {
+	BaseModel
+	TemplateID    string                  `gorm:"not null;index;column:template_id;comment:邮件模板ID" json:"template_id"`
+	Audience      datatypes.JSON          `gorm:"not null;type:jsonb;column:audience;comment:受众选择器" json:"audience"`
+	Params        datatypes.JSON          `gorm:"type:jsonb;column:params;comment:个性化变量" json:"params"`
+	Status        MailBroadcastStatusType `gorm:"not null;type:integer;default:0;column:status;comment:群发状态" json:"status"`
+	LastUserID    uint64                  `gorm:"not null;default:0;column:last_user_id;comment:已投递游标" json:"last_user_id"`
+	Total         int64                   `gorm:"not null;default:0;column:total;comment:预计受众数" json:"total"`
+	Delivered     int64                   `gorm:"not null;default:0;column:delivered;comment:已投递数" json:"delivered"`
+	Operator      string                  `gorm:"not null;column:operator;comment:操作人" json:"operator"`
+	ErrMsg        string                  `gorm:"column:err_msg;comment:失败原因" json:"err_msg"`
+	ScheduleTime  int64                   `gorm:"not null;default:0;column:schedule_time;comment:定时发送时间" json:"schedule_time"`
+	TaskID        string                  `gorm:"column:task_id;comment:定时任务ID" json:"task_id"`
+	CloseOperator string                  `gorm:"column:close_operator;comment:取消/撤销操作人" json:"close_operator"`
+	CloseTime     int64                   `gorm:"column:close_time;comment:取消/撤销时间" json:"close_time"`
+	FinishTime    int64                   `gorm:"column:finish_time;comment:完成时间" json:"finish_time"`
+	CreateTime    int64                   `gorm:"autoCreateTime:milli;column:create_time;comment:创建时间" json:"create_time"`
+	UpdateTime    int64                   `gorm:"autoUpdateTime:milli;column:update_time;comment:更新时间" json:"update_time"`
+}
+
+func (MailBroadcast) TableName() string {
+	return "mail_broadcast"
+}
+
+func MigrateMailBroadcast(db *gorm.DB) error {
+	return db.AutoMigrate(&MailBroadcast{})
+}
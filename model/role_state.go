@@ -0,0 +1,23 @@
+package model
+
+import (
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// RoleState 角色actor持久化的玩家状态,Version为乐观锁版本,每次写入加1
+type RoleState struct {
+	UserID    uint64         `gorm:"column:user_id;type:bigint;primaryKey" json:"user_id"`
+	Version   int64          `gorm:"column:version;not null;default:0;comment:乐观锁版本" json:"version"`
+	Data      datatypes.JSON `gorm:"column:data;type:jsonb;comment:状态数据" json:"data"`
+	CreatedAt int64          `gorm:"column:created_at;autoCreateTime:milli" json:"created_at"`
+	UpdatedAt int64          `gorm:"column:updated_at;autoUpdateTime:milli" json:"updated_at"`
+}
+
+func (RoleState) TableName() string {
+	return "role_state"
+}
+
+func MigrateRoleState(db *gorm.DB) error {
+	return db.AutoMigrate(&RoleState{})
+}
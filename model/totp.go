@@ -0,0 +1,33 @@
+package model
+
+import (
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type UserTotpStatus int32
+
+const (
+	UserTotpStatusPending UserTotpStatus = iota // 0 = 已生成密钥待确认
+	UserTotpStatusEnabled                       // 1 = 已启用
+)
+
+// UserTotp 用户两步验证
+type UserTotp struct {
+	UserID        uint64         `gorm:"column:user_id;type:bigint;primaryKey" json:"user_id"`
+	SecretEnc     string         `gorm:"column:secret_enc;size:255;not null;comment:加密后的TOTP密钥" json:"-"`
+	Status        UserTotpStatus `gorm:"column:status;type:integer;not null;default:0" json:"status"`
+	RecoveryCodes datatypes.JSON `gorm:"column:recovery_codes;type:jsonb;comment:未使用的恢复码哈希" json:"-"`
+	LastUsedStep  int64          `gorm:"column:last_used_step;not null;default:0;comment:最近使用的TOTP时间步(防重放)" json:"-"`
+	EnabledAt     int64          `gorm:"column:enabled_at;not null;default:0" json:"enabled_at"`
+	CreatedAt     int64          `gorm:"column:created_at;autoCreateTime:milli" json:"created_at"`
+	UpdatedAt     int64          `gorm:"column:updated_at;autoUpdateTime:milli" json:"updated_at"`
+}
+
+func (UserTotp) TableName() string {
+	return "user_totp"
+}
+
+func MigrateUserTotp(db *gorm.DB) error {
+	return db.AutoMigrate(&UserTotp{})
+}
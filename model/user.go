@@ -10,15 +10,17 @@ import (
 
 type User struct {
 	BaseModel
-	UserID    uint64 `gorm:"not null;uniqueIndex;column:user_id;comment:玩家UserID" json:"user_id"`
-	Username  string `gorm:"not null;size:255;column:user_name;comment:玩家名" json:"user_name"`
-	Password  string `gorm:"not null;size:255;column:password;comment:密码" json:"password"`
-	Email     string `gorm:"not null;uniqueIndex;size:255;column:email;comment:注册邮箱" json:"email"`
-	BrandID   int32  `gorm:"not null;column:brand_id;comment:品牌" json:"brand_id"`
-	Channel   string `gorm:"not null;column:channel;comment:渠道" json:"channel"`
-	Lang      string `gorm:"not null;column:lang;comment:语言包" json:"lang"`
-	CreatedAt int64  `gorm:"autoCreateTime:milli;column:create_at;comment:创建时间" json:"created_at"`
-	UpdateAt  int64  `gorm:"autoUpdateTime:milli;column:update_at;comment:最后更新" json:"update_at"`
+	UserID        uint64 `gorm:"not null;uniqueIndex;column:user_id;comment:玩家UserID" json:"user_id"`
+	Username      string `gorm:"not null;uniqueIndex;size:255;column:user_name;comment:玩家名" json:"user_name"`
+	Password      string `gorm:"not null;size:255;column:password;comment:密码" json:"password"`
+	Email         string `gorm:"not null;uniqueIndex;size:255;column:email;comment:注册邮箱" json:"email"`
+	EmailVerified bool   `gorm:"not null;default:false;column:email_verified;comment:邮箱是否已验证" json:"email_verified"`
+	BrandID       int32  `gorm:"not null;column:brand_id;comment:品牌" json:"brand_id"`
+	Channel       string `gorm:"not null;column:channel;comment:渠道" json:"channel"`
+	Lang          string `gorm:"not null;column:lang;comment:语言包" json:"lang"`
+	VipLevel      int32  `gorm:"not null;default:0;column:vip_level;comment:VIP等级" json:"vip_level"`
+	CreatedAt     int64  `gorm:"autoCreateTime:milli;column:create_at;comment:创建时间" json:"created_at"`
+	UpdateAt      int64  `gorm:"autoUpdateTime:milli;column:update_at;comment:最后更新" json:"update_at"`
 }
 
 func (User) TableName() string {
@@ -39,6 +41,11 @@ func CreateUser(userID uint64, userName, password, email string) (*User, error)
 	}, nil
 }
 
+// InsertUser 创建用户
+func InsertUser(pgDB *gorm.DB, user *User) error {
+	return pgDB.Create(user).Error
+}
+
 // InsertUsersByPgxPool 批量插入用户数据
 func InsertUsersByPgxPool(pgxPool *pgxpool.Pool, users []*User) error {
 	userModel := User{}
@@ -75,6 +82,34 @@ func GetUserByID(pgDB *gorm.DB, userID uint64) (*User, error) {
 	return &user, nil
 }
 
+// GetUserByName 按用户名获取用户
+func GetUserByName(pgDB *gorm.DB, userName string) (*User, error) {
+	var user User
+	if err := pgDB.Where("user_name = ?", userName).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByEmail 按邮箱获取用户
+func GetUserByEmail(pgDB *gorm.DB, email string) (*User, error) {
+	var user User
+	if err := pgDB.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUserEmailVerified 标记邮箱已验证
+func UpdateUserEmailVerified(pgDB *gorm.DB, userID uint64) error {
+	return pgDB.Model(&User{}).Where("user_id = ?", userID).Update("email_verified", true).Error
+}
+
+// UpdateUserPassword 更新密码哈希
+func UpdateUserPassword(pgDB *gorm.DB, userID uint64, password string) error {
+	return pgDB.Model(&User{}).Where("user_id = ?", userID).Update("password", password).Error
+}
+
 func GetUserSpecifyFieldsByID(pgDB *gorm.DB, userID uint64, fields []string) (*User, error) {
 	var user User
 	if err := pgDB.Where("user_id = ?", userID).Select(fields).First(&user).Error; err != nil {
@@ -82,3 +117,29 @@ func GetUserSpecifyFieldsByID(pgDB *gorm.DB, userID uint64, fields []string) (*U
 	}
 	return &user, nil
 }
+
+// GetUserIDsByCursor 按UserID游标分页获取用户ID(conds为空时查询全部用户)
+func GetUserIDsByCursor(pgDB *gorm.DB, lastUserID uint64, limit int, conds map[string]interface{}) ([]uint64, error) {
+	var userIDs []uint64
+	query := pgDB.Model(&User{}).Where("user_id > ?", lastUserID)
+	if len(conds) > 0 {
+		query = query.Where(conds)
+	}
+	if err := query.Order("user_id").Limit(limit).Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// CountUsers 统计用户数
+func CountUsers(pgDB *gorm.DB, conds map[string]interface{}) (int64, error) {
+	var total int64
+	query := pgDB.Model(&User{})
+	if len(conds) > 0 {
+		query = query.Where(conds)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return 0, err
+	}
+	return total, nil
+}
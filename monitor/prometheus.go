@@ -36,6 +36,51 @@ var (
 			Help: "count of user login through different modes",
 		},
 		[]string{"login_mode"})
+	RegisterBlockedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "register_blocked_count",
+			Help: "count of blocked registration attempts",
+		},
+		[]string{"reason"})
+	// actor指标以actor类型为标签,不区分角色ID,避免标签基数过大
+	ActorMailboxDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "actor_mailbox_depth",
+			Help: "number of messages queued in actor mailboxes",
+		},
+		[]string{"actor"})
+	ActorMailboxOverflowCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "actor_mailbox_overflow_count",
+			Help: "count of messages dropped or rejected by full actor mailboxes",
+		},
+		[]string{"actor", "policy"})
+	ActorMsgWaitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "actor_msg_wait_seconds",
+			Help:    "time actor messages wait in mailbox in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		},
+		[]string{"actor"})
+	ActorMsgProcDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "actor_msg_proc_seconds",
+			Help:    "actor message process duration in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"actor"})
+	ActorStateFlushCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "actor_state_flush_count",
+			Help: "count of actor state flushes by result",
+		},
+		[]string{"actor", "result"})
+	ActorForwardCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "actor_forward_count",
+			Help: "count of actor messages forwarded to owner node by result",
+		},
+		[]string{"msg_type", "result"})
 )
 
 func InitProm() {
@@ -44,5 +89,12 @@ func InitProm() {
 	prometheus.MustRegister(RequestStatusCount)
 	prometheus.MustRegister(RequestMethodCount)
 	prometheus.MustRegister(UserLoginCount)
+	prometheus.MustRegister(RegisterBlockedCount)
+	prometheus.MustRegister(ActorMailboxDepth)
+	prometheus.MustRegister(ActorMailboxOverflowCount)
+	prometheus.MustRegister(ActorMsgWaitDuration)
+	prometheus.MustRegister(ActorMsgProcDuration)
+	prometheus.MustRegister(ActorStateFlushCount)
+	prometheus.MustRegister(ActorForwardCount)
 	//prometheus.MustRegister(collectors.NewGoCollector())
 }
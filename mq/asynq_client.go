@@ -66,8 +66,9 @@ func CloseAsynq() {
 }
 
 // EnqueueTaskInstant 实时发送
-func EnqueueTaskInstant(task *asynq.Task) error {
-	info, err := asynqClient.Enqueue(task, asynq.MaxRetry(3), asynq.Queue(TaskQueueTypeInstant))
+func EnqueueTaskInstant(task *asynq.Task, opts ...asynq.Option) error {
+	opts = append([]asynq.Option{asynq.MaxRetry(3), asynq.Queue(TaskQueueTypeInstant)}, opts...)
+	info, err := asynqClient.Enqueue(task, opts...)
 	if err != nil {
 		log.Error("EnqueueTaskInstant enqueue fail", zap.Any("asynq_task", task), zap.Any("err", err))
 		return err
@@ -77,8 +78,9 @@ func EnqueueTaskInstant(task *asynq.Task) error {
 }
 
 // EnqueueTaskLatency 延时发送
-func EnqueueTaskLatency(task *asynq.Task, sendTime time.Time) error {
-	info, err := asynqClient.Enqueue(task, asynq.MaxRetry(3), asynq.Queue(TaskQueueTypeLatency), asynq.ProcessAt(sendTime))
+func EnqueueTaskLatency(task *asynq.Task, sendTime time.Time, opts ...asynq.Option) error {
+	opts = append([]asynq.Option{asynq.MaxRetry(3), asynq.Queue(TaskQueueTypeLatency), asynq.ProcessAt(sendTime)}, opts...)
+	info, err := asynqClient.Enqueue(task, opts...)
 	if err != nil {
 		log.Error("EnqueueTaskLatency enqueue fail", zap.Any("asynq_task", task), zap.Any("err", err))
 		return err
@@ -9,6 +9,29 @@ import (
 	"ppt/model"
 )
 
+// PptTaskHandler 业务任务处理函数
+type PptTaskHandler func(ctx context.Context, task *model.PptAsynqTask) error
+
+var pptTaskHandlers = make(map[model.PptAsynqTaskType]PptTaskHandler)
+
+// RegisterPptTaskHandler 注册业务任务处理函数(需在StartAsynqServer之前调用)
+func RegisterPptTaskHandler(taskType model.PptAsynqTaskType, handler PptTaskHandler) {
+	pptTaskHandlers[taskType] = handler
+}
+
+// NewPptTask 创建业务任务
+func NewPptTask(taskType model.PptAsynqTaskType, taskID string, payload interface{}) (*asynq.Task, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	taskBytes, err := json.Marshal(model.PptAsynqTask{TaskID: taskID, TaskType: taskType, Payload: payloadBytes})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(PPTTaskType, taskBytes), nil
+}
+
 func HandlePptTask(ctx context.Context, t *asynq.Task) error {
 	var pptAsynqTask model.PptAsynqTask
 	var err error
@@ -17,8 +40,10 @@ func HandlePptTask(ctx context.Context, t *asynq.Task) error {
 		return err
 	}
 	log.Info("HandlePptTask receive asynq task", zap.Any("asynq_task", pptAsynqTask))
-	/*
-		todo handle specific logics
-	*/
-	return nil
+	handler, ok := pptTaskHandlers[pptAsynqTask.TaskType]
+	if !ok {
+		log.Warn("HandlePptTask no handler for task type", zap.Any("asynq_task", pptAsynqTask))
+		return nil
+	}
+	return handler(ctx, &pptAsynqTask)
 }
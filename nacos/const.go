@@ -6,4 +6,6 @@ const (
 	NacosRegion         = "ppt_test"
 	NacosDefaultGroup   = "DEFAULT_GROUP"
 	NacosDataIDDBConfig = "db_config"
+	NacosDataIDAuth     = "auth_config"
+	NacosDataIDActor    = "actor_config"
 )
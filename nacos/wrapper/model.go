@@ -1,10 +1,13 @@
 package wrapper
 
+import "ppt/config"
+
 type DBConfig struct {
-	RedisConfig RedisConfig `json:"redis"`
-	PgConfig    PgConfig    `json:"pg"`
-	MongoConfig string      `json:"mongo"`
-	KafkaConfig KafkaConfig `json:"kafka"`
+	RedisConfig RedisConfig     `json:"redis"`
+	PgConfig    PgConfig        `json:"pg"`
+	MongoConfig string          `json:"mongo"`
+	KafkaConfig KafkaConfig     `json:"kafka"`
+	CKConfig    config.CKConfig `json:"clickhouse"`
 }
 
 type RedisConfig struct {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
 	"go.uber.org/zap"
+	"ppt/config"
 	"ppt/log"
 	"ppt/nacos"
 )
@@ -44,3 +45,33 @@ func GetNacosDBConfig() (*DBConfig, error) {
 	}
 	return nacosDBConfig, nil
 }
+
+func GetNacosAuthConfig() (*config.AuthConfig, error) {
+	data, err := GetNacosConfig(nacos.NacosRegion, nacos.NacosDefaultGroup, nacos.NacosDataIDAuth)
+	if err != nil {
+		log.Error("GetNacosAuthConfig GetNacosConfig error", zap.Error(err))
+		return nil, err
+	}
+	authConfig := &config.AuthConfig{}
+	err = json.Unmarshal([]byte(data), authConfig)
+	if err != nil {
+		log.Error("GetNacosAuthConfig Unmarshal AuthConfig error", zap.Error(err))
+		return nil, err
+	}
+	return authConfig, nil
+}
+
+func GetNacosActorConfig() (*config.ActorConfig, error) {
+	data, err := GetNacosConfig(nacos.NacosRegion, nacos.NacosDefaultGroup, nacos.NacosDataIDActor)
+	if err != nil {
+		log.Error("GetNacosActorConfig GetNacosConfig error", zap.Error(err))
+		return nil, err
+	}
+	actorConfig := &config.ActorConfig{}
+	err = json.Unmarshal([]byte(data), actorConfig)
+	if err != nil {
+		log.Error("GetNacosActorConfig Unmarshal ActorConfig error", zap.Error(err))
+		return nil, err
+	}
+	return actorConfig, nil
+}
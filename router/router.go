@@ -8,6 +8,7 @@ import (
 	"go.uber.org/zap"
 	"net/http"
 	"ppt/config"
+	"ppt/controllers"
 	"ppt/log"
 	loginController "ppt/login/controllers"
 	"ppt/middleware"
@@ -39,8 +40,20 @@ func NewHttpServer(port int) *HttpServer {
 	return &HttpServer{server: httpServer}
 }
 
+// NewEngine 创建gin引擎并设置可信代理,proxies为空时忽略X-Forwarded-For等请求头,防止客户端伪造IP
+func NewEngine(proxies []string) (*gin.Engine, error) {
+	engine := gin.New()
+	if err := engine.SetTrustedProxies(proxies); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
 func initRouter() *gin.Engine {
-	router := gin.New()
+	router, err := NewEngine(config.GetTrustedProxies())
+	if err != nil {
+		log.Fatal("invalid trusted proxies", zap.Error(err))
+	}
 	router.Use(gin.Recovery())
 	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{}))
 	router.Use(middleware.GinRecover(&log.Logger, true))
@@ -59,6 +72,7 @@ func initRouter() *gin.Engine {
 
 	{
 		loginController.RegModelHandler(router)
+		controllers.RegModelHandler(router)
 	}
 
 	router.GET("/metrics", gin.WrapF(middleware.HttpCheckIP([]string{}, promhttp.Handler()).ServeHTTP))
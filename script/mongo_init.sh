@@ -16,4 +16,5 @@ db.createUser({
     }
   ]
 });
+db.user_credit.createIndex({user_id: 1}, {unique: true});
 EOF
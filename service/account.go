@@ -0,0 +1,297 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"net/mail"
+	"ppt/code"
+	"ppt/dao"
+	commonDB "ppt/dao/db"
+	"ppt/log"
+	loginDB "ppt/login/db"
+	"ppt/model"
+	"ppt/util"
+	"regexp"
+	"strings"
+)
+
+const (
+	accountPasswordMinLen = 8
+	accountPasswordMaxLen = 64
+	accountEmailMaxLen    = 255
+	pgUniqueViolation     = "23505"
+)
+
+var (
+	ErrAccountNameInvalid     = errors.New("account name invalid")
+	ErrAccountEmailInvalid    = errors.New("account email invalid")
+	ErrAccountPasswordInvalid = errors.New("account password invalid")
+	ErrAccountParamInvalid    = errors.New("account param invalid")
+	ErrAccountNameExists      = errors.New("account name already exists")
+	ErrAccountEmailExists     = errors.New("account email already exists")
+)
+
+var accountNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{2,31}$`)
+
+// AccountRegisterParam 账号注册参数
+type AccountRegisterParam struct {
+	Name     string
+	Password string
+	Email    string
+	BrandID  int32
+	Channel  string
+	Lang     string
+	IP       string
+	Device   string // 设备指纹
+}
+
+// AccountInfo 账号信息(不含密码)
+type AccountInfo struct {
+	UserID        uint64 `json:"user_id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	BrandID       int32  `json:"brand_id"`
+	Channel       string `json:"channel"`
+	Lang          string `json:"lang"`
+	CreatedAt     int64  `json:"created_at"`
+}
+
+// NewAccountInfo 转换为对外账号信息
+func NewAccountInfo(user *model.User) *AccountInfo {
+	return &AccountInfo{
+		UserID:        user.UserID,
+		Name:          user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		BrandID:       user.BrandID,
+		Channel:       user.Channel,
+		Lang:          user.Lang,
+		CreatedAt:     user.CreatedAt,
+	}
+}
+
+// RegisterAccount 注册账号
+// 先校验防刷策略并占用注册数,在Redis占用账户名,再分配UserID并写入PG;任一步骤失败时归还注册数并释放账户名
+func RegisterAccount(param *AccountRegisterParam) (user *model.User, err error) {
+	if err = checkAccountRegisterParam(param); err != nil {
+		return nil, err
+	}
+	releaseGuard, err := checkRegisterGuard(param)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			releaseGuard()
+		}
+	}()
+	if err = loginDB.RegUserName(param.Name); err != nil {
+		if errors.Is(err, loginDB.ErrUserNameExists) {
+			return nil, ErrAccountNameExists
+		}
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if unregErr := loginDB.UnregUserName(param.Name); unregErr != nil {
+			log.Error("RegisterAccount UnregUserName error", zap.String("user_name", param.Name), zap.Error(unregErr))
+		}
+	}()
+
+	userID, err := commonDB.GenerateUserID(dao.RedisDB)
+	if err != nil {
+		return nil, err
+	}
+	password, err := util.HashPassword(param.Password)
+	if err != nil {
+		log.Error("RegisterAccount HashPassword error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	user = &model.User{
+		UserID:   userID,
+		Username: param.Name,
+		Password: password,
+		Email:    param.Email,
+		BrandID:  param.BrandID,
+		Channel:  param.Channel,
+		Lang:     param.Lang,
+	}
+	if err = model.InsertUser(dao.PgDB, user); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			if strings.Contains(pgErr.ConstraintName, "email") {
+				return nil, ErrAccountEmailExists
+			}
+			return nil, ErrAccountNameExists
+		}
+		log.Error("RegisterAccount InsertUser error", zap.Uint64("user_id", userID), zap.String("user_name", param.Name), zap.Error(err))
+		return nil, err
+	}
+	_ = loginDB.SetUserCache(*user)
+	log.Info("RegisterAccount success", zap.Uint64("user_id", userID), zap.String("user_name", param.Name), zap.Int32("brand_id", param.BrandID), zap.String("channel", param.Channel))
+	recordRegisterGuard(userID, param)
+	go sendRegisterEmailVerify(user, param.IP)
+	return user, nil
+}
+
+// sendRegisterEmailVerify 注册成功后发送邮箱验证码,失败时用户可再次申请
+func sendRegisterEmailVerify(user *model.User, ip string) {
+	if err := checkVerifyCodeSendLimit(VerifyPurposeEmail, user.Email, ip); err != nil {
+		log.Warn("sendRegisterEmailVerify checkVerifyCodeSendLimit error", zap.Uint64("user_id", user.UserID), zap.Error(err))
+		return
+	}
+	if err := sendVerifyCode(VerifyPurposeEmail, user.Email, user.Lang); err != nil {
+		log.Warn("sendRegisterEmailVerify sendVerifyCode error", zap.Uint64("user_id", user.UserID), zap.Error(err))
+	}
+}
+
+// SendAccountEmailVerify 发送邮箱验证码
+// 邮箱未注册或已验证时不发送但同样返回成功,避免枚举注册邮箱
+func SendAccountEmailVerify(email, ip string) error {
+	user, err := getAccountForVerifyCode(VerifyPurposeEmail, email, ip)
+	if err != nil || user == nil || user.EmailVerified {
+		return err
+	}
+	return sendVerifyCode(VerifyPurposeEmail, user.Email, user.Lang)
+}
+
+// VerifyAccountEmail 校验验证码并标记邮箱已验证
+func VerifyAccountEmail(email, verifyCode string) error {
+	email, err := normalizeAccountEmail(email)
+	if err != nil {
+		return err
+	}
+	if err = checkVerifyCode(VerifyPurposeEmail, email, verifyCode); err != nil {
+		return err
+	}
+	user, err := model.GetUserByEmail(dao.PgDB, email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrVerifyCodeInvalid
+		}
+		log.Error("VerifyAccountEmail GetUserByEmail error", zap.Error(err))
+		return err
+	}
+	if err = model.UpdateUserEmailVerified(dao.PgDB, user.UserID); err != nil {
+		log.Error("VerifyAccountEmail UpdateUserEmailVerified error", zap.Uint64("user_id", user.UserID), zap.Error(err))
+		return err
+	}
+	loginDB.DelUserCache(user.UserID)
+	log.Info("VerifyAccountEmail success", zap.Uint64("user_id", user.UserID))
+	return nil
+}
+
+// RequestPasswordReset 发送找回密码验证码,邮箱未注册时同样返回成功
+func RequestPasswordReset(email, ip string) error {
+	user, err := getAccountForVerifyCode(VerifyPurposePassword, email, ip)
+	if err != nil || user == nil {
+		return err
+	}
+	return sendVerifyCode(VerifyPurposePassword, user.Email, user.Lang)
+}
+
+// ResetAccountPassword 校验找回密码验证码并设置新密码
+// 重置成功后删除全部refresh token轮换链及登录会话,已签发的access token因会话不存在立即失效
+func ResetAccountPassword(email, verifyCode, newPassword string) error {
+	email, err := normalizeAccountEmail(email)
+	if err != nil {
+		return err
+	}
+	if err = checkAccountPassword(newPassword); err != nil {
+		return err
+	}
+	if err = checkVerifyCode(VerifyPurposePassword, email, verifyCode); err != nil {
+		return err
+	}
+	user, err := model.GetUserByEmail(dao.PgDB, email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrVerifyCodeInvalid
+		}
+		log.Error("ResetAccountPassword GetUserByEmail error", zap.Error(err))
+		return err
+	}
+	password, err := util.HashPassword(newPassword)
+	if err != nil {
+		log.Error("ResetAccountPassword HashPassword error", zap.Uint64("user_id", user.UserID), zap.Error(err))
+		return err
+	}
+	if err = model.UpdateUserPassword(dao.PgDB, user.UserID, password); err != nil {
+		log.Error("ResetAccountPassword UpdateUserPassword error", zap.Uint64("user_id", user.UserID), zap.Error(err))
+		return err
+	}
+	loginDB.DelUserCache(user.UserID)
+	if err = commonDB.DelUserRefreshFamilies(dao.RedisDB, user.UserID); err != nil {
+		log.Error("ResetAccountPassword DelUserRefreshFamilies error", zap.Uint64("user_id", user.UserID), zap.Error(err))
+	}
+	log.Info("ResetAccountPassword success", zap.Uint64("user_id", user.UserID))
+	return nil
+}
+
+// getAccountForVerifyCode 校验发送频率后按邮箱获取账号,邮箱未注册时返回nil
+// 频率限制在查询账号前执行,注册与未注册邮箱的响应一致
+func getAccountForVerifyCode(purpose, email, ip string) (*model.User, error) {
+	email, err := normalizeAccountEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkVerifyCodeSendLimit(purpose, email, ip); err != nil {
+		return nil, err
+	}
+	user, err := model.GetUserByEmail(dao.PgDB, email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		log.Error("getAccountForVerifyCode GetUserByEmail error", zap.String("purpose", purpose), zap.Error(err))
+		return nil, err
+	}
+	return user, nil
+}
+
+// checkAccountRegisterParam 校验注册参数,邮箱统一转为小写,语言缺省时使用默认语言
+func checkAccountRegisterParam(param *AccountRegisterParam) error {
+	if !accountNameRegexp.MatchString(param.Name) {
+		return fmt.Errorf("%w: 3-32 letters, digits or underscores starting with a letter", ErrAccountNameInvalid)
+	}
+	email, err := normalizeAccountEmail(param.Email)
+	if err != nil {
+		return err
+	}
+	param.Email = email
+	if err = checkAccountPassword(param.Password); err != nil {
+		return err
+	}
+	if param.Lang == "" {
+		param.Lang = code.LangDefault
+	}
+	if !code.IsLang(param.Lang) {
+		return fmt.Errorf("%w: unknown lang %s", ErrAccountParamInvalid, param.Lang)
+	}
+	if param.BrandID < 0 {
+		return fmt.Errorf("%w: brand_id must not be negative", ErrAccountParamInvalid)
+	}
+	return nil
+}
+
+// normalizeAccountEmail 校验邮箱格式并统一转为小写
+func normalizeAccountEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > accountEmailMaxLen {
+		return "", ErrAccountEmailInvalid
+	}
+	return strings.ToLower(email), nil
+}
+
+func checkAccountPassword(password string) error {
+	if len(password) < accountPasswordMinLen || len(password) > accountPasswordMaxLen {
+		return fmt.Errorf("%w: length must be %d-%d", ErrAccountPasswordInvalid, accountPasswordMinLen, accountPasswordMaxLen)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"ppt/cache"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+	"regexp"
+)
+
+var (
+	ErrAdminRoleInvalid       = errors.New("admin role invalid")
+	ErrAdminRoleNotFound      = errors.New("admin role not found")
+	ErrAdminPermissionInvalid = errors.New("admin permission invalid")
+	ErrAdminPrincipalInvalid  = errors.New("admin principal invalid")
+)
+
+var (
+	adminRoleNameRegexp   = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+	adminPermissionRegexp = regexp.MustCompile(`^(\*|[a-z][a-z0-9_]*:(\*|[a-z][a-z0-9_]*))$`)
+)
+
+// GetAdminAccess 获取后台账号生效的角色及权限(经AdminCache缓存)
+func GetAdminAccess(userID uint64) (*model.AdminAccess, error) {
+	accessAny, err := cache.AdminCache.Get(userID)
+	if err != nil {
+		log.Error("GetAdminAccess Get admin cache error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	access, ok := accessAny.(*model.AdminAccess)
+	if !ok {
+		log.Error("GetAdminAccess admin cache type assertion error", zap.Uint64("user_id", userID), zap.Any("admin_cache", accessAny))
+		return nil, errors.New("admin cache type assertion error")
+	}
+	return access, nil
+}
+
+// getAdminRoleNames 获取写入access token的角色,查询失败时按无角色签发(后台权限以实时校验为准)
+func getAdminRoleNames(userID uint64) []string {
+	access, err := GetAdminAccess(userID)
+	if err != nil {
+		return nil
+	}
+	return access.Roles
+}
+
+// SaveAdminRole 创建或更新角色,变更立即在本节点生效
+func SaveAdminRole(name string, permissions []string, description string) (*model.AdminRole, error) {
+	if !adminRoleNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("%w: 2-64 lowercase letters, digits or underscores starting with a letter", ErrAdminRoleInvalid)
+	}
+	for _, perm := range permissions {
+		if !adminPermissionRegexp.MatchString(perm) {
+			return nil, fmt.Errorf("%w: %s", ErrAdminPermissionInvalid, perm)
+		}
+	}
+	role := &model.AdminRole{Name: name, Permissions: permissions, Description: description}
+	if err := db.NewAdminDao(dao.PgDB).SaveAdminRole(role); err != nil {
+		log.Error("SaveAdminRole error", zap.String("role", name), zap.Error(err))
+		return nil, err
+	}
+	clearAdminCache()
+	return role, nil
+}
+
+// DeleteAdminRole 删除角色,已分配该角色的账号随即失去对应权限
+func DeleteAdminRole(name string) error {
+	ok, err := db.NewAdminDao(dao.PgDB).DeleteAdminRole(name)
+	if err != nil {
+		log.Error("DeleteAdminRole error", zap.String("role", name), zap.Error(err))
+		return err
+	}
+	if !ok {
+		return ErrAdminRoleNotFound
+	}
+	clearAdminCache()
+	return nil
+}
+
+func GetAdminRoles() ([]*model.AdminRole, error) {
+	return db.NewAdminDao(dao.PgDB).GetAdminRoles()
+}
+
+// SaveAdminPrincipal 设置后台账号的角色及状态,不能修改自己的账号
+func SaveAdminPrincipal(userID uint64, roles []string, status model.AdminPrincipalStatus, operatorID uint64, operator string) (*model.AdminPrincipal, error) {
+	if userID == 0 || userID == operatorID {
+		return nil, fmt.Errorf("%w: cannot modify own or empty principal", ErrAdminPrincipalInvalid)
+	}
+	if status != model.AdminPrincipalStatusEnabled && status != model.AdminPrincipalStatusDisabled {
+		return nil, fmt.Errorf("%w: unknown status %d", ErrAdminPrincipalInvalid, status)
+	}
+	if _, err := model.GetUserByID(dao.PgDB, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: user %d not found", ErrAdminPrincipalInvalid, userID)
+		}
+		return nil, err
+	}
+	adminDao := db.NewAdminDao(dao.PgDB)
+	if len(roles) > 0 {
+		existRoles, err := adminDao.GetAdminRolesByNames(roles)
+		if err != nil {
+			return nil, err
+		}
+		exists := make(map[string]struct{}, len(existRoles))
+		for _, role := range existRoles {
+			exists[role.Name] = struct{}{}
+		}
+		for _, role := range roles {
+			if _, ok := exists[role]; !ok {
+				return nil, fmt.Errorf("%w: %s", ErrAdminRoleNotFound, role)
+			}
+		}
+	}
+	principal := &model.AdminPrincipal{UserID: userID, Roles: roles, Status: status, Operator: operator}
+	if err := adminDao.SaveAdminPrincipal(principal); err != nil {
+		log.Error("SaveAdminPrincipal error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	cache.AdminCache.Delete(userID)
+	log.Info("SaveAdminPrincipal success", zap.Uint64("user_id", userID), zap.Strings("roles", roles), zap.Int32("status", int32(status)), zap.String("operator", operator))
+	return principal, nil
+}
+
+func GetAdminPrincipals() ([]*model.AdminPrincipal, error) {
+	return db.NewAdminDao(dao.PgDB).GetAdminPrincipals()
+}
+
+// CreateAdminAuditLog 写入审计日志
+func CreateAdminAuditLog(auditLog *model.AdminAuditLog) error {
+	if err := db.NewAdminDao(dao.PgDB).CreateAdminAuditLog(auditLog); err != nil {
+		log.Error("CreateAdminAuditLog error", zap.Uint64("operator_id", auditLog.OperatorID), zap.String("path", auditLog.Path), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func GetAdminAuditLogsByPage(operatorID uint64, target string, page, size int) ([]*model.AdminAuditLog, int64, error) {
+	return db.NewAdminDao(dao.PgDB).GetAdminAuditLogsByPage(operatorID, target, (page-1)*size, size)
+}
+
+// clearAdminCache 角色变更影响所有持有该角色的账号,清空本节点缓存
+func clearAdminCache() {
+	var userIDs []uint64
+	cache.AdminCache.Range(func(userID uint64, _ any) bool {
+		userIDs = append(userIDs, userID)
+		return true
+	})
+	for _, userID := range userIDs {
+		cache.AdminCache.Delete(userID)
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"ppt/actor"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+	"ppt/util"
+	"sync"
+	"time"
+)
+
+const mfaTokenRandomLength = 32
+
+var (
+	ErrAccountLoginFailed  = errors.New("invalid name or password")
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+	ErrMfaChallengeInvalid = errors.New("mfa challenge invalid or expired")
+)
+
+// dummyPasswordHash 用户不存在时同样执行一次哈希校验,避免通过响应耗时枚举用户名
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := util.HashPassword(uuid.NewString())
+	return hash
+})
+
+// AuthTokens 登录/刷新签发的token
+type AuthTokens struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"` // 秒
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"` // 秒
+}
+
+// LoginResult 登录结果,开启两步验证时仅返回MfaToken,需调用LoginAccountMfa完成登录
+type LoginResult struct {
+	User     *model.User
+	Tokens   *AuthTokens
+	MfaToken string
+}
+
+// LoginAccount 校验用户名密码,创建登录会话并签发token
+func LoginAccount(name, password string, client *LoginClient) (*LoginResult, error) {
+	user, err := model.GetUserByName(dao.PgDB, name)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Error("LoginAccount GetUserByName error", zap.String("user_name", name), zap.Error(err))
+			return nil, err
+		}
+		_, _ = util.VerifyPassword(password, dummyPasswordHash())
+		return nil, ErrAccountLoginFailed
+	}
+	ok, err := util.VerifyPassword(password, user.Password)
+	if err != nil {
+		log.Error("LoginAccount VerifyPassword error", zap.Uint64("user_id", user.UserID), zap.Error(err))
+		return nil, ErrAccountLoginFailed
+	}
+	if !ok {
+		return nil, ErrAccountLoginFailed
+	}
+	userTotp, err := db.NewUserTotpDao(dao.PgDB).GetUserTotp(user.UserID)
+	if err != nil {
+		log.Error("LoginAccount GetUserTotp error", zap.Uint64("user_id", user.UserID), zap.Error(err))
+		return nil, err
+	}
+	if userTotp != nil && userTotp.Status == model.UserTotpStatusEnabled {
+		mfaToken, err := util.GenerateRandomToken(mfaTokenRandomLength)
+		if err != nil {
+			return nil, err
+		}
+		if err = db.SetMfaChallenge(dao.RedisDB, mfaToken, user.UserID); err != nil {
+			return nil, err
+		}
+		log.Info("LoginAccount mfa required", zap.Uint64("user_id", user.UserID))
+		return &LoginResult{User: user, MfaToken: mfaToken}, nil
+	}
+	tokens, err := createSession(user, client, false)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("LoginAccount success", zap.Uint64("user_id", user.UserID))
+	return &LoginResult{User: user, Tokens: tokens}, nil
+}
+
+// LoginAccountMfa 使用TOTP验证码或恢复码完成两步验证登录,挑战仅在验证成功后失效
+func LoginAccountMfa(mfaToken, code, recoveryCode string, client *LoginClient) (*LoginResult, error) {
+	userID, err := db.GetMfaChallenge(dao.RedisDB, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 {
+		return nil, ErrMfaChallengeInvalid
+	}
+	if err = VerifyUserTotp(userID, code, recoveryCode); err != nil {
+		if errors.Is(err, ErrTotpLocked) {
+			_, _ = db.DelMfaChallenge(dao.RedisDB, mfaToken)
+		}
+		return nil, err
+	}
+	if ok, err := db.DelMfaChallenge(dao.RedisDB, mfaToken); err != nil || !ok {
+		return nil, ErrMfaChallengeInvalid
+	}
+	user, err := model.GetUserByID(dao.PgDB, userID)
+	if err != nil {
+		log.Error("LoginAccountMfa GetUserByID error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	tokens, err := createSession(user, client, true)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("LoginAccountMfa success", zap.Uint64("user_id", user.UserID))
+	return &LoginResult{User: user, Tokens: tokens}, nil
+}
+
+// RefreshAuthTokens 使用refresh token换取新的token,旧refresh token随即失效
+// 已轮换的refresh token再次使用时使整条轮换链失效
+func RefreshAuthTokens(refreshToken string) (*AuthTokens, error) {
+	tokenHash := util.HashRefreshToken(refreshToken)
+	token, err := db.GetRefreshToken(dao.RedisDB, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+	first, err := db.RotateRefreshToken(dao.RedisDB, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	current, err := db.GetRefreshFamilyCurrent(dao.RedisDB, token.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if !first || current != tokenHash {
+		log.Warn("RefreshAuthTokens refresh token reused, revoke family", zap.Uint64("user_id", token.UserID), zap.String("family_id", token.FamilyID))
+		if err = db.DelRefreshFamily(dao.RedisDB, token.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenInvalid
+	}
+	_ = db.TouchUserSession(dao.RedisDB, token.FamilyID, util.GetRefreshTokenTTL())
+	return issueAuthTokens(token.UserID, token.Name, token.FamilyID, token.MFA)
+}
+
+// LogoutAccount 注销refresh token所在会话,并吊销请求携带的access token(可为nil)
+func LogoutAccount(refreshToken string, accessClaims *util.JwtCustomClaims) error {
+	if accessClaims != nil {
+		if err := util.RevokeToken(accessClaims); err != nil {
+			return err
+		}
+	}
+	token, err := db.GetRefreshToken(dao.RedisDB, util.HashRefreshToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if err = db.DelUserSession(dao.RedisDB, token.UserID, token.FamilyID); err != nil {
+		return err
+	}
+	stopRoleActor(token.UserID)
+	log.Info("LogoutAccount success", zap.Uint64("user_id", token.UserID), zap.String("family_id", token.FamilyID))
+	return nil
+}
+
+// stopRoleActor 用户已无有效会话时停止其角色actor,后续消息到达时会重新创建
+func stopRoleActor(userID uint64) {
+	if actor.RoleActorManager == nil {
+		return
+	}
+	if sessions, err := db.GetUserSessions(dao.RedisDB, userID); err != nil || len(sessions) > 0 {
+		return
+	}
+	actor.RoleActorManager.StopActor(userID)
+}
+
+// issueAuthTokens 签发access token及同一轮换链下的新refresh token,轮换链ID即会话ID,角色在每次签发时重新获取
+func issueAuthTokens(userID uint64, name, familyID string, mfa bool) (*AuthTokens, error) {
+	accessToken, expiresAt, err := util.GenerateAccessToken(userID, name, familyID, getAdminRoleNames(userID), mfa)
+	if err != nil {
+		log.Error("issueAuthTokens GenerateAccessToken error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	refreshToken, err := util.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshTTL := util.GetRefreshTokenTTL()
+	err = db.SetRefreshToken(dao.RedisDB, util.HashRefreshToken(refreshToken), &model.RefreshToken{
+		UserID:    userID,
+		Name:      name,
+		FamilyID:  familyID,
+		MFA:       mfa,
+		CreatedAt: time.Now().UnixMilli(),
+	}, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthTokens{
+		AccessToken:      accessToken,
+		TokenType:        util.TokenTypeBearer,
+		ExpiresIn:        int64(time.Until(expiresAt).Round(time.Second).Seconds()),
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: int64(refreshTTL.Seconds()),
+	}, nil
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+	"time"
+)
+
+var (
+	ErrCouponTemplateNotFound = errors.New("coupon template not found")
+	ErrCouponTemplateInvalid  = errors.New("coupon template invalid")
+	ErrCouponNotApplicable    = errors.New("coupon not applicable")
+	ErrCouponOrderInvalid     = errors.New("coupon order invalid")
+	// errCouponBroken 优惠券配置异常,无法再成功核销
+	errCouponBroken = errors.New("coupon config broken")
+)
+
+// CouponTemplateParam 优惠券模板创建参数,金额单位与订单金额一致
+type CouponTemplateParam struct {
+	Name       map[string]string `json:"name" binding:"required"`
+	CouponType model.CouponType  `json:"coupon_type"`
+	Threshold  int64             `json:"threshold"`
+	Discount   int64             `json:"discount"`    // 满减金额
+	Gifts      map[string]int64  `json:"gifts"`       // 满赠奖励,key同邮件附件
+	ValidStart int64             `json:"valid_start"` // 生效时间(毫秒)
+	ValidEnd   int64             `json:"valid_end" binding:"required"`
+	ValidDays  int32             `json:"valid_days"`
+	CampaignID string            `json:"campaign_id"` // 同一活动的优惠券不可叠加
+	ExclGroup  string            `json:"excl_group"`  // 同一互斥组的优惠券不可叠加
+}
+
+// CouponItem 用户优惠券及模板信息
+type CouponItem struct {
+	*model.UserCoupon
+	Name       string          `json:"name"`
+	Threshold  int64           `json:"threshold"`
+	Discount   int64           `json:"discount"`
+	Gifts      json.RawMessage `json:"gifts,omitempty"`
+	ValidStart int64           `json:"valid_start"`
+}
+
+// CouponQuote 优惠券试算结果
+type CouponQuote struct {
+	CouponID    uuid.UUID        `json:"coupon_id"`
+	CouponType  model.CouponType `json:"coupon_type"`
+	OrderAmount int64            `json:"order_amount"`
+	Discount    int64            `json:"discount"`
+	PayAmount   int64            `json:"pay_amount"`
+	Gifts       json.RawMessage  `json:"gifts,omitempty"`
+}
+
+// CouponRedeemResult 优惠券核销结果
+type CouponRedeemResult struct {
+	Coupon      *model.UserCoupon       `json:"coupon"`
+	Ledgers     []*model.UserCoinLedger `json:"ledgers"`
+	Inventories []*model.UserInventory  `json:"items"`
+}
+
+// CreateCouponTemplate 校验并创建优惠券模板
+func CreateCouponTemplate(param *CouponTemplateParam, operator string) (*model.CouponTemplate, error) {
+	couponTemplate := &model.CouponTemplate{
+		CouponType: param.CouponType,
+		Threshold:  param.Threshold,
+		Discount:   param.Discount,
+		ValidStart: param.ValidStart,
+		ValidEnd:   param.ValidEnd,
+		ValidDays:  param.ValidDays,
+		CampaignID: param.CampaignID,
+		ExclGroup:  param.ExclGroup,
+		Status:     model.CouponTemplateStatusInUse,
+		Operator:   operator,
+	}
+	var err error
+	if couponTemplate.Name, err = marshalLangText(ErrCouponTemplateInvalid, "name", param.Name); err != nil {
+		return nil, err
+	}
+	if param.Threshold < 0 || param.ValidDays < 0 {
+		return nil, fmt.Errorf("%w: threshold and valid_days must not be negative", ErrCouponTemplateInvalid)
+	}
+	if param.ValidEnd <= time.Now().UnixMilli() || param.ValidEnd <= param.ValidStart {
+		return nil, fmt.Errorf("%w: valid_end must be later than now and valid_start", ErrCouponTemplateInvalid)
+	}
+	switch param.CouponType {
+	case model.CouponTypeFullReduction:
+		if param.Discount <= 0 || len(param.Gifts) > 0 {
+			return nil, fmt.Errorf("%w: full reduction coupon requires positive discount and no gifts", ErrCouponTemplateInvalid)
+		}
+	case model.CouponTypeFullGift:
+		if param.Discount != 0 || len(param.Gifts) == 0 {
+			return nil, fmt.Errorf("%w: full gift coupon requires gifts and no discount", ErrCouponTemplateInvalid)
+		}
+		if err = checkAwards(ErrCouponTemplateInvalid, param.Gifts); err != nil {
+			return nil, err
+		}
+		if couponTemplate.Gifts, err = json.Marshal(param.Gifts); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown coupon type %d", ErrCouponTemplateInvalid, param.CouponType)
+	}
+	if err = db.NewCouponDao(dao.PgDB).CreateCouponTemplate(couponTemplate); err != nil {
+		log.Error("CreateCouponTemplate error", zap.Any("param", param), zap.Error(err))
+		return nil, err
+	}
+	return couponTemplate, nil
+}
+
+// GetCouponTemplatesByPage 分页获取可用优惠券模板
+func GetCouponTemplatesByPage(page, size int) ([]*model.CouponTemplate, int64, error) {
+	return db.NewCouponDao(dao.PgDB).GetCouponTemplatesByPage((page-1)*size, size)
+}
+
+// GrantCoupons 向用户发放优惠券,sourceID相同的重复发放会被忽略,返回实际发放数
+func GrantCoupons(templateID string, userIDs []uint64, sourceID, operator string) (int64, error) {
+	couponTemplate, err := getUsableCouponTemplate(templateID)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if couponTemplate.ValidEnd <= now.UnixMilli() {
+		return 0, ErrCouponTemplateNotFound
+	}
+	expiredAt := couponTemplate.ValidEnd
+	if couponTemplate.ValidDays > 0 {
+		expiredAt = min(expiredAt, now.AddDate(0, 0, int(couponTemplate.ValidDays)).UnixMilli())
+	}
+	if sourceID == "" {
+		sourceID = uuid.NewString()
+	}
+	userCoupons := make([]*model.UserCoupon, 0, len(userIDs))
+	for _, userID := range userIDs {
+		couponID, err := uuid.NewV7()
+		if err != nil {
+			return 0, err
+		}
+		userCoupons = append(userCoupons, &model.UserCoupon{
+			UserID:       userID,
+			CouponID:     couponID,
+			TemplateID:   couponTemplate.ID,
+			SourceID:     sourceID,
+			CouponType:   couponTemplate.CouponType,
+			CouponStatus: model.CouponStatusAvailable,
+			ReceivedAt:   now.UnixMilli(),
+			ExpiredAt:    expiredAt,
+			Operator:     operator,
+		})
+	}
+	granted, err := db.NewCouponDao(dao.PgDB).GrantUserCoupons(userCoupons)
+	if err != nil {
+		log.Error("GrantCoupons GrantUserCoupons error", zap.String("template_id", templateID), zap.String("source_id", sourceID), zap.Error(err))
+		return 0, err
+	}
+	log.Info("GrantCoupons success", zap.String("template_id", templateID), zap.String("source_id", sourceID), zap.Int64("granted", granted), zap.String("operator", operator))
+	return granted, nil
+}
+
+// GetUserAvailableCoupons 获取用户可用优惠券(名称按用户语言解析)
+func GetUserAvailableCoupons(userID uint64, lang string) ([]*CouponItem, error) {
+	couponDao := db.NewCouponDao(dao.PgDB)
+	userCoupons, err := couponDao.GetUserAvailableCoupons(userID, time.Now().UnixMilli())
+	if err != nil {
+		log.Error("GetUserAvailableCoupons error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	templateIDs := make([]string, 0, len(userCoupons))
+	for _, userCoupon := range userCoupons {
+		templateIDs = append(templateIDs, userCoupon.TemplateID)
+	}
+	templates, err := couponDao.GetCouponTemplatesByIDs(templateIDs)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*CouponItem, 0, len(userCoupons))
+	for _, userCoupon := range userCoupons {
+		item := &CouponItem{UserCoupon: userCoupon}
+		if couponTemplate, ok := templates[userCoupon.TemplateID]; ok {
+			item.Name = model.GetLangText(couponTemplate.Name, lang, code.LangDefault)
+			item.Threshold = couponTemplate.Threshold
+			item.Discount = couponTemplate.Discount
+			item.ValidStart = couponTemplate.ValidStart
+			if len(couponTemplate.Gifts) > 0 {
+				item.Gifts = json.RawMessage(couponTemplate.Gifts)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// QuoteCoupon 按订单金额试算优惠券优惠
+func QuoteCoupon(userID uint64, couponID uuid.UUID, orderAmount int64) (*CouponQuote, error) {
+	if orderAmount <= 0 {
+		return nil, ErrCouponOrderInvalid
+	}
+	couponDao := db.NewCouponDao(dao.PgDB)
+	userCoupon, err := couponDao.GetUserCoupon(userID, couponID)
+	if err != nil {
+		return nil, err
+	}
+	couponTemplate, err := couponDao.GetCouponTemplateByID(userCoupon.TemplateID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponTemplateNotFound
+		}
+		return nil, err
+	}
+	return evaluateCoupon(couponTemplate, userCoupon, orderAmount, time.Now().UnixMilli())
+}
+
+// RedeemCoupon 核销优惠券,在玩家actor中串行执行
+// 行锁下校验并变更状态,满赠奖励与状态变更在同一事务中写入;配置异常无法发放时标记为使用失败
+func RedeemCoupon(ctx context.Context, userID uint64, couponID uuid.UUID, orderID string, orderAmount int64) (*CouponRedeemResult, error) {
+	if orderID == "" || orderAmount <= 0 {
+		return nil, ErrCouponOrderInvalid
+	}
+	payload := &couponRedeemPayload{CouponID: couponID, OrderID: orderID, OrderAmount: orderAmount}
+	return askRole(ctx, userID, roleMsgCouponRedeem, payload, func() (*CouponRedeemResult, error) {
+		return redeemCoupon(userID, couponID, orderID, orderAmount)
+	})
+}
+
+func redeemCoupon(userID uint64, couponID uuid.UUID, orderID string, orderAmount int64) (*CouponRedeemResult, error) {
+	couponDao := db.NewCouponDao(dao.PgDB)
+	result := &CouponRedeemResult{}
+	applied := false
+	userCoupon, err := couponDao.RedeemUserCouponByTx(userID, couponID, orderID, func(tx *gorm.DB, userCoupon *model.UserCoupon) (map[string]interface{}, error) {
+		applied = true
+		couponTemplate, err := db.NewCouponDao(tx).GetCouponTemplateByID(userCoupon.TemplateID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: template %s not found", errCouponBroken, userCoupon.TemplateID)
+			}
+			return nil, err
+		}
+		quote, err := evaluateCoupon(couponTemplate, userCoupon, orderAmount, time.Now().UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		if couponTemplate.CouponType == model.CouponTypeFullGift {
+			if _, err = model.ParseMailAwards(couponTemplate.Gifts); err != nil {
+				return nil, fmt.Errorf("%w: %v", errCouponBroken, err)
+			}
+			result.Ledgers, result.Inventories, err = db.CreateAwardsByTx(tx, userID, model.CoinLedgerSourceCoupon, couponID.String(), couponTemplate.Gifts, mintInventoryID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{
+			"order_amount": quote.OrderAmount,
+			"discount":     quote.Discount,
+		}, nil
+	})
+	if err != nil {
+		if errors.Is(err, errCouponBroken) {
+			log.Error("RedeemCoupon coupon broken", zap.Uint64("user_id", userID), zap.String("coupon_id", couponID.String()), zap.Error(err))
+			if _, failErr := couponDao.UpdateUserCouponUsedFailed(userID, couponID, orderID, err.Error()); failErr != nil {
+				log.Error("RedeemCoupon UpdateUserCouponUsedFailed error", zap.Uint64("user_id", userID), zap.String("coupon_id", couponID.String()), zap.Error(failErr))
+			}
+			return nil, db.ErrCouponUnavailable
+		}
+		return nil, err
+	}
+	result.Coupon = userCoupon
+	if !applied {
+		// 同一订单重复核销,返回首次核销发放的流水及物品
+		if result.Ledgers, err = db.GetCoinLedgersBySource(dao.PgDB, model.CoinLedgerSourceCoupon, couponID.String()); err != nil {
+			return nil, err
+		}
+		if result.Inventories, err = db.GetInventoriesBySource(dao.PgDB, model.CoinLedgerSourceCoupon, couponID.String()); err != nil {
+			return nil, err
+		}
+	}
+	// 满赠货币入账失败时由待入账流水补偿
+	if err = ApplyUserPendingCoinLedgers(userID); err != nil {
+		log.Warn("RedeemCoupon ApplyUserPendingCoinLedgers error", zap.Uint64("user_id", userID), zap.String("coupon_id", couponID.String()), zap.Error(err))
+	}
+	if result.Ledgers == nil {
+		result.Ledgers = make([]*model.UserCoinLedger, 0)
+	}
+	if result.Inventories == nil {
+		result.Inventories = make([]*model.UserInventory, 0)
+	}
+	log.Info("RedeemCoupon success", zap.Uint64("user_id", userID), zap.String("coupon_id", couponID.String()), zap.String("order_id", orderID), zap.Int64("discount", userCoupon.Discount))
+	return result, nil
+}
+
+// evaluateCoupon 校验优惠券可用性并计算优惠
+func evaluateCoupon(couponTemplate *model.CouponTemplate, userCoupon *model.UserCoupon, orderAmount, now int64) (*CouponQuote, error) {
+	if userCoupon.CouponStatus != model.CouponStatusAvailable || userCoupon.ExpiredAt <= now {
+		return nil, db.ErrCouponUnavailable
+	}
+	if now < couponTemplate.ValidStart {
+		return nil, fmt.Errorf("%w: coupon not started", ErrCouponNotApplicable)
+	}
+	if orderAmount < couponTemplate.Threshold {
+		return nil, fmt.Errorf("%w: order amount below threshold %d", ErrCouponNotApplicable, couponTemplate.Threshold)
+	}
+	quote := &CouponQuote{
+		CouponID:    userCoupon.CouponID,
+		CouponType:  couponTemplate.CouponType,
+		OrderAmount: orderAmount,
+		PayAmount:   orderAmount,
+	}
+	switch couponTemplate.CouponType {
+	case model.CouponTypeFullReduction:
+		quote.Discount = min(couponTemplate.Discount, orderAmount)
+		quote.PayAmount = orderAmount - quote.Discount
+	case model.CouponTypeFullGift:
+		quote.Gifts = json.RawMessage(couponTemplate.Gifts)
+	}
+	return quote, nil
+}
+
+func getUsableCouponTemplate(templateID string) (*model.CouponTemplate, error) {
+	couponTemplate, err := db.NewCouponDao(dao.PgDB).GetCouponTemplateByID(templateID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponTemplateNotFound
+		}
+		return nil, err
+	}
+	if couponTemplate.Status == model.CouponTemplateStatusDeleted {
+		return nil, ErrCouponTemplateNotFound
+	}
+	return couponTemplate, nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/kafka"
+	"ppt/log"
+	"ppt/model"
+	"strconv"
+	"time"
+)
+
+// CouponExpireStrategy 过期优惠券处理策略
+type CouponExpireStrategy string
+
+const (
+	CouponExpireStrategyNone   CouponExpireStrategy = "none"   // 无过期优惠券
+	CouponExpireStrategyDirect CouponExpireStrategy = "direct" // 单条语句直接更新
+	CouponExpireStrategyCursor CouponExpireStrategy = "cursor" // 游标分批更新
+	CouponExpireStrategyResume CouponExpireStrategy = "resume" // 从未完成的游标继续
+)
+
+// ChooseCouponExpireStrategy 存在未完成的游标时从游标处继续(忽略count);否则按过期数量选择直接更新或游标分批更新
+func ChooseCouponExpireStrategy(cursor *model.CouponExpireCursor, count int64) CouponExpireStrategy {
+	switch {
+	case cursor != nil:
+		return CouponExpireStrategyResume
+	case count <= 0:
+		return CouponExpireStrategyNone
+	case count <= dao.CouponExpireDirectMax:
+		return CouponExpireStrategyDirect
+	}
+	return CouponExpireStrategyCursor
+}
+
+// ExpireCoupons 将已过期的可用优惠券更新为过期状态并发送过期事件
+// 过期事件与状态更新在同一事务写入outbox,先补发上次未发送的事件
+func ExpireCoupons() {
+	begin := time.Now()
+	RelayCouponEvents()
+	cursor, err := db.GetCouponExpireCursor(dao.RedisDB)
+	if err != nil {
+		return
+	}
+	cutoff := begin.UnixMilli()
+	var count int64
+	if cursor == nil {
+		if count, err = db.CountExpiredCoupons(dao.PgDB, cutoff); err != nil {
+			log.Error("ExpireCoupons CountExpiredCoupons error", zap.Error(err))
+			return
+		}
+	}
+	strategy := ChooseCouponExpireStrategy(cursor, count)
+	switch strategy {
+	case CouponExpireStrategyResume:
+		log.Info("ExpireCoupons resume from cursor", zap.Any("cursor", cursor))
+		expireCouponsByCursor(cursor)
+	case CouponExpireStrategyNone:
+		log.Info("ExpireCoupons no expired coupons")
+	case CouponExpireStrategyDirect:
+		if _, err = db.DirectedUpdateExpiredCoupons(dao.PgDB, cutoff); err != nil {
+			log.Error("ExpireCoupons DirectedUpdateExpiredCoupons error", zap.Error(err))
+			return
+		}
+		RelayCouponEvents()
+	case CouponExpireStrategyCursor:
+		expireCouponsByCursor(&model.CouponExpireCursor{Cutoff: cutoff})
+	}
+	log.Info("ExpireCoupons cost seconds", zap.String("strategy", string(strategy)), zap.Int64("count", count), zap.Float64("expire_cost", time.Since(begin).Seconds()))
+}
+
+// expireCouponsByCursor 游标分批过期,每批发送事件后保存游标,全部完成后清除游标
+func expireCouponsByCursor(cursor *model.CouponExpireCursor) {
+	err := db.BatchUpdateExpiredCoupons(dao.PgDB, dao.CouponExpireBatchSize, cursor, func(cursor *model.CouponExpireCursor, expired []*model.UserCoupon) error {
+		RelayCouponEvents()
+		return db.SetCouponExpireCursor(dao.RedisDB, cursor)
+	})
+	if err != nil {
+		log.Error("expireCouponsByCursor BatchUpdateExpiredCoupons error", zap.Any("cursor", cursor), zap.Error(err))
+		return
+	}
+	if err = db.DelCouponExpireCursor(dao.RedisDB); err != nil {
+		log.Error("expireCouponsByCursor DelCouponExpireCursor error", zap.Error(err))
+	}
+}
+
+// RelayCouponEvents 将outbox中的优惠券事件按写入顺序发送至Kafka(以user_id为key保证同一用户有序),发送后删除
+// producer未初始化或出错时事件保留在outbox,下次执行时重发;删除失败时可能重复发送,消费方需按coupon_id去重
+func RelayCouponEvents() {
+	for {
+		outbox, err := db.GetCouponEventOutbox(dao.PgDB, dao.CouponEventRelayBatch)
+		if err != nil || len(outbox) == 0 {
+			return
+		}
+		if kafka.KafkaProducerClient == nil {
+			log.Warn("RelayCouponEvents kafka producer not initialized, events kept in outbox", zap.Int("pending", len(outbox)))
+			return
+		}
+		topic := kafka.GetKafkaTopic("coupon")
+		ids := make([]int64, 0, len(outbox))
+		for _, event := range outbox {
+			kafka.KafkaProducerClient.SendTopicMessage(topic, []byte(strconv.FormatUint(event.UserID, 10)), event.Payload)
+			ids = append(ids, event.ID)
+		}
+		if err = db.DeleteCouponEventOutbox(dao.PgDB, ids); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"math/bits"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+	"time"
+)
+
+// CouponStackRule 优惠券叠加规则
+// 同一活动、同一互斥组的优惠券不可叠加,每种类型的使用张数受MaxPerType限制
+type CouponStackRule struct {
+	MaxPerType map[model.CouponType]int // 每种类型最多使用张数(未配置的类型不可使用)
+	MaxCoupons int                      // 单次最多参与计算的优惠券数
+}
+
+// DefaultCouponStackRule 默认规则:一张满减加一张满赠
+var DefaultCouponStackRule = &CouponStackRule{
+	MaxPerType: map[model.CouponType]int{
+		model.CouponTypeFullReduction: 1,
+		model.CouponTypeFullGift:      1,
+	},
+	MaxCoupons: 10,
+}
+
+// CouponRejectReason 优惠券未被选用原因
+type CouponRejectReason string
+
+const (
+	CouponRejectNotFound       CouponRejectReason = "not_found"        // 优惠券不存在
+	CouponRejectDuplicate      CouponRejectReason = "duplicate"        // 重复提交
+	CouponRejectUnavailable    CouponRejectReason = "unavailable"      // 已使用/已删除
+	CouponRejectExpired        CouponRejectReason = "expired"          // 已过期
+	CouponRejectNotStarted     CouponRejectReason = "not_started"      // 未到生效时间
+	CouponRejectBelowThreshold CouponRejectReason = "below_threshold"  // 未达使用门槛
+	CouponRejectTypeLimit      CouponRejectReason = "type_limit"       // 同类型使用张数已达上限
+	CouponRejectSameCampaign   CouponRejectReason = "same_campaign"    // 与已选优惠券同一活动
+	CouponRejectExclGroup      CouponRejectReason = "excl_group"       // 与已选优惠券同一互斥组
+	CouponRejectNoExtraBenefit CouponRejectReason = "no_extra_benefit" // 叠加后无额外优惠
+)
+
+// CouponCandidate 参与叠加计算的优惠券及其模板
+type CouponCandidate struct {
+	Coupon   *model.UserCoupon
+	Template *model.CouponTemplate
+}
+
+// CouponRejection 优惠券未被选用说明
+type CouponRejection struct {
+	CouponID uuid.UUID          `json:"coupon_id"`
+	Reason   CouponRejectReason `json:"reason"`
+	Detail   string             `json:"detail"`
+}
+
+// CouponStackResult 优惠券叠加计算结果
+type CouponStackResult struct {
+	OrderAmount int64              `json:"order_amount"`
+	Discount    int64              `json:"discount"`
+	PayAmount   int64              `json:"pay_amount"`
+	Selected    []*CouponQuote     `json:"selected"`
+	Rejected    []*CouponRejection `json:"rejected"`
+}
+
+// EvaluateCouponStack 计算用户所选优惠券的最优叠加组合
+func EvaluateCouponStack(userID uint64, couponIDs []uuid.UUID, orderAmount int64) (*CouponStackResult, error) {
+	couponDao := db.NewCouponDao(dao.PgDB)
+	userCoupons, err := couponDao.GetUserCouponsByIDs(userID, couponIDs)
+	if err != nil {
+		log.Error("EvaluateCouponStack GetUserCouponsByIDs error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	templateIDs := make([]string, 0, len(userCoupons))
+	for _, userCoupon := range userCoupons {
+		templateIDs = append(templateIDs, userCoupon.TemplateID)
+	}
+	templates, err := couponDao.GetCouponTemplatesByIDs(templateIDs)
+	if err != nil {
+		log.Error("EvaluateCouponStack GetCouponTemplatesByIDs error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	candidates := make(map[uuid.UUID]*CouponCandidate, len(userCoupons))
+	for _, userCoupon := range userCoupons {
+		if couponTemplate, ok := templates[userCoupon.TemplateID]; ok && couponTemplate.Status != model.CouponTemplateStatusDeleted {
+			candidates[userCoupon.CouponID] = &CouponCandidate{Coupon: userCoupon, Template: couponTemplate}
+		}
+	}
+	return SelectCouponCombination(orderAmount, time.Now().UnixMilli(), couponIDs, candidates, DefaultCouponStackRule)
+}
+
+// SelectCouponCombination 按叠加规则选出优惠金额最大的组合
+// 优惠相同时依次优先:满赠券更多、用券更少、所用优惠券过期更早;未选用的优惠券均给出原因
+func SelectCouponCombination(orderAmount, now int64, couponIDs []uuid.UUID, candidates map[uuid.UUID]*CouponCandidate, rule *CouponStackRule) (*CouponStackResult, error) {
+	if orderAmount <= 0 {
+		return nil, ErrCouponOrderInvalid
+	}
+	if len(couponIDs) > rule.MaxCoupons {
+		return nil, fmt.Errorf("%w: at most %d coupons", ErrCouponOrderInvalid, rule.MaxCoupons)
+	}
+	result := &CouponStackResult{
+		OrderAmount: orderAmount,
+		PayAmount:   orderAmount,
+		Selected:    make([]*CouponQuote, 0),
+		Rejected:    make([]*CouponRejection, 0),
+	}
+
+	// 逐张校验可用性
+	seen := make(map[uuid.UUID]bool, len(couponIDs))
+	valid := make([]*CouponCandidate, 0, len(couponIDs))
+	quotes := make([]*CouponQuote, 0, len(couponIDs))
+	for _, couponID := range couponIDs {
+		if seen[couponID] {
+			result.Rejected = append(result.Rejected, &CouponRejection{CouponID: couponID, Reason: CouponRejectDuplicate, Detail: "coupon submitted more than once"})
+			continue
+		}
+		seen[couponID] = true
+		candidate := candidates[couponID]
+		if rejection := checkCouponCandidate(couponID, candidate, orderAmount, now, rule); rejection != nil {
+			result.Rejected = append(result.Rejected, rejection)
+			continue
+		}
+		quote, err := evaluateCoupon(candidate.Template, candidate.Coupon, orderAmount, now)
+		if err != nil {
+			return nil, err
+		}
+		valid = append(valid, candidate)
+		quotes = append(quotes, quote)
+	}
+
+	// 枚举所有满足规则的组合(数量受MaxCoupons限制)
+	var best uint
+	var bestScore *couponComboScore
+	for mask := uint(1); mask < 1<<len(valid); mask++ {
+		if !isCouponComboAllowed(valid, mask, rule) {
+			continue
+		}
+		score := scoreCouponCombo(valid, quotes, mask, orderAmount)
+		if bestScore == nil || score.betterThan(bestScore) {
+			best, bestScore = mask, score
+		}
+	}
+	if bestScore != nil {
+		result.Discount = bestScore.discount
+		result.PayAmount = orderAmount - bestScore.discount
+	}
+
+	selected := make([]*CouponCandidate, 0, bits.OnesCount(best))
+	for i := range valid {
+		if best&(1<<i) != 0 {
+			selected = append(selected, valid[i])
+			result.Selected = append(result.Selected, quotes[i])
+		}
+	}
+	for i, candidate := range valid {
+		if best&(1<<i) == 0 {
+			result.Rejected = append(result.Rejected, explainCouponConflict(candidate, selected, rule))
+		}
+	}
+	return result, nil
+}
+
+// checkCouponCandidate 校验单张优惠券是否可用于订单,不可用时返回原因
+func checkCouponCandidate(couponID uuid.UUID, candidate *CouponCandidate, orderAmount, now int64, rule *CouponStackRule) *CouponRejection {
+	rejection := &CouponRejection{CouponID: couponID}
+	switch {
+	case candidate == nil || candidate.Coupon == nil || candidate.Template == nil:
+		rejection.Reason, rejection.Detail = CouponRejectNotFound, "coupon not found"
+	case candidate.Coupon.CouponStatus == model.CouponStatusExpired || candidate.Coupon.ExpiredAt <= now:
+		rejection.Reason, rejection.Detail = CouponRejectExpired, "coupon expired"
+	case candidate.Coupon.CouponStatus != model.CouponStatusAvailable:
+		rejection.Reason, rejection.Detail = CouponRejectUnavailable, fmt.Sprintf("coupon status %d", candidate.Coupon.CouponStatus)
+	case now < candidate.Template.ValidStart:
+		rejection.Reason, rejection.Detail = CouponRejectNotStarted, "coupon not started"
+	case orderAmount < candidate.Template.Threshold:
+		rejection.Reason, rejection.Detail = CouponRejectBelowThreshold, fmt.Sprintf("order amount below threshold %d", candidate.Template.Threshold)
+	case rule.MaxPerType[candidate.Template.CouponType] <= 0:
+		rejection.Reason, rejection.Detail = CouponRejectTypeLimit, fmt.Sprintf("coupon type %d not allowed", candidate.Template.CouponType)
+	default:
+		return nil
+	}
+	return rejection
+}
+
+// isCouponComboAllowed 组合是否满足类型张数、活动及互斥组规则
+func isCouponComboAllowed(valid []*CouponCandidate, mask uint, rule *CouponStackRule) bool {
+	typeCount := make(map[model.CouponType]int)
+	campaigns := make(map[string]bool)
+	groups := make(map[string]bool)
+	for i, candidate := range valid {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		couponTemplate := candidate.Template
+		typeCount[couponTemplate.CouponType]++
+		if typeCount[couponTemplate.CouponType] > rule.MaxPerType[couponTemplate.CouponType] {
+			return false
+		}
+		if couponTemplate.CampaignID != "" {
+			if campaigns[couponTemplate.CampaignID] {
+				return false
+			}
+			campaigns[couponTemplate.CampaignID] = true
+		}
+		if couponTemplate.ExclGroup != "" {
+			if groups[couponTemplate.ExclGroup] {
+				return false
+			}
+			groups[couponTemplate.ExclGroup] = true
+		}
+	}
+	return true
+}
+
+type couponComboScore struct {
+	discount  int64 // 总优惠(不超过订单金额)
+	gifts     int   // 满赠券数
+	expirySum int64 // 所用优惠券过期时间之和(越小越优先使用即将过期的券)
+	count     int
+}
+
+func scoreCouponCombo(valid []*CouponCandidate, quotes []*CouponQuote, mask uint, orderAmount int64) *couponComboScore {
+	score := &couponComboScore{}
+	for i, candidate := range valid {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		score.discount += quotes[i].Discount
+		if candidate.Template.CouponType == model.CouponTypeFullGift {
+			score.gifts++
+		}
+		score.expirySum += candidate.Coupon.ExpiredAt
+		score.count++
+	}
+	score.discount = min(score.discount, orderAmount)
+	return score
+}
+
+func (s *couponComboScore) betterThan(other *couponComboScore) bool {
+	if s.discount != other.discount {
+		return s.discount > other.discount
+	}
+	if s.gifts != other.gifts {
+		return s.gifts > other.gifts
+	}
+	// 优惠相同时使用更少的券
+	if s.count != other.count {
+		return s.count < other.count
+	}
+	return s.expirySum < other.expirySum
+}
+
+// explainCouponConflict 说明可用优惠券未被选入最优组合的原因
+func explainCouponConflict(candidate *CouponCandidate, selected []*CouponCandidate, rule *CouponStackRule) *CouponRejection {
+	rejection := &CouponRejection{CouponID: candidate.Coupon.CouponID}
+	couponTemplate := candidate.Template
+	sameType := 0
+	for _, other := range selected {
+		otherTemplate := other.Template
+		if couponTemplate.ExclGroup != "" && couponTemplate.ExclGroup == otherTemplate.ExclGroup {
+			rejection.Reason = CouponRejectExclGroup
+			rejection.Detail = fmt.Sprintf("exclusive group %s already used by coupon %s", couponTemplate.ExclGroup, other.Coupon.CouponID)
+			return rejection
+		}
+		if couponTemplate.CampaignID != "" && couponTemplate.CampaignID == otherTemplate.CampaignID {
+			rejection.Reason = CouponRejectSameCampaign
+			rejection.Detail = fmt.Sprintf("campaign %s already used by coupon %s", couponTemplate.CampaignID, other.Coupon.CouponID)
+			return rejection
+		}
+		if couponTemplate.CouponType == otherTemplate.CouponType {
+			sameType++
+		}
+	}
+	if sameType >= rule.MaxPerType[couponTemplate.CouponType] {
+		rejection.Reason = CouponRejectTypeLimit
+		rejection.Detail = fmt.Sprintf("coupon type %d limited to %d", couponTemplate.CouponType, rule.MaxPerType[couponTemplate.CouponType])
+		return rejection
+	}
+	rejection.Reason = CouponRejectNoExtraBenefit
+	rejection.Detail = "order discount already reaches the order amount"
+	return rejection
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/dbuffer"
+	"ppt/log"
+	"ppt/model"
+	"time"
+)
+
+var ErrInventoryKindInvalid = errors.New("inventory kind invalid")
+
+// MailClaimResult 邮件附件领取结果
+type MailClaimResult struct {
+	Ledgers     []*model.UserCoinLedger
+	Inventories []*model.UserInventory
+}
+
+// ClaimMailAccessory 领取邮件附件并入账,在玩家actor中串行执行
+// 领取与流水、物品写入在同一事务中完成,入账按流水幂等,重复请求返回相同结果
+func ClaimMailAccessory(ctx context.Context, userID uint64, mailID string) (*MailClaimResult, error) {
+	return askRole(ctx, userID, roleMsgMailClaim, &mailClaimPayload{MailID: mailID}, func() (*MailClaimResult, error) {
+		return claimMailAccessory(userID, mailID)
+	})
+}
+
+func claimMailAccessory(userID uint64, mailID string) (*MailClaimResult, error) {
+	ledgers, inventories, err := db.NewUserMailDao(dao.PgDB).ClaimMailAccessoryByTx(userID, mailID, time.Now().UnixMilli(), mintInventoryID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ledger := range ledgers {
+		if err = ApplyCoinLedger(ledger); err != nil {
+			return nil, err
+		}
+	}
+	return &MailClaimResult{Ledgers: ledgers, Inventories: inventories}, nil
+}
+
+// mintInventoryID 通过双buffer号段生成物品实例ID
+func mintInventoryID(kind string) (int64, error) {
+	if dbuffer.DBuffer == nil {
+		return 0, errors.New("dbuffer not initialized")
+	}
+	switch kind {
+	case code.AwardKindItem:
+		return dbuffer.GetUUIDByType(dbuffer.UUID_TYPE_ITEMID)
+	case code.AwardKindPet:
+		return dbuffer.GetUUIDByType(dbuffer.UUID_TYPE_PETID)
+	}
+	return 0, ErrInventoryKindInvalid
+}
+
+// ApplyCoinLedger 货币流水入账,以PG流水状态保证同一流水只入账一次
+func ApplyCoinLedger(ledger *model.UserCoinLedger) error {
+	if ledger.Status == model.CoinLedgerStatusApplied {
+		return nil
+	}
+	_, err := db.ApplyCoinLedgerByTx(dao.PgDB, ledger.ID, func(locked *model.UserCoinLedger) error {
+		return db.UpdateUserCoinByLedger(dao.MongoClient, locked.UserID, locked.ID, locked.CoinType, locked.Amount)
+	})
+	if err != nil {
+		log.Error("ApplyCoinLedger ApplyCoinLedgerByTx error", zap.Any("ledger", ledger), zap.Error(err))
+		return err
+	}
+	ledger.Status = model.CoinLedgerStatusApplied
+	return nil
+}
+
+// ApplyUserPendingCoinLedgers 补偿入账用户未完成的货币流水
+func ApplyUserPendingCoinLedgers(userID uint64) error {
+	ledgers, err := db.GetUserPendingCoinLedgers(dao.PgDB, userID)
+	if err != nil {
+		log.Error("ApplyUserPendingCoinLedgers GetUserPendingCoinLedgers error", zap.Uint64("user_id", userID), zap.Error(err))
+		return err
+	}
+	for _, ledger := range ledgers {
+		if err = ApplyCoinLedger(ledger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
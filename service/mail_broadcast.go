@@ -0,0 +1,458 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+	"ppt/mq"
+	"ppt/util"
+	"sort"
+	"time"
+)
+
+var (
+	ErrMailTemplateNotFound    = errors.New("mail template not found")
+	ErrMailAudienceInvalid     = errors.New("mail audience invalid")
+	ErrMailScheduleTimeInvalid = errors.New("mail schedule time invalid")
+	ErrMailBroadcastStatus     = errors.New("mail broadcast status not allowed")
+	ErrMailParamsInvalid       = errors.New("mail params invalid")
+)
+
+// MailBroadcastPayload 群发批次任务参数
+type MailBroadcastPayload struct {
+	BroadcastID string `json:"broadcast_id"`
+}
+
+// InitMailService 注册邮件相关异步任务
+func InitMailService() {
+	mq.RegisterPptTaskHandler(model.AsynqTaskTypeMail, handleMailBroadcastTask)
+}
+
+// CreateMailBroadcast 创建群发任务并投递首个批次
+func CreateMailBroadcast(templateID string, audience *model.MailAudience, params *model.MailSendParams, operator string) (*model.MailBroadcast, error) {
+	broadcast, err := newMailBroadcast(templateID, audience, params, operator, model.MailBroadcastStatusPending, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = enqueueMailBroadcastBatch(broadcast.ID, broadcast.LastUserID); err != nil {
+		return nil, err
+	}
+	log.Info("CreateMailBroadcast success", zap.String("broadcast_id", broadcast.ID), zap.String("template_id", templateID), zap.Int64("total", broadcast.Total), zap.String("operator", operator))
+	return broadcast, nil
+}
+
+// ScheduleMailBroadcast 创建定时群发任务(投递至延时队列)
+func ScheduleMailBroadcast(templateID string, audience *model.MailAudience, params *model.MailSendParams, sendTime time.Time, operator string) (*model.MailBroadcast, error) {
+	if !sendTime.After(time.Now()) {
+		return nil, ErrMailScheduleTimeInvalid
+	}
+	taskID := fmt.Sprintf("mail_broadcast_schedule:%s", uuid.NewString())
+	broadcast, err := newMailBroadcast(templateID, audience, params, operator, model.MailBroadcastStatusSchedule, sendTime.UnixMilli(), taskID)
+	if err != nil {
+		return nil, err
+	}
+	task, err := mq.NewPptTask(model.AsynqTaskTypeMail, taskID, &MailBroadcastPayload{BroadcastID: broadcast.ID})
+	if err != nil {
+		log.Error("ScheduleMailBroadcast NewPptTask error", zap.String("broadcast_id", broadcast.ID), zap.Error(err))
+		return nil, err
+	}
+	if err = mq.EnqueueTaskLatency(task, sendTime, asynq.TaskID(taskID)); err != nil {
+		_, _ = db.NewMailBroadcastDao(dao.PgDB).UpdateMailBroadcastStatus(broadcast.ID, model.MailBroadcastStatusSchedule, model.MailBroadcastStatusFailed, err.Error())
+		return nil, err
+	}
+	log.Info("ScheduleMailBroadcast success", zap.String("broadcast_id", broadcast.ID), zap.String("task_id", taskID), zap.Time("send_time", sendTime), zap.String("operator", operator))
+	return broadcast, nil
+}
+
+// CancelMailBroadcast 取消尚未开始的定时群发
+func CancelMailBroadcast(id, operator string) error {
+	broadcastDao := db.NewMailBroadcastDao(dao.PgDB)
+	broadcast, err := broadcastDao.GetMailBroadcastByID(id)
+	if err != nil {
+		return err
+	}
+	// 先更新状态,定时任务即使已触发也不会再投递
+	ok, err := broadcastDao.CloseMailBroadcast(id, []model.MailBroadcastStatusType{model.MailBroadcastStatusSchedule}, model.MailBroadcastStatusCanceled, operator)
+	if err != nil {
+		log.Error("CancelMailBroadcast CloseMailBroadcast error", zap.String("broadcast_id", id), zap.Error(err))
+		return err
+	}
+	if !ok {
+		return ErrMailBroadcastStatus
+	}
+	if err = mq.DelTaskLatency(broadcast.TaskID); err != nil {
+		log.Warn("CancelMailBroadcast DelTaskLatency error", zap.String("broadcast_id", id), zap.String("task_id", broadcast.TaskID), zap.Error(err))
+	}
+	log.Info("CancelMailBroadcast success", zap.String("broadcast_id", id), zap.String("operator", operator))
+	return nil
+}
+
+// RevokeMailBroadcast 撤销群发(停止后续投递并撤销已投递邮件),返回撤销邮件数
+func RevokeMailBroadcast(id, operator string) (int64, error) {
+	fromStatuses := []model.MailBroadcastStatusType{
+		model.MailBroadcastStatusPending,
+		model.MailBroadcastStatusRunning,
+		model.MailBroadcastStatusFinished,
+		model.MailBroadcastStatusFailed,
+		model.MailBroadcastStatusRevoked,
+	}
+	broadcastDao := db.NewMailBroadcastDao(dao.PgDB)
+	if _, err := broadcastDao.GetMailBroadcastByID(id); err != nil {
+		return 0, err
+	}
+	ok, err := broadcastDao.CloseMailBroadcast(id, fromStatuses, model.MailBroadcastStatusRevoked, operator)
+	if err != nil {
+		log.Error("RevokeMailBroadcast CloseMailBroadcast error", zap.String("broadcast_id", id), zap.Error(err))
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrMailBroadcastStatus
+	}
+	var revoked int64
+	mailDao := db.NewUserMailDao(dao.PgDB)
+	for {
+		userIDs, err := mailDao.RevokeMailsByBroadcastAndBatch(id, operator, int32(dao.MailRevokeBatchSize))
+		if err != nil {
+			return revoked, err
+		}
+		ResetUserMailCounters(userIDs)
+		revoked += int64(len(userIDs))
+		if len(userIDs) < dao.MailRevokeBatchSize {
+			break
+		}
+	}
+	log.Info("RevokeMailBroadcast success", zap.String("broadcast_id", id), zap.Int64("revoked", revoked), zap.String("operator", operator))
+	return revoked, nil
+}
+
+func newMailBroadcast(templateID string, audience *model.MailAudience, params *model.MailSendParams, operator string, status model.MailBroadcastStatusType, scheduleTime int64, taskID string) (*model.MailBroadcast, error) {
+	if err := checkMailAudience(audience); err != nil {
+		return nil, err
+	}
+	if err := checkMailSendParams(params); err != nil {
+		return nil, err
+	}
+	if _, err := getUsableMailTemplate(templateID); err != nil {
+		return nil, err
+	}
+	if audience.Type == model.MailAudienceActive && audience.ActiveDate == "" {
+		// 固定活跃日期,避免跨天续发时受众变化
+		audience.ActiveDate = util.TimeToDateStr(time.Now())
+	}
+	total, err := countMailAudience(audience)
+	if err != nil {
+		log.Error("newMailBroadcast countMailAudience error", zap.Any("audience", audience), zap.Error(err))
+		return nil, err
+	}
+	audienceBytes, err := json.Marshal(audience)
+	if err != nil {
+		return nil, err
+	}
+	broadcast := &model.MailBroadcast{
+		TemplateID:   templateID,
+		Audience:     audienceBytes,
+		Status:       status,
+		Total:        total,
+		Operator:     operator,
+		ScheduleTime: scheduleTime,
+		TaskID:       taskID,
+	}
+	if params != nil && (len(params.Params) > 0 || len(params.UserParams) > 0) {
+		if broadcast.Params, err = json.Marshal(params); err != nil {
+			return nil, err
+		}
+	}
+	if err = db.NewMailBroadcastDao(dao.PgDB).CreateMailBroadcast(broadcast); err != nil {
+		log.Error("newMailBroadcast CreateMailBroadcast error", zap.Any("broadcast", broadcast), zap.Error(err))
+		return nil, err
+	}
+	return broadcast, nil
+}
+
+// GetMailBroadcast 获取群发进度
+func GetMailBroadcast(id string) (*model.MailBroadcast, error) {
+	return db.NewMailBroadcastDao(dao.PgDB).GetMailBroadcastByID(id)
+}
+
+// ResumeStaleMailBroadcasts 重新投递中断的群发任务
+func ResumeStaleMailBroadcasts() {
+	before := time.Now().Add(-dao.MailBroadcastStaleDuration).UnixMilli()
+	broadcasts, err := db.NewMailBroadcastDao(dao.PgDB).GetStaleMailBroadcasts(before)
+	if err != nil {
+		log.Error("ResumeStaleMailBroadcasts GetStaleMailBroadcasts error", zap.Error(err))
+		return
+	}
+	for _, broadcast := range broadcasts {
+		log.Info("ResumeStaleMailBroadcasts resume broadcast", zap.String("broadcast_id", broadcast.ID), zap.Uint64("last_user_id", broadcast.LastUserID))
+		_ = enqueueMailBroadcastBatch(broadcast.ID, broadcast.LastUserID)
+	}
+}
+
+func enqueueMailBroadcastBatch(broadcastID string, lastUserID uint64) error {
+	// 同一游标的批次任务ID相同,避免重复入队
+	taskID := fmt.Sprintf("mail_broadcast:%s:%d", broadcastID, lastUserID)
+	task, err := mq.NewPptTask(model.AsynqTaskTypeMail, taskID, &MailBroadcastPayload{BroadcastID: broadcastID})
+	if err != nil {
+		log.Error("enqueueMailBroadcastBatch NewPptTask error", zap.String("broadcast_id", broadcastID), zap.Error(err))
+		return err
+	}
+	err = mq.EnqueueTaskInstant(task, asynq.TaskID(taskID))
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return err
+	}
+	return nil
+}
+
+func handleMailBroadcastTask(ctx context.Context, task *model.PptAsynqTask) error {
+	var payload MailBroadcastPayload
+	if err := json.Unmarshal(task.Payload, &payload); err != nil {
+		log.Error("handleMailBroadcastTask unmarshal payload error", zap.Any("task", task), zap.Error(err))
+		return err
+	}
+	broadcastDao := db.NewMailBroadcastDao(dao.PgDB)
+	broadcast, err := broadcastDao.GetMailBroadcastByID(payload.BroadcastID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Warn("handleMailBroadcastTask broadcast not found", zap.String("broadcast_id", payload.BroadcastID))
+			return nil
+		}
+		return err
+	}
+	switch broadcast.Status {
+	case model.MailBroadcastStatusPending, model.MailBroadcastStatusSchedule:
+		ok, err := broadcastDao.UpdateMailBroadcastStatus(broadcast.ID, broadcast.Status, model.MailBroadcastStatusRunning, "")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// 定时任务已被取消或已由其它任务启动
+			log.Info("handleMailBroadcastTask broadcast status changed", zap.String("broadcast_id", broadcast.ID))
+			return nil
+		}
+	case model.MailBroadcastStatusRunning:
+	default:
+		log.Info("handleMailBroadcastTask broadcast already done", zap.String("broadcast_id", broadcast.ID), zap.Any("status", broadcast.Status))
+		return nil
+	}
+
+	mailTemplate, err := getUsableMailTemplate(broadcast.TemplateID)
+	if err != nil {
+		if errors.Is(err, ErrMailTemplateNotFound) {
+			_, _ = broadcastDao.UpdateMailBroadcastStatus(broadcast.ID, model.MailBroadcastStatusRunning, model.MailBroadcastStatusFailed, err.Error())
+			return nil
+		}
+		return err
+	}
+	audience := &model.MailAudience{}
+	if err = json.Unmarshal(broadcast.Audience, audience); err != nil {
+		_, _ = broadcastDao.UpdateMailBroadcastStatus(broadcast.ID, model.MailBroadcastStatusRunning, model.MailBroadcastStatusFailed, err.Error())
+		return nil
+	}
+
+	userIDs, err := nextMailAudienceBatch(audience, broadcast.LastUserID, dao.MailBroadcastBatchSize)
+	if err != nil {
+		log.Error("handleMailBroadcastTask nextMailAudienceBatch error", zap.String("broadcast_id", broadcast.ID), zap.Error(err))
+		return err
+	}
+	if len(userIDs) == 0 {
+		if _, err = broadcastDao.UpdateMailBroadcastStatus(broadcast.ID, model.MailBroadcastStatusRunning, model.MailBroadcastStatusFinished, ""); err != nil {
+			return err
+		}
+		log.Info("handleMailBroadcastTask broadcast finished", zap.String("broadcast_id", broadcast.ID), zap.Int64("total", broadcast.Total), zap.Int64("delivered", broadcast.Delivered))
+		return nil
+	}
+
+	newLastUserID := userIDs[len(userIDs)-1]
+	userMails, err := buildUserMails(broadcast, mailTemplate, userIDs)
+	if err != nil {
+		log.Error("handleMailBroadcastTask buildUserMails error", zap.String("broadcast_id", broadcast.ID), zap.Error(err))
+		return err
+	}
+	delivered, err := broadcastDao.DeliverMailBatchByTx(broadcast.ID, broadcast.LastUserID, newLastUserID, userMails)
+	if err != nil {
+		if errors.Is(err, db.ErrMailBroadcastCursorMoved) {
+			log.Info("handleMailBroadcastTask cursor moved by other task", zap.String("broadcast_id", broadcast.ID), zap.Uint64("last_user_id", broadcast.LastUserID))
+			return nil
+		}
+		log.Error("handleMailBroadcastTask DeliverMailBatchByTx error", zap.String("broadcast_id", broadcast.ID), zap.Error(err))
+		return err
+	}
+	ResetUserMailCounters(userIDs)
+	log.Info("handleMailBroadcastTask deliver batch success", zap.String("broadcast_id", broadcast.ID), zap.Uint64("last_user_id", newLastUserID),
+		zap.Int64("batch_delivered", delivered), zap.Int64("delivered", broadcast.Delivered+delivered), zap.Int64("total", broadcast.Total))
+	return enqueueMailBroadcastBatch(broadcast.ID, newLastUserID)
+}
+
+func getUsableMailTemplate(templateID string) (*model.MailTemplate, error) {
+	mailTemplate, err := db.NewUserMailDao(dao.PgDB).GetMailTemplateByID(templateID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMailTemplateNotFound
+		}
+		return nil, err
+	}
+	if mailTemplate.Status == code.MailTemplateStatusDeleted {
+		return nil, ErrMailTemplateNotFound
+	}
+	return mailTemplate, nil
+}
+
+func buildUserMails(broadcast *model.MailBroadcast, mailTemplate *model.MailTemplate, userIDs []uint64) ([]*model.UserMail, error) {
+	params := &model.MailSendParams{}
+	if len(broadcast.Params) > 0 {
+		if err := json.Unmarshal(broadcast.Params, params); err != nil {
+			return nil, err
+		}
+	}
+	validDays := mailTemplate.ValidDays
+	if validDays <= 0 {
+		validDays = code.MailDefaultValidDays
+	}
+	expiredTime := time.Now().AddDate(0, 0, int(validDays)).UnixMilli()
+	userMails := make([]*model.UserMail, 0, len(userIDs))
+	for _, userID := range userIDs {
+		userMail := &model.UserMail{
+			UserID:          userID,
+			TemplateID:      mailTemplate.ID,
+			BroadcastID:     broadcast.ID,
+			Awards:          mailTemplate.Awards,
+			ExpiredTime:     expiredTime,
+			ReadStatus:      model.MailReadStatusUnRead,
+			AccessoryStatus: model.MailAccessoryStatusUnReceive,
+			VisibleStatus:   model.MailVisibleStatusDefault,
+			Operator:        broadcast.Operator,
+		}
+		if userParams := params.GetUserParams(userID); len(userParams) > 0 {
+			paramsBytes, err := json.Marshal(userParams)
+			if err != nil {
+				return nil, err
+			}
+			userMail.Params = paramsBytes
+		}
+		userMails = append(userMails, userMail)
+	}
+	return userMails, nil
+}
+
+// checkMailSendParams 校验个性化变量名(不可覆盖内置变量)
+func checkMailSendParams(params *model.MailSendParams) error {
+	if params == nil {
+		return nil
+	}
+	for name := range params.Params {
+		if !model.IsMailVarName(name) {
+			return fmt.Errorf("%w: invalid param name %s", ErrMailParamsInvalid, name)
+		}
+	}
+	for userID, userParams := range params.UserParams {
+		for name := range userParams {
+			if !model.IsMailVarName(name) {
+				return fmt.Errorf("%w: user %d invalid param name %s", ErrMailParamsInvalid, userID, name)
+			}
+		}
+	}
+	return nil
+}
+
+func checkMailAudience(audience *model.MailAudience) error {
+	if audience == nil {
+		return ErrMailAudienceInvalid
+	}
+	switch audience.Type {
+	case model.MailAudienceAll, model.MailAudienceActive:
+		return nil
+	case model.MailAudienceUserIDs:
+		if len(audience.UserIDs) > 0 {
+			return nil
+		}
+	case model.MailAudienceBrand:
+		if audience.BrandID > 0 {
+			return nil
+		}
+	case model.MailAudienceChannel:
+		if audience.Channel != "" {
+			return nil
+		}
+	case model.MailAudienceLang:
+		if audience.Lang != "" {
+			return nil
+		}
+	}
+	return ErrMailAudienceInvalid
+}
+
+// mailAudienceConds 受众对应的用户表查询条件
+func mailAudienceConds(audience *model.MailAudience) map[string]interface{} {
+	switch audience.Type {
+	case model.MailAudienceBrand:
+		return map[string]interface{}{"brand_id": audience.BrandID}
+	case model.MailAudienceChannel:
+		return map[string]interface{}{"channel": audience.Channel}
+	case model.MailAudienceLang:
+		return map[string]interface{}{"lang": audience.Lang}
+	}
+	return nil
+}
+
+func countMailAudience(audience *model.MailAudience) (int64, error) {
+	switch audience.Type {
+	case model.MailAudienceUserIDs:
+		return int64(len(audience.UserIDs)), nil
+	case model.MailAudienceActive:
+		return dao.RedisDB.SCard(dao.Ctx, mailAudienceActiveKey(audience)).Result()
+	}
+	return model.CountUsers(dao.PgDB, mailAudienceConds(audience))
+}
+
+// nextMailAudienceBatch 获取游标之后的下一批受众(按UserID升序)
+func nextMailAudienceBatch(audience *model.MailAudience, lastUserID uint64, limit int) ([]uint64, error) {
+	switch audience.Type {
+	case model.MailAudienceUserIDs:
+		return NextUserIDsBatch(audience.UserIDs, lastUserID, limit), nil
+	case model.MailAudienceActive:
+		userIDs, err := db.GetActiveUsers(dao.RedisDB, mailAudienceActiveKey(audience))
+		if err != nil {
+			return nil, err
+		}
+		return NextUserIDsBatch(userIDs, lastUserID, limit), nil
+	}
+	return model.GetUserIDsByCursor(dao.PgDB, lastUserID, limit, mailAudienceConds(audience))
+}
+
+func mailAudienceActiveKey(audience *model.MailAudience) string {
+	activeDate := audience.ActiveDate
+	if activeDate == "" {
+		activeDate = util.TimeToDateStr(time.Now())
+	}
+	return fmt.Sprintf(dao.UserActiveKey, activeDate)
+}
+
+// NextUserIDsBatch 按UserID升序去重后取游标lastUserID之后的至多limit个用户
+func NextUserIDsBatch(userIDs []uint64, lastUserID uint64, limit int) []uint64 {
+	sorted := make([]uint64, len(userIDs))
+	copy(sorted, userIDs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	start := sort.Search(len(sorted), func(i int) bool {
+		return sorted[i] > lastUserID
+	})
+	batch := make([]uint64, 0, limit)
+	for i := start; i < len(sorted) && len(batch) < limit; i++ {
+		if len(batch) > 0 && batch[len(batch)-1] == sorted[i] {
+			continue
+		}
+		batch = append(batch, sorted[i])
+	}
+	return batch
+}
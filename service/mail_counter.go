@@ -0,0 +1,77 @@
+package service
+
+import (
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+	"time"
+)
+
+const MailCounterNoticeType = "mail_counter" // 邮件红点计数变更通知
+
+// GetUserMailCounter 获取用户邮件红点计数(优先读取缓存,未命中时从数据库重建)
+func GetUserMailCounter(userID uint64) (*model.UserMailCounter, error) {
+	counter, err := db.GetUserMailCounter(dao.RedisDB, userID)
+	if err == nil && counter != nil {
+		return counter, nil
+	}
+	return loadUserMailCounter(userID)
+}
+
+// RefreshUserMailCounter 用户邮件状态变更后清除红点计数并通知在线会话
+// 不在此处重新统计写入缓存,避免并发变更时较慢的统计结果覆盖较新的计数
+func RefreshUserMailCounter(userID uint64) {
+	ResetUserMailCounters([]uint64{userID})
+}
+
+// ResetUserMailCounters 批量邮件变更(群发/撤销)后清除红点计数并通知在线会话刷新
+func ResetUserMailCounters(userIDs []uint64) {
+	if err := db.DelUserMailCounters(dao.RedisDB, userIDs); err != nil {
+		return
+	}
+	for _, userID := range userIDs {
+		publishMailCounterNotice(userID, nil)
+	}
+}
+
+// NotifyExpiredUserMails 计数邮件到期的用户清除红点计数并通知在线会话
+func NotifyExpiredUserMails() {
+	for {
+		userIDs, err := db.PopUserMailExpireNotices(dao.RedisDB, time.Now().UnixMilli(), dao.UserMailExpireNoticeBatch)
+		if err != nil || len(userIDs) == 0 {
+			return
+		}
+		ResetUserMailCounters(userIDs)
+	}
+}
+
+// loadUserMailCounter 从数据库统计红点计数并写入缓存
+// 缓存在计数邮件中最早过期时失效,到期后由NotifyExpiredUserMails通知在线会话
+func loadUserMailCounter(userID uint64) (*model.UserMailCounter, error) {
+	counter, err := db.NewUserMailDao(dao.PgDB).CountUserMailCounter(userID, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	if err = db.SetUserMailCounter(dao.RedisDB, userID, counter); err != nil {
+		// 缓存失败不影响返回结果
+		_ = db.DelUserMailCounters(dao.RedisDB, []uint64{userID})
+	}
+	return counter, nil
+}
+
+// publishMailCounterNotice 发布红点计数变更通知(counter为nil时仅通知客户端重新拉取)
+func publishMailCounterNotice(userID uint64, counter *model.UserMailCounter) {
+	data := map[string]interface{}{
+		"type":    MailCounterNoticeType,
+		"user_id": userID,
+	}
+	if counter != nil {
+		data["unread"] = counter.Unread
+		data["unclaimed"] = counter.Unclaimed
+	}
+	if err := db.NewDynamicNotice(dao.RedisDB, dao.UserDynamicNoticeChannel, userID, data); err != nil {
+		log.Warn("publishMailCounterNotice NewDynamicNotice error", zap.Uint64("user_id", userID), zap.Error(err))
+	}
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+)
+
+var (
+	ErrMailTemplateInvalid   = errors.New("mail template invalid")
+	ErrMailTemplateDelivered = errors.New("mail template already delivered")
+)
+
+// MailTemplateParam 邮件模板编辑参数,多语言字段以语言为key
+// Awards的key为货币类型或物品奖励(item:配置ID/pet:配置ID)
+type MailTemplateParam struct {
+	SenderID    uint64            `json:"sender_id"`
+	SenderName  map[string]string `json:"sender_name" binding:"required"`
+	Title       map[string]string `json:"title" binding:"required"`
+	Content     map[string]string `json:"content" binding:"required"`
+	Awards      map[string]int64  `json:"awards"`
+	Type        int32             `json:"type"`
+	ValidDays   int32             `json:"valid_days"`
+	DefaultLang string            `json:"default_lang"` // 用户语言缺失时使用,默认code.LangDefault
+}
+
+// MailTemplatePreview 邮件模板指定语言预览
+type MailTemplatePreview struct {
+	ID         string             `json:"id"`
+	Lang       string             `json:"lang"`
+	SenderName string             `json:"sender_name"`
+	Title      string             `json:"title"`
+	Content    string             `json:"content"`
+	Awards     []*model.MailAward `json:"awards"`
+	Grants     []*model.MailGrant `json:"grants"`
+	ValidDays  int32              `json:"valid_days"`
+	Status     int32              `json:"status"`
+}
+
+// CreateMailTemplate 校验并创建邮件模板
+func CreateMailTemplate(param *MailTemplateParam) (*model.MailTemplate, error) {
+	mailTemplate, err := buildMailTemplate(param)
+	if err != nil {
+		return nil, err
+	}
+	mailTemplate.Status = code.MailTemplateStatusInUse
+	if err = db.NewUserMailDao(dao.PgDB).CreateMailTemplate(mailTemplate); err != nil {
+		log.Error("CreateMailTemplate error", zap.Any("param", param), zap.Error(err))
+		return nil, err
+	}
+	return mailTemplate, nil
+}
+
+// UpdateMailTemplate 校验并更新邮件模板,已投递给用户的模板不允许修改
+func UpdateMailTemplate(id string, param *MailTemplateParam) (*model.MailTemplate, error) {
+	if _, err := getUsableMailTemplate(id); err != nil {
+		return nil, err
+	}
+	mailTemplate, err := buildMailTemplate(param)
+	if err != nil {
+		return nil, err
+	}
+	mailDao := db.NewUserMailDao(dao.PgDB)
+	ok, err := mailDao.UpdateMailTemplateUndelivered(id, map[string]interface{}{
+		"sender_id":    mailTemplate.SenderID,
+		"sender_name":  mailTemplate.SenderName,
+		"title":        mailTemplate.Title,
+		"content":      mailTemplate.Content,
+		"awards":       mailTemplate.Awards,
+		"type":         mailTemplate.Type,
+		"valid_days":   mailTemplate.ValidDays,
+		"default_lang": mailTemplate.DefaultLang,
+	})
+	if err != nil {
+		log.Error("UpdateMailTemplate UpdateMailTemplateUndelivered error", zap.String("template_id", id), zap.Error(err))
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrMailTemplateDelivered
+	}
+	return mailDao.GetMailTemplateByID(id)
+}
+
+// GetMailTemplatesByPage 分页获取邮件模板
+func GetMailTemplatesByPage(page, size int, withDeleted bool) ([]*model.MailTemplate, int64, error) {
+	return db.NewUserMailDao(dao.PgDB).GetMailTemplatesByPage((page-1)*size, size, withDeleted)
+}
+
+// PreviewMailTemplate 按语言预览邮件模板(缺失时回退模板默认语言),vars用于替换占位符
+func PreviewMailTemplate(id, lang string, vars map[string]string) (*MailTemplatePreview, error) {
+	mailTemplate, err := db.NewUserMailDao(dao.PgDB).GetMailTemplateByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMailTemplateNotFound
+		}
+		return nil, err
+	}
+	awards, err := model.ParseMailAwards(mailTemplate.Awards)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := model.ParseMailGrants(mailTemplate.Awards)
+	if err != nil {
+		return nil, err
+	}
+	if lang == "" {
+		lang = mailTemplate.DefaultLang
+	}
+	return &MailTemplatePreview{
+		ID:         mailTemplate.ID,
+		Lang:       lang,
+		SenderName: model.GetLangText(mailTemplate.SenderName, lang, mailTemplate.DefaultLang),
+		Title:      model.RenderMailText(model.GetLangText(mailTemplate.Title, lang, mailTemplate.DefaultLang), vars),
+		Content:    model.RenderMailText(model.GetLangText(mailTemplate.Content, lang, mailTemplate.DefaultLang), vars),
+		Awards:     awards,
+		Grants:     grants,
+		ValidDays:  mailTemplate.ValidDays,
+		Status:     mailTemplate.Status,
+	}, nil
+}
+
+// DeleteMailTemplate 删除邮件模板,已投递邮件仍可正常展示
+func DeleteMailTemplate(id string) error {
+	ok, err := db.NewUserMailDao(dao.PgDB).DeleteMailTemplate(id)
+	if err != nil {
+		log.Error("DeleteMailTemplate error", zap.String("template_id", id), zap.Error(err))
+		return err
+	}
+	if !ok {
+		return ErrMailTemplateNotFound
+	}
+	return nil
+}
+
+// buildMailTemplate 校验编辑参数并转换为模板
+func buildMailTemplate(param *MailTemplateParam) (*model.MailTemplate, error) {
+	mailTemplate := &model.MailTemplate{
+		SenderID:    param.SenderID,
+		Type:        param.Type,
+		ValidDays:   param.ValidDays,
+		DefaultLang: param.DefaultLang,
+	}
+	if mailTemplate.DefaultLang == "" {
+		mailTemplate.DefaultLang = code.LangDefault
+	}
+	if !code.IsLang(mailTemplate.DefaultLang) {
+		return nil, fmt.Errorf("%w: unknown default_lang %s", ErrMailTemplateInvalid, mailTemplate.DefaultLang)
+	}
+	if mailTemplate.Type == 0 {
+		mailTemplate.Type = code.MailTypeSystem
+	}
+	if mailTemplate.ValidDays == 0 {
+		mailTemplate.ValidDays = code.MailDefaultValidDays
+	}
+	if mailTemplate.ValidDays < 0 {
+		return nil, fmt.Errorf("%w: valid_days must be positive", ErrMailTemplateInvalid)
+	}
+	var err error
+	if mailTemplate.SenderName, err = marshalLangText(ErrMailTemplateInvalid, "sender_name", param.SenderName); err != nil {
+		return nil, err
+	}
+	if mailTemplate.Title, err = marshalLangText(ErrMailTemplateInvalid, "title", param.Title); err != nil {
+		return nil, err
+	}
+	if mailTemplate.Content, err = marshalLangText(ErrMailTemplateInvalid, "content", param.Content); err != nil {
+		return nil, err
+	}
+	if err = checkAwards(ErrMailTemplateInvalid, param.Awards); err != nil {
+		return nil, err
+	}
+	if len(param.Awards) > 0 {
+		if mailTemplate.Awards, err = json.Marshal(param.Awards); err != nil {
+			return nil, err
+		}
+	}
+	return mailTemplate, nil
+}
+
+// marshalLangText 校验多语言文本覆盖全部已配置语言,校验失败时返回包装errInvalid的错误
+func marshalLangText(errInvalid error, field string, texts map[string]string) (datatypes.JSON, error) {
+	for _, lang := range code.Langs {
+		if texts[lang] == "" {
+			return nil, fmt.Errorf("%w: %s missing lang %s", errInvalid, field, lang)
+		}
+	}
+	for lang := range texts {
+		if !code.IsLang(lang) {
+			return nil, fmt.Errorf("%w: %s unknown lang %s", errInvalid, field, lang)
+		}
+	}
+	return json.Marshal(texts)
+}
+
+// checkAwards 校验奖励key为货币类型或物品奖励且数量有效,校验失败时返回包装errInvalid的错误
+func checkAwards(errInvalid error, awards map[string]int64) error {
+	for key, amount := range awards {
+		kind, _, isGrant := model.ParseMailGrantKey(key)
+		if !isGrant && !code.IsCoinType(key) {
+			return fmt.Errorf("%w: unknown award %s", errInvalid, key)
+		}
+		if amount <= 0 {
+			return fmt.Errorf("%w: award %s amount must be positive", errInvalid, key)
+		}
+		if kind == code.AwardKindPet && amount > code.AwardPetCountMax {
+			return fmt.Errorf("%w: award %s amount exceeds %d", errInvalid, key, code.AwardPetCountMax)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/netip"
+	"ppt/config"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/kafka"
+	"ppt/log"
+	"ppt/monitor"
+	"sync/atomic"
+	"time"
+)
+
+// 注册拦截原因
+const (
+	RegisterBlockDenyCIDR      = "deny_cidr"
+	RegisterBlockIPLimit       = "ip_limit"
+	RegisterBlockDeviceMissing = "device_missing"
+	RegisterBlockDeviceLimit   = "device_limit"
+)
+
+const registerDayLayout = "20060102"
+
+var (
+	ErrRegisterDenied  = errors.New("registration denied")
+	ErrRegisterLimited = errors.New("too many registrations")
+)
+
+const RegisterEventTypeBlocked = "register_blocked"
+
+// RegisterBlockedEvent 注册被拦截事件
+type RegisterBlockedEvent struct {
+	EventType  string `json:"event_type"`
+	Reason     string `json:"reason"`
+	IP         string `json:"ip"`
+	DeviceHash string `json:"device_hash"`
+	UserName   string `json:"user_name"`
+	BrandID    int32  `json:"brand_id"`
+	Channel    string `json:"channel"`
+	EventTime  int64  `json:"event_time"`
+}
+
+// RegisterGuard 注册防刷策略
+type RegisterGuard struct {
+	cfg   config.RegisterGuardConfig
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+var registerGuard atomic.Pointer[RegisterGuard]
+
+// NewRegisterGuard 解析注册防刷配置
+func NewRegisterGuard(cfg *config.RegisterGuardConfig) (*RegisterGuard, error) {
+	guard := &RegisterGuard{cfg: *cfg}
+	var err error
+	if guard.allow, err = parseCIDRs(cfg.AllowCIDRs); err != nil {
+		return nil, err
+	}
+	if guard.deny, err = parseCIDRs(cfg.DenyCIDRs); err != nil {
+		return nil, err
+	}
+	return guard, nil
+}
+
+// InitRegisterGuard 初始化注册防刷策略,未初始化时不做限制
+func InitRegisterGuard(cfg *config.RegisterGuardConfig) error {
+	guard, err := NewRegisterGuard(cfg)
+	if err != nil {
+		return err
+	}
+	registerGuard.Store(guard)
+	return nil
+}
+
+// MatchCIDR 按名单判断IP:denied-命中黑名单,allowed-命中白名单(黑名单优先)
+func (g *RegisterGuard) MatchCIDR(ip string) (denied, allowed bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range g.deny {
+		if prefix.Contains(addr) {
+			return true, false
+		}
+	}
+	for _, prefix := range g.allow {
+		if prefix.Contains(addr) {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// Reserve 校验注册请求并原子占用IP及设备指纹当日注册数,返回拦截原因(未拦截时为空)
+// 未拦截时返回release,注册失败时调用以归还占用;拦截或出错时已归还
+func (g *RegisterGuard) Reserve(ip, deviceHash string, now time.Time) (reason string, release func(), err error) {
+	denied, allowed := g.MatchCIDR(ip)
+	if denied {
+		return RegisterBlockDenyCIDR, nil, nil
+	}
+	if allowed {
+		return "", func() {}, nil
+	}
+	var reserved []string
+	release = func() {
+		for _, key := range reserved {
+			_ = db.DecrRegisterCount(dao.RedisDB, key)
+		}
+	}
+	// reserve 累加计数,超出上限时拦截
+	reserve := func(key string, max int64) (bool, error) {
+		count, err := db.IncrRegisterCount(dao.RedisDB, key)
+		if err != nil {
+			return false, err
+		}
+		reserved = append(reserved, key)
+		return count > max, nil
+	}
+	day := now.Format(registerDayLayout)
+	if g.cfg.IPDailyMax > 0 && ip != "" {
+		exceeded, err := reserve(fmt.Sprintf(dao.RegisterIPKey, day, ip), g.cfg.IPDailyMax)
+		if err != nil {
+			release()
+			return "", nil, err
+		}
+		if exceeded {
+			release()
+			return RegisterBlockIPLimit, nil, nil
+		}
+	}
+	if deviceHash == "" {
+		if g.cfg.RequireDevice {
+			release()
+			return RegisterBlockDeviceMissing, nil, nil
+		}
+		return "", release, nil
+	}
+	if g.cfg.DeviceDailyMax > 0 {
+		exceeded, err := reserve(fmt.Sprintf(dao.RegisterDeviceKey, day, deviceHash), g.cfg.DeviceDailyMax)
+		if err != nil {
+			release()
+			return "", nil, err
+		}
+		if exceeded {
+			release()
+			return RegisterBlockDeviceLimit, nil, nil
+		}
+	}
+	return "", release, nil
+}
+
+// checkRegisterGuard 注册前校验防刷策略并占用注册数,拦截时上报事件及指标
+// 返回的release在注册失败时调用以归还占用
+func checkRegisterGuard(param *AccountRegisterParam) (func(), error) {
+	guard := registerGuard.Load()
+	if guard == nil {
+		return func() {}, nil
+	}
+	deviceHash := hashRegisterDevice(param.Device)
+	reason, release, err := guard.Reserve(param.IP, deviceHash, time.Now())
+	if err != nil {
+		log.Error("checkRegisterGuard error", zap.String("ip", param.IP), zap.Error(err))
+		return nil, err
+	}
+	if reason == "" {
+		return release, nil
+	}
+	monitor.RegisterBlockedCount.WithLabelValues(reason).Inc()
+	log.Warn("register blocked", zap.String("reason", reason), zap.String("ip", param.IP), zap.String("device_hash", deviceHash), zap.String("user_name", param.Name))
+	sendRegisterBlockedEvent(param, deviceHash, reason)
+	if reason == RegisterBlockIPLimit || reason == RegisterBlockDeviceLimit {
+		return nil, fmt.Errorf("%w: %s", ErrRegisterLimited, reason)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrRegisterDenied, reason)
+}
+
+// recordRegisterGuard 注册成功后更新IP注册表
+func recordRegisterGuard(userID uint64, param *AccountRegisterParam) {
+	if param.IP != "" && dao.MongoClient != nil {
+		_ = db.UpdateIPReg(dao.MongoClient, userID, param.IP)
+	}
+}
+
+// sendRegisterBlockedEvent 发送注册拦截事件(以IP为key)
+func sendRegisterBlockedEvent(param *AccountRegisterParam, deviceHash, reason string) {
+	if kafka.KafkaProducerClient == nil {
+		log.Warn("sendRegisterBlockedEvent kafka producer not initialized", zap.String("reason", reason))
+		return
+	}
+	event := &RegisterBlockedEvent{
+		EventType:  RegisterEventTypeBlocked,
+		Reason:     reason,
+		IP:         param.IP,
+		DeviceHash: deviceHash,
+		UserName:   param.Name,
+		BrandID:    param.BrandID,
+		Channel:    param.Channel,
+		EventTime:  time.Now().UnixMilli(),
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Error("sendRegisterBlockedEvent json marshal error", zap.Any("event", event), zap.Error(err))
+		return
+	}
+	kafka.KafkaProducerClient.SendTopicMessage(kafka.GetKafkaTopic("register"), []byte(param.IP), data)
+}
+
+// hashRegisterDevice 设备指纹仅以哈希形式存储及上报
+func hashRegisterDevice(device string) string {
+	if device == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(device))
+	return hex.EncodeToString(hash[:])
+}
+
+func parseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"ppt/actor"
+	"ppt/config"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"time"
+)
+
+// 角色actor消息类型,跨节点转发时按类型查找处理函数
+const (
+	roleMsgMailClaim    = "mail_claim"
+	roleMsgCouponRedeem = "coupon_redeem"
+)
+
+type mailClaimPayload struct {
+	MailID string `json:"mail_id"`
+}
+
+type couponRedeemPayload struct {
+	CouponID    uuid.UUID `json:"coupon_id"`
+	OrderID     string    `json:"order_id"`
+	OrderAmount int64     `json:"order_amount"`
+}
+
+// InitRoleCluster 初始化角色actor集群并注册消息处理函数,未开启集群时为单节点模式
+func InitRoleCluster(cfg *config.ActorClusterConfig) {
+	var leases actor.LeaseStore
+	var transport actor.Transport
+	if cfg.Enabled {
+		leases = db.NewActorLeaseRedis(dao.RedisDB)
+		transport = db.NewActorStreamRedis(dao.RedisDB)
+	}
+	leaseTTL := dao.ActorLeaseTTL
+	if cfg.LeaseTTL > 0 {
+		leaseTTL = time.Duration(cfg.LeaseTTL) * time.Second
+	}
+	nodeID := fmt.Sprintf("%s-%s", config.HostName, uuid.NewString()[:8])
+	actor.InitRoleCluster(nodeID, leases, transport, leaseTTL)
+	registerRoleHandlers(actor.RoleCluster)
+	actor.RoleCluster.Start()
+	log.Info("InitRoleCluster success", zap.String("node_id", nodeID), zap.Bool("cluster", cfg.Enabled), zap.Duration("lease_ttl", leaseTTL))
+}
+
+func registerRoleHandlers(cluster *actor.Cluster) {
+	cluster.RegisterHandler(roleMsgMailClaim, func(state *actor.RoleState, payload json.RawMessage) (any, error) {
+		var req mailClaimPayload
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		return claimMailAccessory(state.RoleID, req.MailID)
+	})
+	cluster.RegisterHandler(roleMsgCouponRedeem, func(state *actor.RoleState, payload json.RawMessage) (any, error) {
+		var req couponRedeemPayload
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		return redeemCoupon(state.RoleID, req.CouponID, req.OrderID, req.OrderAmount)
+	})
+	cluster.RegisterErrors(db.ErrMailNotFound, ErrInventoryKindInvalid, db.ErrCouponNotFound, db.ErrCouponUnavailable,
+		ErrCouponNotApplicable, ErrCouponTemplateNotFound, ErrCouponOrderInvalid)
+}
+
+// askRole 在玩家actor中串行执行玩家状态变更,玩家actor在其他节点时转发至该节点;集群未初始化时直接执行fn
+func askRole[T any](ctx context.Context, userID uint64, msgType string, payload any, fn func() (T, error)) (T, error) {
+	if actor.RoleCluster == nil {
+		return fn()
+	}
+	ctx, cancel := context.WithTimeout(ctx, dao.RoleActorAskTimeout)
+	defer cancel()
+	var result T
+	err := actor.RoleCluster.Ask(ctx, userID, msgType, payload, &result)
+	return result, err
+}
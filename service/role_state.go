@@ -0,0 +1,36 @@
+package service
+
+import (
+	"ppt/actor"
+	"ppt/dao"
+	"ppt/dao/db"
+	loginDB "ppt/login/db"
+	"ppt/model"
+)
+
+// roleStateStore 角色状态存储,用户信息取自UserCache,状态数据存于PG
+type roleStateStore struct{}
+
+// NewRoleStateStore 角色actor使用的状态存储
+func NewRoleStateStore() actor.StateStore {
+	return roleStateStore{}
+}
+
+func (roleStateStore) LoadUser(roleID uint64) (*model.User, error) {
+	return loginDB.GetUserCache(roleID)
+}
+
+func (roleStateStore) LoadState(roleID uint64) (*model.RoleState, error) {
+	return db.NewRoleStateDao(dao.PgDB).GetRoleState(roleID)
+}
+
+func (roleStateStore) SaveState(state *model.RoleState) error {
+	saved, err := db.NewRoleStateDao(dao.PgDB).SaveRoleState(state)
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return actor.ErrStateConflict
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+	"ppt/util"
+	"sort"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// LoginClient 登录客户端信息,记录在会话中供用户识别
+type LoginClient struct {
+	Device string
+	IP     string
+}
+
+// SessionInfo 会话信息,Current表示发起请求的会话
+type SessionInfo struct {
+	*model.UserSession
+	Current bool `json:"current"`
+}
+
+// createSession 创建登录会话并签发token,同时记录登录历史
+func createSession(user *model.User, client *LoginClient, mfa bool) (*AuthTokens, error) {
+	if client == nil {
+		client = &LoginClient{}
+	}
+	sessionID, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	device := client.Device
+	if len(device) > dao.UserSessionDeviceMaxLen {
+		device = device[:dao.UserSessionDeviceMaxLen]
+	}
+	session := &model.UserSession{
+		SessionID: sessionID.String(),
+		UserID:    user.UserID,
+		Device:    device,
+		IP:        client.IP,
+		MFA:       mfa,
+		CreatedAt: now,
+	}
+	if err = db.SetUserSession(dao.RedisDB, session, util.GetRefreshTokenTTL()); err != nil {
+		return nil, err
+	}
+	tokens, err := issueAuthTokens(user.UserID, user.Username, session.SessionID, mfa)
+	if err != nil {
+		_ = db.DelUserSession(dao.RedisDB, user.UserID, session.SessionID)
+		return nil, err
+	}
+	_ = db.PushUserLoginTime(dao.RedisDB, user.UserID, now)
+	_ = db.UpdateUserLogin(dao.MongoClient, user.UserID, now, client.IP)
+	return tokens, nil
+}
+
+// GetUserSessions 获取用户全部有效会话,按最近活跃时间倒序
+func GetUserSessions(userID uint64, currentSessionID string) ([]*SessionInfo, error) {
+	sessions, err := db.GetUserSessions(dao.RedisDB, userID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen > sessions[j].LastSeen
+	})
+	infos := make([]*SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, &SessionInfo{UserSession: session, Current: session.SessionID == currentSessionID})
+	}
+	return infos, nil
+}
+
+// RevokeUserSession 注销用户的指定会话,会话下的token立即失效
+func RevokeUserSession(userID uint64, sessionID string) error {
+	sessions, err := db.GetUserSessions(dao.RedisDB, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.SessionID != sessionID {
+			continue
+		}
+		if err = db.DelUserSession(dao.RedisDB, userID, sessionID); err != nil {
+			return err
+		}
+		stopRoleActor(userID)
+		log.Info("RevokeUserSession success", zap.Uint64("user_id", userID), zap.String("session_id", sessionID))
+		return nil
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeUserSessions 注销用户全部会话,keepSessionID不为空时保留该会话,返回注销数量
+func RevokeUserSessions(userID uint64, keepSessionID string) (int, error) {
+	sessions, err := db.GetUserSessions(dao.RedisDB, userID)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, session := range sessions {
+		if session.SessionID == keepSessionID {
+			continue
+		}
+		if err = db.DelUserSession(dao.RedisDB, userID, session.SessionID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	stopRoleActor(userID)
+	log.Info("RevokeUserSessions success", zap.Uint64("user_id", userID), zap.Int("revoked", revoked), zap.String("keep_session_id", keepSessionID))
+	return revoked, nil
+}
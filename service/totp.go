@@ -0,0 +1,223 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go.uber.org/zap"
+	"math/big"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/model"
+	"ppt/util"
+	"strings"
+	"time"
+)
+
+const (
+	totpRecoveryCodeCount  = 10
+	totpRecoveryCodeLength = 10
+	totpRecoveryAlphabet   = "abcdefghjkmnpqrstuvwxyz23456789" // 去除易混淆字符
+)
+
+var (
+	ErrTotpNotEnabled     = errors.New("two-factor authentication not enabled")
+	ErrTotpAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	ErrTotpNotEnrolled    = errors.New("two-factor authentication not enrolled")
+	ErrTotpCodeInvalid    = errors.New("two-factor code invalid")
+	ErrTotpLocked         = errors.New("too many failed two-factor attempts")
+)
+
+// TotpEnrollment 两步验证绑定信息,OtpauthURL可直接生成二维码供验证器App扫描
+type TotpEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURL string `json:"otpauth_url"`
+}
+
+// EnrollTotp 生成待确认的TOTP密钥,重复调用会替换未确认的密钥
+func EnrollTotp(userID uint64, account string) (*TotpEnrollment, error) {
+	key, err := util.GenerateTOTPKey(util.GetTotpIssuer(), account)
+	if err != nil {
+		return nil, err
+	}
+	secretEnc, err := util.EncryptTotpSecret(key.Secret())
+	if err != nil {
+		log.Error("EnrollTotp EncryptTotpSecret error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	ok, err := db.NewUserTotpDao(dao.PgDB).SaveUserTotpPending(userID, secretEnc)
+	if err != nil {
+		log.Error("EnrollTotp SaveUserTotpPending error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTotpAlreadyEnabled
+	}
+	return &TotpEnrollment{Secret: key.Secret(), OtpauthURL: key.URL()}, nil
+}
+
+// ConfirmTotp 使用首个验证码确认绑定并启用两步验证,返回仅展示一次的恢复码
+func ConfirmTotp(userID uint64, code string) ([]string, error) {
+	totpDao := db.NewUserTotpDao(dao.PgDB)
+	userTotp, err := totpDao.GetUserTotp(userID)
+	if err != nil {
+		return nil, err
+	}
+	if userTotp == nil {
+		return nil, ErrTotpNotEnrolled
+	}
+	if userTotp.Status == model.UserTotpStatusEnabled {
+		return nil, ErrTotpAlreadyEnabled
+	}
+	if err = checkTotpLocked(userID); err != nil {
+		return nil, err
+	}
+	step, err := matchTotpCode(userTotp, code)
+	if err != nil {
+		return nil, err
+	}
+	if step == 0 {
+		return nil, recordTotpFailure(userID)
+	}
+	recoveryCodes, recoveryHashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := totpDao.EnableUserTotp(userID, step, recoveryHashes)
+	if err != nil {
+		log.Error("ConfirmTotp EnableUserTotp error", zap.Uint64("user_id", userID), zap.Error(err))
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTotpAlreadyEnabled
+	}
+	_ = db.ResetTotpFailCount(dao.RedisDB, userID)
+	log.Info("ConfirmTotp success", zap.Uint64("user_id", userID))
+	return recoveryCodes, nil
+}
+
+// DisableTotp 校验验证码或恢复码后关闭两步验证
+func DisableTotp(userID uint64, code, recoveryCode string) error {
+	if err := VerifyUserTotp(userID, code, recoveryCode); err != nil {
+		return err
+	}
+	if err := db.NewUserTotpDao(dao.PgDB).DeleteUserTotp(userID); err != nil {
+		log.Error("DisableTotp DeleteUserTotp error", zap.Uint64("user_id", userID), zap.Error(err))
+		return err
+	}
+	log.Info("DisableTotp success", zap.Uint64("user_id", userID))
+	return nil
+}
+
+// VerifyUserTotp 校验已启用的两步验证,code与recoveryCode二选一
+// 同一验证码不可重复使用,恢复码使用后失效;窗口内失败次数过多时锁定
+func VerifyUserTotp(userID uint64, code, recoveryCode string) error {
+	totpDao := db.NewUserTotpDao(dao.PgDB)
+	userTotp, err := totpDao.GetUserTotp(userID)
+	if err != nil {
+		return err
+	}
+	if userTotp == nil || userTotp.Status != model.UserTotpStatusEnabled {
+		return ErrTotpNotEnabled
+	}
+	if err = checkTotpLocked(userID); err != nil {
+		return err
+	}
+	var ok bool
+	if recoveryCode != "" {
+		ok, err = totpDao.UseUserRecoveryCode(userID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			log.Error("VerifyUserTotp UseUserRecoveryCode error", zap.Uint64("user_id", userID), zap.Error(err))
+			return err
+		}
+		if ok {
+			log.Info("VerifyUserTotp recovery code used", zap.Uint64("user_id", userID))
+		}
+	} else {
+		var step int64
+		if step, err = matchTotpCode(userTotp, code); err != nil {
+			return err
+		}
+		if step > 0 {
+			if ok, err = totpDao.UseUserTotpStep(userID, step); err != nil {
+				log.Error("VerifyUserTotp UseUserTotpStep error", zap.Uint64("user_id", userID), zap.Error(err))
+				return err
+			}
+		}
+	}
+	if !ok {
+		return recordTotpFailure(userID)
+	}
+	_ = db.ResetTotpFailCount(dao.RedisDB, userID)
+	return nil
+}
+
+// matchTotpCode 校验验证码,匹配时返回时间步,不匹配返回0
+func matchTotpCode(userTotp *model.UserTotp, code string) (int64, error) {
+	secret, err := util.DecryptTotpSecret(userTotp.SecretEnc)
+	if err != nil {
+		log.Error("matchTotpCode DecryptTotpSecret error", zap.Uint64("user_id", userTotp.UserID), zap.Error(err))
+		return 0, err
+	}
+	step, ok := util.ValidateTOTPStep(strings.TrimSpace(code), secret, time.Now())
+	if !ok {
+		return 0, nil
+	}
+	return step, nil
+}
+
+func checkTotpLocked(userID uint64) error {
+	count, err := db.GetTotpFailCount(dao.RedisDB, userID)
+	if err != nil {
+		return err
+	}
+	if count >= dao.TotpFailMax {
+		return ErrTotpLocked
+	}
+	return nil
+}
+
+// recordTotpFailure 记录失败并返回对应错误
+func recordTotpFailure(userID uint64) error {
+	count, err := db.IncrTotpFailCount(dao.RedisDB, userID)
+	if err != nil {
+		return err
+	}
+	log.Warn("two-factor verification failed", zap.Uint64("user_id", userID), zap.Int64("fail_count", count))
+	if count >= dao.TotpFailMax {
+		return ErrTotpLocked
+	}
+	return ErrTotpCodeInvalid
+}
+
+// generateRecoveryCodes 生成恢复码(xxxxx-xxxxx)及其存储哈希
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, totpRecoveryCodeCount)
+	hashes := make([]string, 0, totpRecoveryCodeCount)
+	alphabetSize := big.NewInt(int64(len(totpRecoveryAlphabet)))
+	for i := 0; i < totpRecoveryCodeCount; i++ {
+		var sb strings.Builder
+		for j := 0; j < totpRecoveryCodeLength; j++ {
+			if j == totpRecoveryCodeLength/2 {
+				sb.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			sb.WriteByte(totpRecoveryAlphabet[n.Int64()])
+		}
+		codes = append(codes, sb.String())
+		hashes = append(hashes, hashRecoveryCode(sb.String()))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode 恢复码忽略大小写及分隔符
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/log"
+	"ppt/mailer"
+	"ppt/util"
+	"time"
+)
+
+const (
+	VerifyPurposeEmail    = "email"    // 邮箱验证
+	VerifyPurposePassword = "password" // 找回密码
+
+	verifyCodeSendTimeout = 10 * time.Second
+)
+
+var (
+	ErrVerifyCodeInvalid     = errors.New("verify code invalid")
+	ErrVerifyCodeTooFrequent = errors.New("verify code requested too frequently")
+	ErrMailerUnavailable     = errors.New("mailer unavailable")
+)
+
+var verifyCodeGenerator = util.NewVerifyCodeGenerator()
+
+// verifyCodeMailText 验证码邮件文本,按用途及语言配置,%s为验证码,%d为有效分钟数
+var verifyCodeMailText = map[string]map[string][2]string{
+	VerifyPurposeEmail: {
+		code.LangZh: {"邮箱验证码", "您的邮箱验证码为 %s,%d分钟内有效。如非本人操作请忽略。"},
+		code.LangEn: {"Email verification code", "Your verification code is %s. It expires in %d minutes. Ignore this email if you did not request it."},
+	},
+	VerifyPurposePassword: {
+		code.LangZh: {"重置密码验证码", "您正在重置密码,验证码为 %s,%d分钟内有效。如非本人操作请忽略并检查账号安全。"},
+		code.LangEn: {"Password reset code", "Your password reset code is %s. It expires in %d minutes. Ignore this email if you did not request it."},
+	},
+}
+
+// checkVerifyCodeSendLimit 校验验证码发送频率:同用途同邮箱冷却期、窗口内单邮箱及单IP发送次数
+func checkVerifyCodeSendLimit(purpose, email, ip string) error {
+	ok, err := db.AcquireVerifyCodeCooldown(dao.RedisDB, purpose, email)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrVerifyCodeTooFrequent
+	}
+	count, err := db.IncrVerifyCodeLimit(dao.RedisDB, fmt.Sprintf(dao.VerifyEmailLimit, email))
+	if err != nil {
+		return err
+	}
+	if count > dao.VerifyCodeEmailLimit {
+		log.Warn("checkVerifyCodeSendLimit email limited", zap.String("purpose", purpose), zap.Int64("count", count))
+		return ErrVerifyCodeTooFrequent
+	}
+	if ip == "" {
+		return nil
+	}
+	if count, err = db.IncrVerifyCodeLimit(dao.RedisDB, fmt.Sprintf(dao.VerifyIPLimit, ip)); err != nil {
+		return err
+	}
+	if count > dao.VerifyCodeIPLimit {
+		log.Warn("checkVerifyCodeSendLimit ip limited", zap.String("purpose", purpose), zap.String("ip", ip), zap.Int64("count", count))
+		return ErrVerifyCodeTooFrequent
+	}
+	return nil
+}
+
+// sendVerifyCode 生成验证码并通过邮件发送,Redis中仅保存哈希
+func sendVerifyCode(purpose, email, lang string) error {
+	m := mailer.GetMailer()
+	if m == nil {
+		return ErrMailerUnavailable
+	}
+	verifyCode, err := verifyCodeGenerator.GenerateSecure()
+	if err != nil {
+		return err
+	}
+	if err = db.SetVerifyCode(dao.RedisDB, purpose, email, hashVerifyCode(purpose, email, verifyCode)); err != nil {
+		return err
+	}
+	texts, ok := verifyCodeMailText[purpose][lang]
+	if !ok {
+		texts = verifyCodeMailText[purpose][code.LangDefault]
+	}
+	ctx, cancel := context.WithTimeout(dao.Ctx, verifyCodeSendTimeout)
+	defer cancel()
+	err = m.Send(ctx, &mailer.Message{
+		To:      email,
+		Subject: texts[0],
+		Body:    fmt.Sprintf(texts[1], verifyCode, int(dao.VerifyCodeExpiration.Minutes())),
+	})
+	if err != nil {
+		log.Error("sendVerifyCode mailer Send error", zap.String("purpose", purpose), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// checkVerifyCode 校验并消耗验证码
+func checkVerifyCode(purpose, email, verifyCode string) error {
+	err := db.ConsumeVerifyCode(dao.RedisDB, purpose, email, hashVerifyCode(purpose, email, verifyCode))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, db.ErrVerifyCodeNotFound), errors.Is(err, db.ErrVerifyCodeMismatch), errors.Is(err, db.ErrVerifyCodeExceeded):
+		return fmt.Errorf("%w: %v", ErrVerifyCodeInvalid, err)
+	default:
+		return err
+	}
+}
+
+// hashVerifyCode 验证码哈希绑定用途及邮箱
+func hashVerifyCode(purpose, email, verifyCode string) string {
+	hash := sha256.Sum256([]byte(purpose + ":" + email + ":" + verifyCode))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,502 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"ppt/actor"
+	"ppt/model"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type funcMsg func()
+
+func (f funcMsg) Proc() error { f(); return nil }
+
+func newTestActor(roleID uint64) actor.Actor {
+	return actor.NewRoleActor(roleID, &actor.RoleActorConfig{Capacity: 16})
+}
+
+func TestActorManagerSpawnOnce(t *testing.T) {
+	var spawned atomic.Int32
+	manager := actor.NewActorManager(func(roleID uint64) actor.Actor {
+		spawned.Add(1)
+		return newTestActor(roleID)
+	}, 0)
+	defer manager.Shutdown(context.Background())
+
+	const roles, senders, msgs = 4, 8, 100
+	var mu sync.Mutex
+	counts := make(map[uint64]int)
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < msgs; j++ {
+				roleID := uint64(j%roles + 1)
+				if err := manager.Send(roleID, funcMsg(func() {
+					mu.Lock()
+					counts[roleID]++
+					mu.Unlock()
+				})); err != nil {
+					t.Errorf("Send error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := manager.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if spawned.Load() != roles {
+		t.Fatalf("spawned %d actors, want %d", spawned.Load(), roles)
+	}
+	for roleID := uint64(1); roleID <= roles; roleID++ {
+		if counts[roleID] != senders*msgs/roles {
+			t.Fatalf("role %d processed %d msgs, want %d", roleID, counts[roleID], senders*msgs/roles)
+		}
+	}
+}
+
+func TestActorManagerOrder(t *testing.T) {
+	manager := actor.NewActorManager(newTestActor, 0)
+	const total = 1000
+	var got []int
+	for i := 0; i < total; i++ {
+		i := i
+		if err := manager.Send(1, funcMsg(func() { got = append(got, i) })); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+		// 中途停止,新actor须在旧actor处理完后才开始处理
+		if i == total/2 {
+			manager.StopActor(1)
+		}
+	}
+	if err := manager.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if len(got) != total {
+		t.Fatalf("processed %d msgs, want %d", len(got), total)
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("msg %d processed at %d", v, i)
+		}
+	}
+}
+
+func TestActorManagerPassivate(t *testing.T) {
+	var spawned atomic.Int32
+	manager := actor.NewActorManager(func(roleID uint64) actor.Actor {
+		spawned.Add(1)
+		return newTestActor(roleID)
+	}, 50*time.Millisecond)
+	defer manager.Shutdown(context.Background())
+
+	var processed atomic.Int32
+	incr := funcMsg(func() { processed.Add(1) })
+	if err := manager.Send(1, incr); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	// 持续投递的actor不会被回收
+	for i := 0; i < 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if err := manager.Send(2, incr); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for manager.Len() > 1 && time.Now().Before(deadline) {
+		if err := manager.Send(2, incr); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if manager.Len() != 1 {
+		t.Fatalf("idle actor not passivated, actors %d", manager.Len())
+	}
+	if manager.StopActor(1) {
+		t.Fatal("passivated actor should not be stopped again")
+	}
+	before := spawned.Load()
+	if err := manager.Send(1, incr); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if spawned.Load() != before+1 {
+		t.Fatal("passivated actor should respawn on next msg")
+	}
+}
+
+func TestActorManagerStopAndShutdown(t *testing.T) {
+	manager := actor.NewActorManager(newTestActor, time.Minute)
+	if manager.StopActor(1) {
+		t.Fatal("StopActor should return false for unknown role")
+	}
+	var processed atomic.Int32
+	release := make(chan struct{})
+	for roleID := uint64(1); roleID <= 3; roleID++ {
+		if err := manager.Send(roleID, funcMsg(func() { <-release })); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+		for i := 0; i < 5; i++ {
+			if err := manager.Send(roleID, funcMsg(func() { processed.Add(1) })); err != nil {
+				t.Fatalf("Send error: %v", err)
+			}
+		}
+	}
+
+	// actor阻塞时Shutdown超时返回
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := manager.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown error = %v, want deadline exceeded", err)
+	}
+	if err := manager.Send(1, funcMsg(func() {})); !errors.Is(err, actor.ErrManagerClosed) {
+		t.Fatalf("Send after Shutdown error = %v, want ErrManagerClosed", err)
+	}
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for processed.Load() < 15 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if processed.Load() != 15 {
+		t.Fatalf("Shutdown drained %d msgs, want 15", processed.Load())
+	}
+}
+
+func TestActorAsk(t *testing.T) {
+	manager := actor.NewActorManager(newTestActor, 0)
+	defer manager.Shutdown(context.Background())
+	ctx := context.Background()
+
+	value, err := actor.Ask(ctx, manager, 1, func() (string, error) { return "ok", nil })
+	if err != nil || value != "ok" {
+		t.Fatalf("Ask = %q, %v, want ok", value, err)
+	}
+	errProc := errors.New("proc failed")
+	if _, err = actor.Ask(ctx, manager, 1, func() (int, error) { return 0, errProc }); !errors.Is(err, errProc) {
+		t.Fatalf("Ask error = %v, want %v", err, errProc)
+	}
+	if _, err = actor.Ask(ctx, manager, 1, func() (int, error) { panic("boom") }); !errors.Is(err, actor.ErrMsgPanic) {
+		t.Fatalf("Ask error = %v, want ErrMsgPanic", err)
+	}
+
+	// 同一角色的Ask串行执行
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := actor.Ask(ctx, manager, 2, func() (int, error) {
+				counter++
+				return counter, nil
+			}); err != nil {
+				t.Errorf("Ask error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if counter != 50 {
+		t.Fatalf("counter = %d, want 50", counter)
+	}
+}
+
+func TestActorAskTimeout(t *testing.T) {
+	manager := actor.NewActorManager(newTestActor, 0)
+	defer manager.Shutdown(context.Background())
+
+	release := make(chan struct{})
+	if err := manager.Send(1, funcMsg(func() { <-release })); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	var executed atomic.Bool
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := actor.Ask(ctx, manager, 1, func() (int, error) {
+		executed.Store(true)
+		return 1, nil
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Ask error = %v, want deadline exceeded", err)
+	}
+	close(release)
+	// 调用方已放弃等待的消息不再执行
+	if _, err := actor.Ask(context.Background(), manager, 1, func() (int, error) { return 0, nil }); err != nil {
+		t.Fatalf("Ask error: %v", err)
+	}
+	if executed.Load() {
+		t.Fatal("ask msg should be skipped after caller gave up")
+	}
+}
+
+func TestMailboxPolicy(t *testing.T) {
+	if _, err := actor.ParseMailboxPolicy("unknown"); err == nil {
+		t.Fatal("ParseMailboxPolicy should reject unknown policy")
+	}
+	ctx := context.Background()
+	var got []int
+	push := func(mailbox *actor.Mailbox, i int) error {
+		return mailbox.Push(ctx, funcMsg(func() { got = append(got, i) }))
+	}
+	drain := func(mailbox *actor.Mailbox) []int {
+		got = nil
+		mailbox.Close()
+		mailbox.Process(func(msg actor.Msg) { _ = msg.Proc() }, nil, nil)
+		return got
+	}
+
+	reject := actor.NewMailbox("test", 2, actor.MailboxPolicyReject)
+	for i := 0; i < 3; i++ {
+		err := push(reject, i)
+		if i < 2 && err != nil || i == 2 && !errors.Is(err, actor.ErrMailboxFull) {
+			t.Fatalf("reject Push(%d) error = %v", i, err)
+		}
+	}
+	if res := drain(reject); len(res) != 2 || res[0] != 0 || res[1] != 1 {
+		t.Fatalf("reject processed %v, want [0 1]", res)
+	}
+	if err := push(reject, 3); !errors.Is(err, actor.ErrMailboxClosed) {
+		t.Fatalf("Push after Close error = %v, want ErrMailboxClosed", err)
+	}
+
+	dropOldest := actor.NewMailbox("test", 2, actor.MailboxPolicyDropOldest)
+	for i := 0; i < 5; i++ {
+		if err := push(dropOldest, i); err != nil {
+			t.Fatalf("drop_oldest Push(%d) error: %v", i, err)
+		}
+	}
+	if res := drain(dropOldest); len(res) != 2 || res[0] != 3 || res[1] != 4 {
+		t.Fatalf("drop_oldest processed %v, want [3 4]", res)
+	}
+
+	block := actor.NewMailbox("test", 1, actor.MailboxPolicyBlock)
+	if err := push(block, 0); err != nil {
+		t.Fatalf("block Push error: %v", err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := block.Push(timeoutCtx, funcMsg(func() {})); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("block Push error = %v, want deadline exceeded", err)
+	}
+	if block.Len() != 1 {
+		t.Fatalf("block Len = %d, want 1", block.Len())
+	}
+}
+
+func TestActorAskDropped(t *testing.T) {
+	manager := actor.NewActorManager(func(roleID uint64) actor.Actor {
+		return actor.NewRoleActor(roleID, &actor.RoleActorConfig{Capacity: 1, Policy: actor.MailboxPolicyDropOldest})
+	}, 0)
+	defer manager.Shutdown(context.Background())
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := manager.Send(1, funcMsg(func() { close(started); <-release })); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	<-started
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := actor.Ask(context.Background(), manager, 1, func() (int, error) { return 1, nil })
+		errChan <- err
+	}()
+	// 等待Ask消息入队后再投递,使其被丢弃
+	time.Sleep(20 * time.Millisecond)
+	if err := manager.Send(1, funcMsg(func() {})); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if err := <-errChan; !errors.Is(err, actor.ErrMsgDropped) {
+		t.Fatalf("Ask error = %v, want ErrMsgDropped", err)
+	}
+	close(release)
+}
+
+// memStateStore 内存角色状态存储,按版本写入
+type memStateStore struct {
+	mu       sync.Mutex
+	states   map[uint64]*model.RoleState
+	saveFail bool
+}
+
+func newMemStateStore() *memStateStore {
+	return &memStateStore{states: make(map[uint64]*model.RoleState)}
+}
+
+func (s *memStateStore) LoadUser(roleID uint64) (*model.User, error) {
+	if roleID == 0 {
+		return nil, errors.New("user not found")
+	}
+	return &model.User{UserID: roleID}, nil
+}
+
+func (s *memStateStore) LoadState(roleID uint64) (*model.RoleState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.states[roleID]; ok {
+		copied := *state
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (s *memStateStore) SaveState(state *model.RoleState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saveFail {
+		return errors.New("save failed")
+	}
+	var version int64
+	if stored, ok := s.states[state.UserID]; ok {
+		version = stored.Version
+	}
+	if version != state.Version {
+		return actor.ErrStateConflict
+	}
+	s.states[state.UserID] = &model.RoleState{UserID: state.UserID, Version: version + 1, Data: state.Data}
+	return nil
+}
+
+func (s *memStateStore) version(roleID uint64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.states[roleID]; ok {
+		return state.Version
+	}
+	return 0
+}
+
+func newStateManager(store actor.StateStore, flushInterval time.Duration) *actor.ActorManager {
+	return actor.NewActorManager(func(roleID uint64) actor.Actor {
+		return actor.NewRoleActor(roleID, &actor.RoleActorConfig{Store: store, FlushInterval: flushInterval})
+	}, 0)
+}
+
+func setGold(manager *actor.ActorManager, roleID uint64, gold int64) error {
+	_, err := actor.AskState(context.Background(), manager, roleID, func(state *actor.RoleState) (bool, error) {
+		return true, state.Set("gold", gold)
+	})
+	return err
+}
+
+func getGold(manager *actor.ActorManager, roleID uint64) (int64, error) {
+	return actor.AskState(context.Background(), manager, roleID, func(state *actor.RoleState) (int64, error) {
+		var gold int64
+		_, err := state.Get("gold", &gold)
+		return gold, err
+	})
+}
+
+func TestRoleActorStateFlush(t *testing.T) {
+	store := newMemStateStore()
+	manager := newStateManager(store, time.Hour)
+	defer manager.Shutdown(context.Background())
+
+	if err := setGold(manager, 1, 10); err != nil {
+		t.Fatalf("setGold error: %v", err)
+	}
+	if store.version(1) != 0 {
+		t.Fatal("state should not be flushed before Stop")
+	}
+	// 停止时写入,重新创建后从存储加载
+	manager.StopActor(1)
+	if store.version(1) != 1 {
+		t.Fatalf("version after Stop = %d, want 1", store.version(1))
+	}
+	if gold, err := getGold(manager, 1); err != nil || gold != 10 {
+		t.Fatalf("getGold = %d, %v, want 10", gold, err)
+	}
+	// 未变更时不写入
+	manager.StopActor(1)
+	if store.version(1) != 1 {
+		t.Fatalf("version after clean Stop = %d, want 1", store.version(1))
+	}
+
+	// 定时写入
+	timerManager := newStateManager(store, 10*time.Millisecond)
+	defer timerManager.Shutdown(context.Background())
+	if err := setGold(timerManager, 1, 20); err != nil {
+		t.Fatalf("setGold error: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for store.version(1) != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if store.version(1) != 2 {
+		t.Fatalf("version after flush interval = %d, want 2", store.version(1))
+	}
+
+	if _, err := getGold(manager, 0); !errors.Is(err, actor.ErrStateUnavailable) {
+		t.Fatalf("getGold without user error = %v, want ErrStateUnavailable", err)
+	}
+	if _, err := getGold(actor.NewActorManager(newTestActor, 0), 1); !errors.Is(err, actor.ErrStateUnavailable) {
+		t.Fatalf("getGold without store error = %v, want ErrStateUnavailable", err)
+	}
+}
+
+func TestRoleActorStateConflict(t *testing.T) {
+	store := newMemStateStore()
+	nodeA := newStateManager(store, time.Hour)
+	nodeB := newStateManager(store, time.Hour)
+	defer nodeA.Shutdown(context.Background())
+	defer nodeB.Shutdown(context.Background())
+
+	// 两个节点读取同一版本后各自修改,后写入的节点版本冲突,不会覆盖
+	if err := setGold(nodeA, 1, 10); err != nil {
+		t.Fatalf("setGold error: %v", err)
+	}
+	if err := setGold(nodeB, 1, 99); err != nil {
+		t.Fatalf("setGold error: %v", err)
+	}
+	nodeA.StopActor(1)
+	nodeB.StopActor(1)
+	if store.version(1) != 1 {
+		t.Fatalf("version = %d, want 1", store.version(1))
+	}
+	if gold, err := getGold(nodeB, 1); err != nil || gold != 10 {
+		t.Fatalf("getGold = %d, %v, want 10", gold, err)
+	}
+}
+
+func TestRoleActorSnapshot(t *testing.T) {
+	storeA := newMemStateStore()
+	storeA.saveFail = true
+	nodeA := newStateManager(storeA, time.Hour)
+	storeB := newMemStateStore()
+	nodeB := newStateManager(storeB, time.Hour)
+	defer nodeA.Shutdown(context.Background())
+	defer nodeB.Shutdown(context.Background())
+
+	if _, err := nodeA.HandoffActor(1); !errors.Is(err, actor.ErrActorNotFound) {
+		t.Fatalf("HandoffActor error = %v, want ErrActorNotFound", err)
+	}
+	if err := setGold(nodeA, 1, 30); err != nil {
+		t.Fatalf("setGold error: %v", err)
+	}
+	// 写入失败时快照保留未写入的变更,由迁移后的节点写入
+	snapshot, err := nodeA.HandoffActor(1)
+	if err != nil {
+		t.Fatalf("HandoffActor error: %v", err)
+	}
+	if nodeA.Len() != 0 {
+		t.Fatal("HandoffActor should remove actor")
+	}
+	if err = nodeB.RestoreActor(1, snapshot); err != nil {
+		t.Fatalf("RestoreActor error: %v", err)
+	}
+	if err = nodeB.RestoreActor(1, snapshot); !errors.Is(err, actor.ErrActorRunning) {
+		t.Fatalf("RestoreActor error = %v, want ErrActorRunning", err)
+	}
+	if err = nodeB.RestoreActor(2, snapshot); err == nil {
+		t.Fatal("RestoreActor should reject snapshot of other role")
+	}
+	if gold, err := getGold(nodeB, 1); err != nil || gold != 30 {
+		t.Fatalf("getGold = %d, %v, want 30", gold, err)
+	}
+	nodeB.StopActor(1)
+	if storeB.version(1) != 1 {
+		t.Fatalf("version after restore Stop = %d, want 1", storeB.version(1))
+	}
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"ppt/middleware"
+	"ppt/model"
+	"testing"
+)
+
+func newAdminAccess(perms ...string) *model.AdminAccess {
+	access := &model.AdminAccess{UserID: 1, Roles: []string{"ops"}, Permissions: map[string]struct{}{}}
+	for _, perm := range perms {
+		access.Permissions[perm] = struct{}{}
+	}
+	return access
+}
+
+func TestAdminAccessHasPermission(t *testing.T) {
+	tests := []struct {
+		name   string
+		access *model.AdminAccess
+		perm   string
+		want   bool
+	}{
+		{"exact", newAdminAccess(model.AdminPermMailSend), model.AdminPermMailSend, true},
+		{"other", newAdminAccess(model.AdminPermMailSend), model.AdminPermCouponGrant, false},
+		{"resource wildcard", newAdminAccess("mail:*"), model.AdminPermMailTemplate, true},
+		{"resource wildcard other", newAdminAccess("mail:*"), model.AdminPermCouponGrant, false},
+		{"all", newAdminAccess(model.AdminPermAll), model.AdminPermAdminManage, true},
+		{"nil", nil, model.AdminPermMailSend, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.access.HasPermission(tt.perm); got != tt.want {
+				t.Fatalf("HasPermission(%s) = %v, want %v", tt.perm, got, tt.want)
+			}
+		})
+	}
+	if (&model.AdminAccess{UserID: 1}).IsAdmin() {
+		t.Fatal("access without roles should not be admin")
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		access *model.AdminAccess
+		want   int
+	}{
+		{"granted", newAdminAccess(model.AdminPermCouponGrant), http.StatusOK},
+		{"denied", newAdminAccess(model.AdminPermMailSend), http.StatusForbidden},
+		{"not admin", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.POST("/admin/coupon/grant", func(c *gin.Context) {
+				if tt.access != nil {
+					c.Set(middleware.ContextKeyAdminAccess, tt.access)
+				}
+			}, middleware.RequirePermission(model.AdminPermCouponGrant), func(c *gin.Context) {
+				if c.GetString(middleware.ContextKeyAuditPermission) != model.AdminPermCouponGrant {
+					t.Error("audit permission should be recorded in context")
+				}
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/coupon/grant", nil))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -20,7 +20,7 @@ func TestUserCache(t *testing.T) {
 	uuid, _ := uuid2.NewV7()
 	now := time.Now().UnixMilli()
 	userCache := model.User{
-		ID:        uuid.String(),
+		BaseModel: model.BaseModel{ID: uuid.String()},
 		UserID:    userID,
 		Username:  "ppt_001",
 		Password:  "tdv23d8rf",
@@ -0,0 +1,346 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ppt/actor"
+	"ppt/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memLease struct {
+	owner    string
+	expireAt time.Time
+}
+
+// memLeaseStore 内存租约存储,down中的节点访问时返回错误(模拟节点与Redis断开)
+type memLeaseStore struct {
+	mu     sync.Mutex
+	leases map[uint64]*memLease
+	down   map[string]bool
+}
+
+func newMemLeaseStore() *memLeaseStore {
+	return &memLeaseStore{leases: make(map[uint64]*memLease), down: make(map[string]bool)}
+}
+
+func (s *memLeaseStore) setDown(nodeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down[nodeID] = true
+}
+
+// steal 模拟租约被其他节点抢占
+func (s *memLeaseStore) steal(roleID uint64, nodeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leases[roleID] = &memLease{owner: nodeID, expireAt: time.Now().Add(time.Hour)}
+}
+
+func (s *memLeaseStore) AcquireActorLease(roleID uint64, nodeID string, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down[nodeID] {
+		return "", errors.New("lease store unreachable")
+	}
+	now := time.Now()
+	lease, ok := s.leases[roleID]
+	if !ok || now.After(lease.expireAt) {
+		s.leases[roleID] = &memLease{owner: nodeID, expireAt: now.Add(ttl)}
+		return nodeID, nil
+	}
+	if lease.owner == nodeID {
+		lease.expireAt = now.Add(ttl)
+	}
+	return lease.owner, nil
+}
+
+func (s *memLeaseStore) RenewActorLeases(roleIDs []uint64, nodeID string, ttl time.Duration) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down[nodeID] {
+		return nil, errors.New("lease store unreachable")
+	}
+	now := time.Now()
+	var lost []uint64
+	for _, roleID := range roleIDs {
+		lease, ok := s.leases[roleID]
+		if !ok || lease.owner != nodeID || now.After(lease.expireAt) {
+			lost = append(lost, roleID)
+			continue
+		}
+		lease.expireAt = now.Add(ttl)
+	}
+	return lost, nil
+}
+
+func (s *memLeaseStore) ReleaseActorLease(roleID uint64, nodeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lease, ok := s.leases[roleID]; ok && lease.owner == nodeID {
+		delete(s.leases, roleID)
+	}
+	return nil
+}
+
+// memTransport 内存消息转发,发往down中节点的消息被丢弃
+type memTransport struct {
+	mu    sync.Mutex
+	inbox map[string]chan *model.ActorEnvelope
+	down  map[string]bool
+}
+
+func newMemTransport() *memTransport {
+	return &memTransport{inbox: make(map[string]chan *model.ActorEnvelope), down: make(map[string]bool)}
+}
+
+func (t *memTransport) getInbox(nodeID string) chan *model.ActorEnvelope {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	inbox, ok := t.inbox[nodeID]
+	if !ok {
+		inbox = make(chan *model.ActorEnvelope, 1024)
+		t.inbox[nodeID] = inbox
+	}
+	return inbox
+}
+
+func (t *memTransport) setDown(nodeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.down[nodeID] = true
+}
+
+func (t *memTransport) SendActorEnvelope(nodeID string, env *model.ActorEnvelope) error {
+	t.mu.Lock()
+	down := t.down[nodeID]
+	t.mu.Unlock()
+	if down {
+		return nil
+	}
+	t.getInbox(nodeID) <- env
+	return nil
+}
+
+func (t *memTransport) ReadActorEnvelopes(ctx context.Context, nodeID, lastID string) ([]*model.ActorEnvelope, string, error) {
+	select {
+	case env := <-t.getInbox(nodeID):
+		return []*model.ActorEnvelope{env}, lastID, nil
+	case <-time.After(50 * time.Millisecond):
+		return nil, lastID, nil
+	case <-ctx.Done():
+		return nil, lastID, ctx.Err()
+	}
+}
+
+func (t *memTransport) DelActorInbox(nodeID string) error {
+	return nil
+}
+
+var errClusterTest = errors.New("cluster test error")
+
+type clusterReply struct {
+	NodeID string `json:"node_id"`
+	Count  int64  `json:"count"`
+}
+
+func newTestCluster(nodeID string, store actor.StateStore, leases actor.LeaseStore, transport actor.Transport) *actor.Cluster {
+	cluster := actor.NewCluster(nodeID, newStateManager(store, time.Hour), leases, transport, 100*time.Millisecond)
+	cluster.RegisterHandler("incr", func(state *actor.RoleState, payload json.RawMessage) (any, error) {
+		var count int64
+		if _, err := state.Get("count", &count); err != nil {
+			return nil, err
+		}
+		count++
+		return &clusterReply{NodeID: nodeID, Count: count}, state.Set("count", count)
+	})
+	cluster.RegisterHandler("fail", func(state *actor.RoleState, payload json.RawMessage) (any, error) {
+		return nil, errClusterTest
+	})
+	cluster.RegisterErrors(errClusterTest)
+	cluster.Start()
+	return cluster
+}
+
+func askIncr(ctx context.Context, cluster *actor.Cluster, roleID uint64) (*clusterReply, error) {
+	reply := &clusterReply{}
+	err := cluster.Ask(ctx, roleID, "incr", nil, reply)
+	return reply, err
+}
+
+func TestClusterSingleOwner(t *testing.T) {
+	store, leases, transport := newMemStateStore(), newMemLeaseStore(), newMemTransport()
+	nodeA := newTestCluster("A", store, leases, transport)
+	nodeB := newTestCluster("B", store, leases, transport)
+	defer nodeA.Stop(context.Background())
+	defer nodeB.Stop(context.Background())
+
+	const asks = 30
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var mu sync.Mutex
+	owners := make(map[string]int)
+	counts := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for _, node := range []*actor.Cluster{nodeA, nodeB} {
+		for i := 0; i < asks; i++ {
+			wg.Add(1)
+			go func(node *actor.Cluster) {
+				defer wg.Done()
+				reply, err := askIncr(ctx, node, 1)
+				if err != nil {
+					t.Errorf("Ask from %s error: %v", node.NodeID(), err)
+					return
+				}
+				mu.Lock()
+				owners[reply.NodeID]++
+				counts[reply.Count] = true
+				mu.Unlock()
+			}(node)
+		}
+	}
+	wg.Wait()
+	// 同一角色只在一个节点处理,计数串行递增无重复
+	if len(owners) != 1 {
+		t.Fatalf("role processed on nodes %v, want single owner", owners)
+	}
+	if len(counts) != 2*asks {
+		t.Fatalf("distinct counts = %d, want %d", len(counts), 2*asks)
+	}
+
+	if err := nodeB.Ask(ctx, 1, "fail", nil, nil); !errors.Is(err, errClusterTest) {
+		t.Fatalf("remote error = %v, want errClusterTest", err)
+	}
+	if err := nodeA.Ask(ctx, 1, "fail", nil, nil); !errors.Is(err, errClusterTest) {
+		t.Fatalf("remote error = %v, want errClusterTest", err)
+	}
+	if err := nodeB.Ask(ctx, 1, "unknown", nil, nil); !errors.Is(err, actor.ErrUnknownMsgType) {
+		t.Fatalf("unknown msg error = %v, want ErrUnknownMsgType", err)
+	}
+}
+
+func TestClusterHandoffOnStop(t *testing.T) {
+	store, leases, transport := newMemStateStore(), newMemLeaseStore(), newMemTransport()
+	nodeA := newTestCluster("A", store, leases, transport)
+	nodeB := newTestCluster("B", store, leases, transport)
+	defer nodeB.Stop(context.Background())
+
+	ctx := context.Background()
+	if reply, err := askIncr(ctx, nodeA, 1); err != nil || reply.NodeID != "A" {
+		t.Fatalf("askIncr = %+v, %v, want processed on A", reply, err)
+	}
+	// 正常退出时写入状态并释放租约,其他节点立即接管
+	if err := nodeA.Stop(ctx); err != nil {
+		t.Fatalf("Stop error: %v", err)
+	}
+	reply, err := askIncr(ctx, nodeB, 1)
+	if err != nil || reply.NodeID != "B" || reply.Count != 2 {
+		t.Fatalf("askIncr = %+v, %v, want count 2 on B", reply, err)
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	store, leases, transport := newMemStateStore(), newMemLeaseStore(), newMemTransport()
+	nodeA := newTestCluster("A", store, leases, transport)
+	nodeB := newTestCluster("B", store, leases, transport)
+	defer nodeA.Stop(context.Background())
+	defer nodeB.Stop(context.Background())
+
+	if reply, err := askIncr(context.Background(), nodeA, 1); err != nil || reply.NodeID != "A" {
+		t.Fatalf("askIncr = %+v, %v, want processed on A", reply, err)
+	}
+	// 节点A与其他节点失联:无法续期租约,转发给A的消息丢失
+	leases.setDown("A")
+	transport.setDown("A")
+
+	var reply *clusterReply
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		r, err := askIncr(ctx, nodeB, 1)
+		cancel()
+		if err == nil {
+			reply = r
+			break
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("askIncr error: %v", err)
+		}
+	}
+	if reply == nil || reply.NodeID != "B" {
+		t.Fatalf("lease not failed over to B, reply %+v", reply)
+	}
+	// A续期失败后已停止本地actor并写入状态,B从存储加载
+	if reply.Count != 2 {
+		t.Fatalf("count on B = %d, want 2", reply.Count)
+	}
+}
+
+func TestClusterRenewNotBlockedByLostActor(t *testing.T) {
+	store, leases, transport := newMemStateStore(), newMemLeaseStore(), newMemTransport()
+	nodeA := newTestCluster("A", store, leases, transport)
+	defer nodeA.Stop(context.Background())
+	release := make(chan struct{})
+	nodeA.RegisterHandler("block", func(state *actor.RoleState, payload json.RawMessage) (any, error) {
+		<-release
+		return nil, nil
+	})
+	defer close(release)
+
+	if reply, err := askIncr(context.Background(), nodeA, 2); err != nil || reply.NodeID != "A" {
+		t.Fatalf("askIncr = %+v, %v, want processed on A", reply, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_ = nodeA.Ask(ctx, 1, "block", nil, nil)
+	cancel()
+	// 角色1租约被抢占,其actor仍阻塞在处理中,停止需等待;角色2的租约须照常续期
+	leases.steal(1, "B")
+	time.Sleep(500 * time.Millisecond)
+	if owner, err := leases.AcquireActorLease(2, "B", time.Second); err != nil || owner != "A" {
+		t.Fatalf("lease of role 2 owner = %q, %v, want still renewed by A", owner, err)
+	}
+}
+
+func TestClusterDiscardQueuedOnLeaseLost(t *testing.T) {
+	store, leases, transport := newMemStateStore(), newMemLeaseStore(), newMemTransport()
+	nodeA := newTestCluster("A", store, leases, transport)
+	nodeB := newTestCluster("B", store, leases, transport)
+	defer nodeA.Stop(context.Background())
+	defer nodeB.Stop(context.Background())
+	release := make(chan struct{})
+	nodeA.RegisterHandler("block", func(state *actor.RoleState, payload json.RawMessage) (any, error) {
+		<-release
+		return nil, nil
+	})
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = nodeA.Ask(ctx, 1, "block", nil, nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	type result struct {
+		reply *clusterReply
+		err   error
+	}
+	queued := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		reply, err := askIncr(ctx, nodeA, 1)
+		queued <- result{reply, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// 排队期间租约被B获取,A上的排队消息不得执行,由发送方重新定位至B
+	leases.steal(1, "B")
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+
+	r := <-queued
+	if r.err != nil || r.reply.NodeID != "B" {
+		t.Fatalf("queued askIncr = %+v, %v, want processed on B", r.reply, r.err)
+	}
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/model"
+	"ppt/service"
+	"testing"
+	"time"
+)
+
+func TestChooseCouponExpireStrategy(t *testing.T) {
+	cursor := &model.CouponExpireCursor{Cutoff: time.Now().UnixMilli(), UserID: 1}
+	tests := []struct {
+		name   string
+		cursor *model.CouponExpireCursor
+		count  int64
+		want   service.CouponExpireStrategy
+	}{
+		{name: "resume ignores count", cursor: cursor, count: 0, want: service.CouponExpireStrategyResume},
+		{name: "resume large count", cursor: cursor, count: dao.CouponExpireDirectMax + 1, want: service.CouponExpireStrategyResume},
+		{name: "none", count: 0, want: service.CouponExpireStrategyNone},
+		{name: "direct small", count: 1, want: service.CouponExpireStrategyDirect},
+		{name: "direct at limit", count: dao.CouponExpireDirectMax, want: service.CouponExpireStrategyDirect},
+		{name: "cursor above limit", count: dao.CouponExpireDirectMax + 1, want: service.CouponExpireStrategyCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := service.ChooseCouponExpireStrategy(tt.cursor, tt.count); got != tt.want {
+				t.Fatalf("ChooseCouponExpireStrategy = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchUpdateExpiredCouponsCheckpoint(t *testing.T) {
+	requirePg(t)
+	if err := model.MigrateUserCoupon(dao.PgDB); err != nil {
+		t.Fatalf("MigrateUserCoupon error: %v", err)
+	}
+	if err := model.MigrateCouponEventOutbox(dao.PgDB); err != nil {
+		t.Fatalf("MigrateCouponEventOutbox error: %v", err)
+	}
+
+	now := time.Now()
+	userID, templateID := newTestUserID(), uuid.NewString()
+	ours := make(map[uuid.UUID]bool)
+	for i := 0; i < 5; i++ {
+		userCoupon := &model.UserCoupon{
+			UserID:       userID,
+			CouponID:     uuid.New(),
+			TemplateID:   templateID,
+			SourceID:     uuid.NewString(),
+			CouponStatus: model.CouponStatusAvailable,
+			ReceivedAt:   now.Add(-2 * time.Hour).UnixMilli(),
+			ExpiredAt:    now.Add(-time.Hour).UnixMilli(),
+		}
+		if err := dao.PgDB.Create(userCoupon).Error; err != nil {
+			t.Fatalf("create coupon error: %v", err)
+		}
+		ours[userCoupon.CouponID] = false
+	}
+	collect := func(expired []*model.UserCoupon) {
+		for _, userCoupon := range expired {
+			if done, ok := ours[userCoupon.CouponID]; ok {
+				if done {
+					t.Fatalf("coupon %s expired twice", userCoupon.CouponID)
+				}
+				ours[userCoupon.CouponID] = true
+			}
+		}
+	}
+
+	// 第一批回调失败模拟任务中断,游标停在已处理的最后一条
+	errInterrupted := errors.New("interrupted")
+	var checkpoint model.CouponExpireCursor
+	err := db.BatchUpdateExpiredCoupons(dao.PgDB, 2, &model.CouponExpireCursor{Cutoff: now.UnixMilli()}, func(cursor *model.CouponExpireCursor, expired []*model.UserCoupon) error {
+		collect(expired)
+		checkpoint = *cursor
+		return errInterrupted
+	})
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("BatchUpdateExpiredCoupons error = %v, want interrupted", err)
+	}
+	if checkpoint.UserID == 0 {
+		t.Fatalf("checkpoint not saved")
+	}
+
+	// 从检查点继续,每张优惠券只过期一次
+	err = db.BatchUpdateExpiredCoupons(dao.PgDB, 2, &checkpoint, func(cursor *model.CouponExpireCursor, expired []*model.UserCoupon) error {
+		collect(expired)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("BatchUpdateExpiredCoupons resume error: %v", err)
+	}
+	for couponID, done := range ours {
+		if !done {
+			t.Fatalf("coupon %s not expired after resume", couponID)
+		}
+	}
+	// 中断批次的事件已随状态更新写入outbox,每张优惠券恰有一条待发送事件
+	defer dao.PgDB.Where("user_id = ?", userID).Delete(&model.CouponEventOutbox{})
+	var events []*model.CouponEventOutbox
+	if err = dao.PgDB.Where("user_id = ?", userID).Find(&events).Error; err != nil {
+		t.Fatalf("find outbox error: %v", err)
+	}
+	if len(events) != len(ours) {
+		t.Fatalf("outbox events = %d, want %d", len(events), len(ours))
+	}
+	for _, event := range events {
+		expiredEvent := &model.CouponExpiredEvent{}
+		if err = json.Unmarshal(event.Payload, expiredEvent); err != nil {
+			t.Fatalf("unmarshal outbox payload error: %v", err)
+		}
+		if _, ok := ours[expiredEvent.CouponID]; !ok || expiredEvent.EventType != model.CouponEventTypeExpired {
+			t.Fatalf("unexpected outbox event %+v", expiredEvent)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/model"
+	"ppt/service"
+	"sync"
+	"testing"
+	"time"
+)
+
+func migrateCouponTables(t *testing.T) {
+	t.Helper()
+	for _, migrate := range []func() error{
+		func() error { return model.MigrateCouponTemplate(dao.PgDB) },
+		func() error { return model.MigrateUserCoupon(dao.PgDB) },
+		func() error { return model.MigrateUserCoinLedger(dao.PgDB) },
+		func() error { return model.MigrateUserInventory(dao.PgDB) },
+	} {
+		if err := migrate(); err != nil {
+			t.Fatalf("migrate error: %v", err)
+		}
+	}
+}
+
+// grantTestCoupon 创建模板并向用户发放一张优惠券
+func grantTestCoupon(t *testing.T, userID uint64, param *service.CouponTemplateParam) *model.UserCoupon {
+	t.Helper()
+	param.Name = map[string]string{code.LangDefault: "test coupon"}
+	param.ValidEnd = time.Now().Add(24 * time.Hour).UnixMilli()
+	couponTemplate, err := service.CreateCouponTemplate(param, "test")
+	if err != nil {
+		t.Fatalf("CreateCouponTemplate error: %v", err)
+	}
+	if _, err = service.GrantCoupons(couponTemplate.ID, []uint64{userID}, "", "test"); err != nil {
+		t.Fatalf("GrantCoupons error: %v", err)
+	}
+	userCoupons, err := db.NewCouponDao(dao.PgDB).GetUserAvailableCoupons(userID, time.Now().UnixMilli())
+	if err != nil || len(userCoupons) != 1 {
+		t.Fatalf("GetUserAvailableCoupons = %d, %v, want 1", len(userCoupons), err)
+	}
+	return userCoupons[0]
+}
+
+func TestRedeemCoupon(t *testing.T) {
+	requirePg(t)
+	migrateCouponTables(t)
+
+	reduction := &service.CouponTemplateParam{CouponType: model.CouponTypeFullReduction, Threshold: 100, Discount: 30}
+	ctx := context.Background()
+
+	t.Run("same order retry", func(t *testing.T) {
+		userID := newTestUserID()
+		userCoupon := grantTestCoupon(t, userID, reduction)
+		first, err := service.RedeemCoupon(ctx, userID, userCoupon.CouponID, "order-1", 200)
+		if err != nil {
+			t.Fatalf("RedeemCoupon error: %v", err)
+		}
+		retry, err := service.RedeemCoupon(ctx, userID, userCoupon.CouponID, "order-1", 200)
+		if err != nil {
+			t.Fatalf("RedeemCoupon retry error: %v", err)
+		}
+		if retry.Coupon.Discount != 30 || retry.Coupon.UsedAt != first.Coupon.UsedAt {
+			t.Fatalf("retry coupon = %+v, want first redeem %+v", retry.Coupon, first.Coupon)
+		}
+		if _, err = service.RedeemCoupon(ctx, userID, userCoupon.CouponID, "order-2", 200); !errors.Is(err, db.ErrCouponUnavailable) {
+			t.Fatalf("other order error = %v, want ErrCouponUnavailable", err)
+		}
+	})
+
+	t.Run("concurrent orders", func(t *testing.T) {
+		userID := newTestUserID()
+		userCoupon := grantTestCoupon(t, userID, reduction)
+		const orders = 10
+		errs := make([]error, orders)
+		var wg sync.WaitGroup
+		for i := 0; i < orders; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = service.RedeemCoupon(ctx, userID, userCoupon.CouponID, fmt.Sprintf("order-%d", i), 200)
+			}(i)
+		}
+		wg.Wait()
+		succeeded := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, db.ErrCouponUnavailable):
+				t.Fatalf("RedeemCoupon error: %v", err)
+			}
+		}
+		if succeeded != 1 {
+			t.Fatalf("succeeded redeems = %d, want 1", succeeded)
+		}
+	})
+
+	t.Run("foreign coupon", func(t *testing.T) {
+		userCoupon := grantTestCoupon(t, newTestUserID(), reduction)
+		if _, err := service.RedeemCoupon(ctx, newTestUserID(), userCoupon.CouponID, "order-1", 200); !errors.Is(err, db.ErrCouponNotFound) {
+			t.Fatalf("foreign coupon error = %v, want ErrCouponNotFound", err)
+		}
+	})
+
+	t.Run("expired coupon", func(t *testing.T) {
+		userID := newTestUserID()
+		userCoupon := grantTestCoupon(t, userID, reduction)
+		if err := dao.PgDB.Model(&model.UserCoupon{}).Where("user_id = ? and coupon_id = ?", userID, userCoupon.CouponID).
+			Update("expired_at", time.Now().Add(-time.Minute).UnixMilli()).Error; err != nil {
+			t.Fatalf("expire coupon error: %v", err)
+		}
+		if _, err := service.RedeemCoupon(ctx, userID, userCoupon.CouponID, "order-1", 200); !errors.Is(err, db.ErrCouponUnavailable) {
+			t.Fatalf("expired coupon error = %v, want ErrCouponUnavailable", err)
+		}
+	})
+
+	t.Run("below threshold", func(t *testing.T) {
+		userID := newTestUserID()
+		userCoupon := grantTestCoupon(t, userID, reduction)
+		if _, err := service.RedeemCoupon(ctx, userID, userCoupon.CouponID, "order-1", 50); !errors.Is(err, service.ErrCouponNotApplicable) {
+			t.Fatalf("below threshold error = %v, want ErrCouponNotApplicable", err)
+		}
+	})
+}
+
+func TestRedeemGiftCouponRetry(t *testing.T) {
+	requirePg(t)
+	requireMongo(t)
+	migrateCouponTables(t)
+
+	userID := newTestUserID()
+	gift := &service.CouponTemplateParam{CouponType: model.CouponTypeFullGift, Threshold: 100, Gifts: map[string]int64{code.CoinTypeGold: 10}}
+	userCoupon := grantTestCoupon(t, userID, gift)
+	ctx := context.Background()
+
+	first, err := service.RedeemCoupon(ctx, userID, userCoupon.CouponID, uuid.NewString(), 200)
+	if err != nil {
+		t.Fatalf("RedeemCoupon error: %v", err)
+	}
+	retry, err := service.RedeemCoupon(ctx, userID, userCoupon.CouponID, first.Coupon.OrderID, 200)
+	if err != nil {
+		t.Fatalf("RedeemCoupon retry error: %v", err)
+	}
+	// 重试返回首次核销的流水,且奖励只入账一次
+	if want, got := ledgerIDs(first.Ledgers), ledgerIDs(retry.Ledgers); len(want) != 1 || len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("retry ledgers = %v, want %v", got, want)
+	}
+	balance, err := db.GetUserCoin(dao.MongoClient, userID, code.CoinTypeGold)
+	if err != nil || balance != 10 {
+		t.Fatalf("gold balance = %d, %v, want 10", balance, err)
+	}
+}
@@ -0,0 +1,190 @@
+package test
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"ppt/model"
+	"ppt/service"
+	"testing"
+)
+
+func TestCouponStackSelect(t *testing.T) {
+	now := int64(1_700_000_000_000)
+	expire := now + 86400000
+
+	newCandidate := func(couponType model.CouponType, threshold, discount int64, campaign, group string) *service.CouponCandidate {
+		return &service.CouponCandidate{
+			Coupon: &model.UserCoupon{
+				CouponID:     uuid.New(),
+				CouponType:   couponType,
+				CouponStatus: model.CouponStatusAvailable,
+				ExpiredAt:    expire,
+			},
+			Template: &model.CouponTemplate{
+				CouponType: couponType,
+				Threshold:  threshold,
+				Discount:   discount,
+				CampaignID: campaign,
+				ExclGroup:  group,
+			},
+		}
+	}
+	reduce := func(threshold, discount int64, campaign, group string) *service.CouponCandidate {
+		return newCandidate(model.CouponTypeFullReduction, threshold, discount, campaign, group)
+	}
+	gift := func(threshold int64, campaign, group string) *service.CouponCandidate {
+		return newCandidate(model.CouponTypeFullGift, threshold, 0, campaign, group)
+	}
+
+	tests := []struct {
+		name         string
+		orderAmount  int64
+		coupons      []*service.CouponCandidate
+		missing      int // 追加不存在的优惠券数
+		duplicate    bool
+		mutate       func(coupons []*service.CouponCandidate)
+		wantDiscount int64
+		wantSelected []int
+		wantRejected map[int]service.CouponRejectReason
+	}{
+		{
+			name:         "single reduction",
+			orderAmount:  1000,
+			coupons:      []*service.CouponCandidate{reduce(500, 100, "", "")},
+			wantDiscount: 100,
+			wantSelected: []int{0},
+		},
+		{
+			name:         "reduction stacks with gift",
+			orderAmount:  1000,
+			coupons:      []*service.CouponCandidate{reduce(500, 100, "a", ""), gift(500, "b", "")},
+			wantDiscount: 100,
+			wantSelected: []int{0, 1},
+		},
+		{
+			name:         "best reduction wins type limit",
+			orderAmount:  1000,
+			coupons:      []*service.CouponCandidate{reduce(500, 100, "", ""), reduce(800, 300, "", "")},
+			wantDiscount: 300,
+			wantSelected: []int{1},
+			wantRejected: map[int]service.CouponRejectReason{0: service.CouponRejectTypeLimit},
+		},
+		{
+			name:         "same campaign not stackable",
+			orderAmount:  1000,
+			coupons:      []*service.CouponCandidate{reduce(500, 100, "a", ""), gift(500, "a", "")},
+			wantDiscount: 100,
+			wantSelected: []int{0},
+			wantRejected: map[int]service.CouponRejectReason{1: service.CouponRejectSameCampaign},
+		},
+		{
+			name:         "exclusive group not stackable",
+			orderAmount:  1000,
+			coupons:      []*service.CouponCandidate{gift(100, "", "g"), reduce(500, 50, "", "g")},
+			wantDiscount: 50,
+			wantSelected: []int{1},
+			wantRejected: map[int]service.CouponRejectReason{0: service.CouponRejectExclGroup},
+		},
+		{
+			name:         "below threshold rejected",
+			orderAmount:  400,
+			coupons:      []*service.CouponCandidate{reduce(500, 100, "", ""), reduce(300, 30, "", "")},
+			wantDiscount: 30,
+			wantSelected: []int{1},
+			wantRejected: map[int]service.CouponRejectReason{0: service.CouponRejectBelowThreshold},
+		},
+		{
+			name:        "expired unavailable and not started rejected",
+			orderAmount: 1000,
+			coupons:     []*service.CouponCandidate{reduce(0, 10, "", ""), reduce(0, 20, "", ""), reduce(0, 30, "", "")},
+			mutate: func(coupons []*service.CouponCandidate) {
+				coupons[0].Coupon.ExpiredAt = now
+				coupons[1].Coupon.CouponStatus = model.CouponStatusUsedSuccess
+				coupons[2].Template.ValidStart = now + 1
+			},
+			wantRejected: map[int]service.CouponRejectReason{
+				0: service.CouponRejectExpired,
+				1: service.CouponRejectUnavailable,
+				2: service.CouponRejectNotStarted,
+			},
+		},
+		{
+			name:         "discount capped at order amount",
+			orderAmount:  80,
+			coupons:      []*service.CouponCandidate{reduce(0, 100, "", "")},
+			wantDiscount: 80,
+			wantSelected: []int{0},
+		},
+		{
+			name:         "not found and duplicate rejected",
+			orderAmount:  1000,
+			coupons:      []*service.CouponCandidate{reduce(0, 10, "", "")},
+			missing:      1,
+			duplicate:    true,
+			wantDiscount: 10,
+			wantSelected: []int{0},
+			wantRejected: map[int]service.CouponRejectReason{1: service.CouponRejectNotFound, 2: service.CouponRejectDuplicate},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.mutate != nil {
+				tt.mutate(tt.coupons)
+			}
+			candidates := make(map[uuid.UUID]*service.CouponCandidate)
+			couponIDs := make([]uuid.UUID, 0, len(tt.coupons)+tt.missing+1)
+			for _, candidate := range tt.coupons {
+				candidates[candidate.Coupon.CouponID] = candidate
+				couponIDs = append(couponIDs, candidate.Coupon.CouponID)
+			}
+			for i := 0; i < tt.missing; i++ {
+				couponIDs = append(couponIDs, uuid.New())
+			}
+			if tt.duplicate {
+				couponIDs = append(couponIDs, couponIDs[0])
+			}
+
+			result, err := service.SelectCouponCombination(tt.orderAmount, now, couponIDs, candidates, service.DefaultCouponStackRule)
+			if err != nil {
+				t.Fatalf("SelectCouponCombination error: %v", err)
+			}
+			if result.Discount != tt.wantDiscount || result.PayAmount != tt.orderAmount-tt.wantDiscount {
+				t.Errorf("discount = %d, pay = %d, want discount %d", result.Discount, result.PayAmount, tt.wantDiscount)
+			}
+			if len(result.Selected) != len(tt.wantSelected) {
+				t.Fatalf("selected %d coupons, want %d", len(result.Selected), len(tt.wantSelected))
+			}
+			for i, idx := range tt.wantSelected {
+				if result.Selected[i].CouponID != couponIDs[idx] {
+					t.Errorf("selected[%d] = %s, want coupon %d", i, result.Selected[i].CouponID, idx)
+				}
+			}
+			if len(result.Rejected) != len(tt.wantRejected) {
+				t.Fatalf("rejected %d coupons, want %d", len(result.Rejected), len(tt.wantRejected))
+			}
+			for _, rejection := range result.Rejected {
+				found := false
+				for idx, reason := range tt.wantRejected {
+					if couponIDs[idx] == rejection.CouponID && reason == rejection.Reason {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("unexpected rejection %s: %s (%s)", rejection.CouponID, rejection.Reason, rejection.Detail)
+				}
+			}
+		})
+	}
+}
+
+func TestCouponStackTooMany(t *testing.T) {
+	couponIDs := make([]uuid.UUID, service.DefaultCouponStackRule.MaxCoupons+1)
+	for i := range couponIDs {
+		couponIDs[i] = uuid.New()
+	}
+	_, err := service.SelectCouponCombination(1000, 0, couponIDs, nil, service.DefaultCouponStackRule)
+	if !errors.Is(err, service.ErrCouponOrderInvalid) {
+		t.Fatalf("err = %v, want ErrCouponOrderInvalid", err)
+	}
+}
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"errors"
+	"ppt/dbuffer"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoubleBufferUnique(t *testing.T) {
+	var next atomic.Int64
+	buffer, err := dbuffer.NewDoubleBuffer(func(idType int) (int64, int64, error) {
+		return next.Add(10) - 10, 10, nil
+	})
+	if err != nil {
+		t.Fatalf("NewDoubleBuffer error: %v", err)
+	}
+	var (
+		mu   sync.Mutex
+		seen = make(map[int64]bool)
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				id, err := buffer.GetUUID(dbuffer.UUID_TYPE_ITEMID)
+				if errors.Is(err, dbuffer.ErrFunctionIDNotReady) {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if err != nil {
+					t.Errorf("GetUUID error: %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("duplicate id %d", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err = buffer.GetUUID(dbuffer.UUID_TYPE_MAX); !errors.Is(err, dbuffer.ErrFunctionTypeUnknown) {
+		t.Fatalf("GetUUID unknown type error = %v, want ErrFunctionTypeUnknown", err)
+	}
+}
+
+func TestDoubleBufferSyncFailure(t *testing.T) {
+	var calls atomic.Int64
+	buffer, err := dbuffer.NewDoubleBuffer(func(idType int) (int64, int64, error) {
+		if calls.Add(1) > dbuffer.UUID_TYPE_MAX {
+			return 0, 0, errors.New("segment store unreachable")
+		}
+		return 100, 4, nil
+	})
+	if err != nil {
+		t.Fatalf("NewDoubleBuffer error: %v", err)
+	}
+	for want := int64(100); want < 104; want++ {
+		if id, err := buffer.GetUUID(dbuffer.UUID_TYPE_PETID); err != nil || id != want {
+			t.Fatalf("GetUUID = %d, %v, want %d", id, err, want)
+		}
+	}
+	// 备用号段分配失败,号段用完后返回错误而不是复用已用号段
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		id, err := buffer.GetUUID(dbuffer.UUID_TYPE_PETID)
+		if !errors.Is(err, dbuffer.ErrFunctionIDNotReady) {
+			t.Fatalf("GetUUID = %d, %v, want ErrFunctionIDNotReady", id, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/model"
+	"testing"
+	"time"
+)
+
+func newExpiredTestMail(t *testing.T, now time.Time) *model.UserMail {
+	t.Helper()
+	if err := model.MigrateUserMail(dao.PgDB); err != nil {
+		t.Fatalf("MigrateUserMail error: %v", err)
+	}
+	userMail := &model.UserMail{
+		UserID:      newTestUserID(),
+		TemplateID:  uuid.NewString(),
+		ExpiredTime: now.AddDate(0, 0, -dao.UserMailExpiredDeleteDays-1).UnixMilli(),
+		Operator:    "test",
+	}
+	if err := dao.PgDB.Create(userMail).Error; err != nil {
+		t.Fatalf("create mail error: %v", err)
+	}
+	return userMail
+}
+
+func countMailByID(t *testing.T, id string) int64 {
+	t.Helper()
+	var count int64
+	if err := dao.PgDB.Model(&model.UserMail{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		t.Fatalf("count mail error: %v", err)
+	}
+	return count
+}
+
+func TestArchiveAndDeleteExpiredMails(t *testing.T) {
+	requirePg(t)
+
+	now := time.Now()
+	mailDao := db.NewUserMailDao(dao.PgDB)
+	errInsert := errors.New("clickhouse insert failed")
+
+	tests := []struct {
+		name      string
+		archive   func(archived *[]string) func(userMails []*model.UserMail) error
+		wantErr   error
+		wantCount int64
+	}{
+		{
+			name: "archive failed rolls back delete",
+			archive: func(archived *[]string) func(userMails []*model.UserMail) error {
+				return func(userMails []*model.UserMail) error {
+					return errInsert
+				}
+			},
+			wantErr:   errInsert,
+			wantCount: 1,
+		},
+		{
+			name: "archive succeeded commits delete",
+			archive: func(archived *[]string) func(userMails []*model.UserMail) error {
+				return func(userMails []*model.UserMail) error {
+					for _, userMail := range userMails {
+						*archived = append(*archived, userMail.ID)
+					}
+					return nil
+				}
+			},
+			wantCount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userMail := newExpiredTestMail(t, now)
+			var archived []string
+			_, err := mailDao.ArchiveAndDeleteExpiredMails(now, 1<<20, tt.archive(&archived))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ArchiveAndDeleteExpiredMails error = %v, want %v", err, tt.wantErr)
+			}
+			if got := countMailByID(t, userMail.ID); got != tt.wantCount {
+				t.Fatalf("mail rows = %d, want %d", got, tt.wantCount)
+			}
+			if tt.wantErr == nil {
+				found := false
+				for _, id := range archived {
+					found = found || id == userMail.ID
+				}
+				if !found {
+					t.Fatalf("deleted mail %s not archived", userMail.ID)
+				}
+			}
+		})
+	}
+}
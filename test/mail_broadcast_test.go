@@ -0,0 +1,174 @@
+package test
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/model"
+	"ppt/service"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNextUserIDsBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		userIDs    []uint64
+		lastUserID uint64
+		limit      int
+		want       []uint64
+	}{
+		{name: "first batch", userIDs: []uint64{5, 1, 3, 2, 4}, lastUserID: 0, limit: 2, want: []uint64{1, 2}},
+		{name: "resume after cursor", userIDs: []uint64{5, 1, 3, 2, 4}, lastUserID: 2, limit: 2, want: []uint64{3, 4}},
+		{name: "cursor between ids", userIDs: []uint64{10, 20, 30}, lastUserID: 15, limit: 5, want: []uint64{20, 30}},
+		{name: "duplicates", userIDs: []uint64{3, 1, 3, 1, 2, 2}, lastUserID: 0, limit: 3, want: []uint64{1, 2, 3}},
+		{name: "duplicate at cursor", userIDs: []uint64{2, 2, 3}, lastUserID: 2, limit: 3, want: []uint64{3}},
+		{name: "exhausted", userIDs: []uint64{1, 2}, lastUserID: 2, limit: 3, want: []uint64{}},
+		{name: "empty", userIDs: nil, lastUserID: 0, limit: 3, want: []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userIDs := append([]uint64(nil), tt.userIDs...)
+			got := service.NextUserIDsBatch(userIDs, tt.lastUserID, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("NextUserIDsBatch = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(userIDs, tt.userIDs) {
+				t.Fatalf("input modified: %v, want %v", userIDs, tt.userIDs)
+			}
+		})
+	}
+
+	// 按批推进游标可遍历全部受众且不重复
+	userIDs := []uint64{9, 7, 7, 1, 3, 5, 8, 2}
+	var delivered []uint64
+	lastUserID := uint64(0)
+	for {
+		batch := service.NextUserIDsBatch(userIDs, lastUserID, 3)
+		if len(batch) == 0 {
+			break
+		}
+		delivered = append(delivered, batch...)
+		lastUserID = batch[len(batch)-1]
+	}
+	if want := []uint64{1, 2, 3, 5, 7, 8, 9}; !reflect.DeepEqual(delivered, want) {
+		t.Fatalf("delivered = %v, want %v", delivered, want)
+	}
+}
+
+func newTestMailBroadcast(t *testing.T) *model.MailBroadcast {
+	t.Helper()
+	if err := model.MigrateMailBroadcast(dao.PgDB); err != nil {
+		t.Fatalf("MigrateMailBroadcast error: %v", err)
+	}
+	if err := model.MigrateUserMail(dao.PgDB); err != nil {
+		t.Fatalf("MigrateUserMail error: %v", err)
+	}
+	broadcast := &model.MailBroadcast{
+		TemplateID: uuid.NewString(),
+		Audience:   []byte(`{"type":"user_ids"}`),
+		Status:     model.MailBroadcastStatusRunning,
+		Operator:   "test",
+	}
+	if err := db.NewMailBroadcastDao(dao.PgDB).CreateMailBroadcast(broadcast); err != nil {
+		t.Fatalf("CreateMailBroadcast error: %v", err)
+	}
+	return broadcast
+}
+
+func newBroadcastMails(broadcast *model.MailBroadcast, userIDs []uint64) []*model.UserMail {
+	userMails := make([]*model.UserMail, 0, len(userIDs))
+	for _, userID := range userIDs {
+		userMails = append(userMails, &model.UserMail{
+			UserID:      userID,
+			TemplateID:  broadcast.TemplateID,
+			BroadcastID: broadcast.ID,
+			ExpiredTime: time.Now().Add(time.Hour).UnixMilli(),
+			Operator:    broadcast.Operator,
+		})
+	}
+	return userMails
+}
+
+func TestDeliverMailBatchCursor(t *testing.T) {
+	requirePg(t)
+
+	broadcast := newTestMailBroadcast(t)
+	broadcastDao := db.NewMailBroadcastDao(dao.PgDB)
+	base := newTestUserID()
+	first := []uint64{base + 1, base + 2}
+
+	delivered, err := broadcastDao.DeliverMailBatchByTx(broadcast.ID, 0, first[1], newBroadcastMails(broadcast, first))
+	if err != nil || delivered != 2 {
+		t.Fatalf("DeliverMailBatchByTx = %d, %v, want 2", delivered, err)
+	}
+	// 过期游标的重复批次回滚,不会多投递
+	stale := []uint64{base + 3}
+	if _, err = broadcastDao.DeliverMailBatchByTx(broadcast.ID, 0, stale[0], newBroadcastMails(broadcast, stale)); !errors.Is(err, db.ErrMailBroadcastCursorMoved) {
+		t.Fatalf("stale cursor error = %v, want ErrMailBroadcastCursorMoved", err)
+	}
+	var count int64
+	if err = dao.PgDB.Model(&model.UserMail{}).Where("broadcast_id = ?", broadcast.ID).Count(&count).Error; err != nil || count != 2 {
+		t.Fatalf("delivered mails = %d, %v, want 2", count, err)
+	}
+	// 重放已投递批次:已存在的邮件跳过
+	delivered, err = broadcastDao.DeliverMailBatchByTx(broadcast.ID, first[1], base+3, newBroadcastMails(broadcast, []uint64{first[1], base + 3}))
+	if err != nil || delivered != 1 {
+		t.Fatalf("DeliverMailBatchByTx replay = %d, %v, want 1", delivered, err)
+	}
+	got, err := broadcastDao.GetMailBroadcastByID(broadcast.ID)
+	if err != nil {
+		t.Fatalf("GetMailBroadcastByID error: %v", err)
+	}
+	if got.LastUserID != base+3 || got.Delivered != 3 {
+		t.Fatalf("cursor = %d delivered = %d, want %d and 3", got.LastUserID, got.Delivered, base+3)
+	}
+	// 同一模板再次群发(如补偿/周期奖励)仍投递给已收到过的用户
+	resend := newTestMailBroadcast(t)
+	resend.TemplateID = broadcast.TemplateID
+	delivered, err = broadcastDao.DeliverMailBatchByTx(resend.ID, 0, first[1], newBroadcastMails(resend, first))
+	if err != nil || delivered != 2 {
+		t.Fatalf("DeliverMailBatchByTx resend = %d, %v, want 2", delivered, err)
+	}
+}
+
+func TestRevokeMailsByBroadcastBatch(t *testing.T) {
+	requirePg(t)
+
+	broadcast := newTestMailBroadcast(t)
+	base := newTestUserID()
+	userIDs := []uint64{base + 1, base + 2, base + 3, base + 4, base + 5}
+	if _, err := db.NewMailBroadcastDao(dao.PgDB).DeliverMailBatchByTx(broadcast.ID, 0, userIDs[len(userIDs)-1], newBroadcastMails(broadcast, userIDs)); err != nil {
+		t.Fatalf("DeliverMailBatchByTx error: %v", err)
+	}
+
+	mailDao := db.NewUserMailDao(dao.PgDB)
+	revoked := make(map[uint64]bool)
+	for {
+		batch, err := mailDao.RevokeMailsByBroadcastAndBatch(broadcast.ID, "test", 2)
+		if err != nil {
+			t.Fatalf("RevokeMailsByBroadcastAndBatch error: %v", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if len(batch) > 2 {
+			t.Fatalf("batch size = %d, want <= 2", len(batch))
+		}
+		for _, userID := range batch {
+			if revoked[userID] {
+				t.Fatalf("user %d revoked twice", userID)
+			}
+			revoked[userID] = true
+		}
+	}
+	if len(revoked) != len(userIDs) {
+		t.Fatalf("revoked %d mails, want %d", len(revoked), len(userIDs))
+	}
+	var visible int64
+	if err := dao.PgDB.Model(&model.UserMail{}).Where("broadcast_id = ? and visible_status = ?", broadcast.ID, model.MailVisibleStatusDefault).Count(&visible).Error; err != nil || visible != 0 {
+		t.Fatalf("visible mails = %d, %v, want 0", visible, err)
+	}
+}
@@ -0,0 +1,172 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/model"
+	"ppt/service"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func requirePg(t *testing.T) {
+	t.Helper()
+	if dao.PgDB == nil {
+		t.Skip("postgres not initialized")
+	}
+}
+
+func requireMongo(t *testing.T) {
+	t.Helper()
+	if dao.MongoClient == nil {
+		t.Skip("mongo not initialized")
+	}
+}
+
+func requireRedis(t *testing.T) {
+	t.Helper()
+	if dao.RedisDB == nil {
+		t.Skip("redis not initialized")
+	}
+}
+
+// newTestUserID 生成测试用户ID,避免与已有数据冲突
+func newTestUserID() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+func newClaimTestMail(t *testing.T, userID uint64, awards map[string]int64) *model.UserMail {
+	t.Helper()
+	for _, migrate := range []func() error{
+		func() error { return model.MigrateUserMail(dao.PgDB) },
+		func() error { return model.MigrateUserCoinLedger(dao.PgDB) },
+		func() error { return model.MigrateUserInventory(dao.PgDB) },
+	} {
+		if err := migrate(); err != nil {
+			t.Fatalf("migrate error: %v", err)
+		}
+	}
+	awardsData, _ := json.Marshal(awards)
+	userMail := &model.UserMail{
+		UserID:      userID,
+		TemplateID:  uuid.NewString(),
+		Awards:      awardsData,
+		ExpiredTime: time.Now().Add(time.Hour).UnixMilli(),
+		Operator:    "test",
+	}
+	if err := dao.PgDB.Create(userMail).Error; err != nil {
+		t.Fatalf("create mail error: %v", err)
+	}
+	return userMail
+}
+
+func ledgerIDs(ledgers []*model.UserCoinLedger) []string {
+	ids := make([]string, 0, len(ledgers))
+	for _, ledger := range ledgers {
+		ids = append(ids, ledger.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestClaimMailAccessoryOnce(t *testing.T) {
+	requirePg(t)
+	requireMongo(t)
+
+	tests := []struct {
+		name        string
+		claims      int
+		concurrency int
+	}{
+		{name: "retry", claims: 3, concurrency: 1},
+		{name: "concurrent", claims: 20, concurrency: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := newTestUserID()
+			userMail := newClaimTestMail(t, userID, map[string]int64{code.CoinTypeGold: 100, code.CoinTypeSilver: 50})
+
+			results := make([]*service.MailClaimResult, tt.claims)
+			errs := make([]error, tt.claims)
+			sem := make(chan struct{}, tt.concurrency)
+			var wg sync.WaitGroup
+			for i := 0; i < tt.claims; i++ {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(i int) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					results[i], errs[i] = service.ClaimMailAccessory(context.Background(), userID, userMail.ID)
+				}(i)
+			}
+			wg.Wait()
+
+			for i, err := range errs {
+				if err != nil {
+					t.Fatalf("claim %d error: %v", i, err)
+				}
+			}
+			// 每次领取返回首次领取生成的同一组流水
+			want := ledgerIDs(results[0].Ledgers)
+			if len(want) != 2 {
+				t.Fatalf("ledgers = %v, want 2", want)
+			}
+			for i := range results {
+				if got := ledgerIDs(results[i].Ledgers); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+					t.Fatalf("claim %d ledgers = %v, want %v", i, got, want)
+				}
+			}
+			for coinType, amount := range map[string]int64{code.CoinTypeGold: 100, code.CoinTypeSilver: 50} {
+				balance, err := db.GetUserCoin(dao.MongoClient, userID, coinType)
+				if err != nil {
+					t.Fatalf("GetUserCoin error: %v", err)
+				}
+				if balance != amount {
+					t.Fatalf("%s balance = %d, want %d", coinType, balance, amount)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyCoinLedgerReplay(t *testing.T) {
+	requirePg(t)
+	requireMongo(t)
+
+	userID := newTestUserID()
+	userMail := newClaimTestMail(t, userID, map[string]int64{code.CoinTypeGold: 100})
+	result, err := service.ClaimMailAccessory(context.Background(), userID, userMail.ID)
+	if err != nil {
+		t.Fatalf("ClaimMailAccessory error: %v", err)
+	}
+
+	// 模拟ledger_ids已被新流水挤出,重放仍以PG流水状态为准
+	userCredit := dao.MongoClient.Database(dao.MongoDBPPT).Collection(dao.MongoCollUserCredit)
+	if _, err = userCredit.UpdateOne(dao.Ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"ledger_ids": []string{}}}); err != nil {
+		t.Fatalf("reset ledger_ids error: %v", err)
+	}
+	for _, ledger := range result.Ledgers {
+		stale := *ledger
+		stale.Status = model.CoinLedgerStatusPending
+		if err = service.ApplyCoinLedger(&stale); err != nil {
+			t.Fatalf("ApplyCoinLedger error: %v", err)
+		}
+	}
+	if err = service.ApplyUserPendingCoinLedgers(userID); err != nil {
+		t.Fatalf("ApplyUserPendingCoinLedgers error: %v", err)
+	}
+	balance, err := db.GetUserCoin(dao.MongoClient, userID, code.CoinTypeGold)
+	if err != nil {
+		t.Fatalf("GetUserCoin error: %v", err)
+	}
+	if balance != 100 {
+		t.Fatalf("gold balance = %d after replay, want 100", balance)
+	}
+}
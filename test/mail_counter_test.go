@@ -0,0 +1,49 @@
+package test
+
+import (
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/model"
+	"testing"
+	"time"
+)
+
+func TestPopUserMailExpireNotices(t *testing.T) {
+	requireRedis(t)
+
+	now := time.Now().UnixMilli()
+	expired, pending := newTestUserID(), newTestUserID()+1
+	if err := db.SetUserMailCounter(dao.RedisDB, expired, &model.UserMailCounter{Unread: 1, NextExpire: now - 1000}); err != nil {
+		t.Fatalf("SetUserMailCounter error: %v", err)
+	}
+	if err := db.SetUserMailCounter(dao.RedisDB, pending, &model.UserMailCounter{Unread: 1, NextExpire: now + time.Hour.Milliseconds()}); err != nil {
+		t.Fatalf("SetUserMailCounter error: %v", err)
+	}
+	defer db.DelUserMailCounters(dao.RedisDB, []uint64{expired, pending})
+	defer dao.RedisDB.ZRem(dao.Ctx, dao.UserMailExpireNoticeKey, expired, pending)
+
+	userIDs, err := db.PopUserMailExpireNotices(dao.RedisDB, now, dao.UserMailExpireNoticeBatch)
+	if err != nil {
+		t.Fatalf("PopUserMailExpireNotices error: %v", err)
+	}
+	found := false
+	for _, userID := range userIDs {
+		if userID == pending {
+			t.Fatalf("user %d popped before its mail expires", pending)
+		}
+		found = found || userID == expired
+	}
+	if !found {
+		t.Fatalf("PopUserMailExpireNotices = %v, want to contain %d", userIDs, expired)
+	}
+	// 已取出的用户不会被再次通知
+	userIDs, err = db.PopUserMailExpireNotices(dao.RedisDB, now, dao.UserMailExpireNoticeBatch)
+	if err != nil {
+		t.Fatalf("PopUserMailExpireNotices error: %v", err)
+	}
+	for _, userID := range userIDs {
+		if userID == expired {
+			t.Fatalf("user %d popped twice", expired)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package test
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"ppt/code"
+	"ppt/dao"
+	"ppt/dao/db"
+	"ppt/model"
+	"reflect"
+	"testing"
+)
+
+func TestParseMailGrants(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantAwards []model.MailAward
+		wantGrants []model.MailGrant
+	}{
+		{
+			name:       "mixed coins and grants",
+			data:       `{"gold":100,"pet:2001":2,"item:1002":5,"item:1001":1}`,
+			wantAwards: []model.MailAward{{CoinType: code.CoinTypeGold, Amount: 100}},
+			wantGrants: []model.MailGrant{
+				{Kind: code.AwardKindItem, ConfigID: 1001, Count: 1},
+				{Kind: code.AwardKindItem, ConfigID: 1002, Count: 5},
+				{Kind: code.AwardKindPet, ConfigID: 2001, Count: 2},
+			},
+		},
+		{
+			name:       "invalid keys and counts ignored",
+			data:       `{"diamond":10,"gold":0,"item:0":1,"item:abc":1,"mount:1":1,"pet:2001":-1}`,
+			wantAwards: []model.MailAward{},
+			wantGrants: []model.MailGrant{},
+		},
+		{
+			name: "empty",
+			data: ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			awards, err := model.ParseMailAwards([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("ParseMailAwards error: %v", err)
+			}
+			grants, err := model.ParseMailGrants([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("ParseMailGrants error: %v", err)
+			}
+			gotAwards := make([]model.MailAward, 0, len(awards))
+			for _, award := range awards {
+				gotAwards = append(gotAwards, *award)
+			}
+			gotGrants := make([]model.MailGrant, 0, len(grants))
+			for _, grant := range grants {
+				gotGrants = append(gotGrants, *grant)
+			}
+			if len(gotAwards) != len(tt.wantAwards) || (len(gotAwards) > 0 && !reflect.DeepEqual(gotAwards, tt.wantAwards)) {
+				t.Fatalf("awards = %+v, want %+v", gotAwards, tt.wantAwards)
+			}
+			if len(gotGrants) != len(tt.wantGrants) || (len(gotGrants) > 0 && !reflect.DeepEqual(gotGrants, tt.wantGrants)) {
+				t.Fatalf("grants = %+v, want %+v", gotGrants, tt.wantGrants)
+			}
+		})
+	}
+}
+
+func TestCreateAwardsInventories(t *testing.T) {
+	requirePg(t)
+	if err := model.MigrateUserCoinLedger(dao.PgDB); err != nil {
+		t.Fatalf("MigrateUserCoinLedger error: %v", err)
+	}
+	if err := model.MigrateUserInventory(dao.PgDB); err != nil {
+		t.Fatalf("MigrateUserInventory error: %v", err)
+	}
+
+	userID, sourceID := newTestUserID(), uuid.NewString()
+	awards := []byte(`{"gold":100,"item:1001":5,"pet:2001":3}`)
+	nextID := int64(newTestUserID())
+	mintID := func(kind string) (int64, error) {
+		nextID++
+		return nextID, nil
+	}
+	create := func() ([]*model.UserCoinLedger, []*model.UserInventory, error) {
+		var ledgers []*model.UserCoinLedger
+		var inventories []*model.UserInventory
+		err := dao.PgDB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			ledgers, inventories, err = db.CreateAwardsByTx(tx, userID, model.CoinLedgerSourceMail, sourceID, awards, mintID)
+			return err
+		})
+		return ledgers, inventories, err
+	}
+
+	ledgers, inventories, err := create()
+	if err != nil {
+		t.Fatalf("CreateAwardsByTx error: %v", err)
+	}
+	if len(ledgers) != 1 || ledgers[0].Amount != 100 {
+		t.Fatalf("ledgers = %+v, want one gold ledger", ledgers)
+	}
+	// 道具堆叠为一个实例,宠物每只一个实例
+	kinds := make(map[string][]int64)
+	for _, inventory := range inventories {
+		kinds[inventory.Kind] = append(kinds[inventory.Kind], inventory.Count)
+	}
+	if !reflect.DeepEqual(kinds[code.AwardKindItem], []int64{5}) || !reflect.DeepEqual(kinds[code.AwardKindPet], []int64{1, 1, 1}) {
+		t.Fatalf("inventories by kind = %v", kinds)
+	}
+	// 同一来源重复发放被唯一索引拒绝,整个事务回滚
+	if _, _, err = create(); err == nil {
+		t.Fatalf("duplicate CreateAwardsByTx succeeded")
+	}
+	stored, err := db.GetInventoriesBySource(dao.PgDB, model.CoinLedgerSourceMail, sourceID)
+	if err != nil || len(stored) != len(inventories) {
+		t.Fatalf("stored inventories = %d, %v, want %d", len(stored), err, len(inventories))
+	}
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"context"
+	"ppt/config"
+	"ppt/mailer"
+	"strings"
+	"testing"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	m := mailer.NewMemoryMailer()
+	mailer.SetMailer(m)
+	if mailer.GetMailer() != m {
+		t.Fatal("GetMailer should return the mailer set")
+	}
+	for _, to := range []string{"a@example.com", "b@example.com", "a@example.com"} {
+		if err := m.Send(context.Background(), &mailer.Message{To: to, Subject: "code", Body: to}); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+	if n := len(m.Messages()); n != 3 {
+		t.Fatalf("Messages len = %d, want 3", n)
+	}
+	if msg := m.Last("a@example.com"); msg == nil || msg != m.Messages()[2] {
+		t.Fatalf("Last should return the latest message, got %+v", msg)
+	}
+	if msg := m.Last("c@example.com"); msg != nil {
+		t.Fatalf("Last of unknown recipient = %+v, want nil", msg)
+	}
+}
+
+func TestSMTPBuildMessage(t *testing.T) {
+	raw := string(mailer.BuildMessage("noreply@example.com", &mailer.Message{
+		To:      "a@example.com",
+		Subject: "邮箱验证码",
+		Body:    "line1\nline2",
+	}))
+	header, body, found := strings.Cut(raw, "\r\n\r\n")
+	if !found {
+		t.Fatal("message should separate header and body with CRLF")
+	}
+	for _, want := range []string{"From: noreply@example.com", "To: a@example.com", "Subject: =?utf-8?q?", "Content-Type: text/plain; charset=utf-8"} {
+		if !strings.Contains(header, want) {
+			t.Fatalf("header missing %q:\n%s", want, header)
+		}
+	}
+	if body != "line1\r\nline2" {
+		t.Fatalf("body = %q, want CRLF line endings", body)
+	}
+	if _, err := mailer.NewSMTPMailer(&config.SmtpConfig{}); err == nil {
+		t.Fatal("NewSMTPMailer should reject empty config")
+	}
+}
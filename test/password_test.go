@@ -0,0 +1,30 @@
+package test
+
+import (
+	"ppt/util"
+	"strings"
+	"testing"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := util.HashPassword("tdv23d8rf")
+	if err != nil {
+		t.Fatalf("HashPassword error: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+	other, _ := util.HashPassword("tdv23d8rf")
+	if other == hash {
+		t.Fatal("hash should be salted")
+	}
+	if ok, err := util.VerifyPassword("tdv23d8rf", hash); err != nil || !ok {
+		t.Fatalf("VerifyPassword = %v, %v, want true", ok, err)
+	}
+	if ok, _ := util.VerifyPassword("wrong-pass", hash); ok {
+		t.Fatal("VerifyPassword should reject wrong password")
+	}
+	if _, err := util.VerifyPassword("tdv23d8rf", "md5:abc"); err == nil {
+		t.Fatal("VerifyPassword should reject invalid hash")
+	}
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"ppt/config"
+	"ppt/dao"
+	"ppt/router"
+	"ppt/service"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegisterGuardCIDR(t *testing.T) {
+	guard, err := service.NewRegisterGuard(&config.RegisterGuardConfig{
+		AllowCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"},
+		DenyCIDRs:  []string{"10.1.0.0/16", "203.0.113.7/32"},
+	})
+	if err != nil {
+		t.Fatalf("NewRegisterGuard error: %v", err)
+	}
+	tests := []struct {
+		ip      string
+		denied  bool
+		allowed bool
+	}{
+		{"10.2.3.4", false, true},
+		{"10.1.2.3", true, false}, // 黑名单优先
+		{"203.0.113.7", true, false},
+		{"::ffff:203.0.113.7", true, false},
+		{"203.0.113.8", false, false},
+		{"2001:db8::1", false, true},
+		{"not-an-ip", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		denied, allowed := guard.MatchCIDR(tt.ip)
+		if denied != tt.denied || allowed != tt.allowed {
+			t.Fatalf("MatchCIDR(%q) = %v, %v, want %v, %v", tt.ip, denied, allowed, tt.denied, tt.allowed)
+		}
+	}
+	if _, err = service.NewRegisterGuard(&config.RegisterGuardConfig{DenyCIDRs: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatal("NewRegisterGuard should reject invalid cidr")
+	}
+}
+
+func TestRegisterGuardSpoofedForwardedFor(t *testing.T) {
+	guard, err := service.NewRegisterGuard(&config.RegisterGuardConfig{DenyCIDRs: []string{"203.0.113.7/32"}})
+	if err != nil {
+		t.Fatalf("NewRegisterGuard error: %v", err)
+	}
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		ip      string
+		denied  bool
+	}{
+		{"untrusted peer spoofs header", nil, "203.0.113.7:4321", "203.0.113.7", true},
+		{"trusted proxy forwards header", []string{"10.0.0.1"}, "10.0.0.1:4321", "198.51.100.9", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := router.NewEngine(tt.proxies)
+			if err != nil {
+				t.Fatalf("NewEngine error: %v", err)
+			}
+			var ip string
+			var denied bool
+			engine.GET("/guard", func(c *gin.Context) {
+				ip = c.ClientIP()
+				denied, _ = guard.MatchCIDR(ip)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/guard", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set("X-Forwarded-For", "198.51.100.9")
+			req.Header.Set("X-Real-IP", "198.51.100.9")
+			engine.ServeHTTP(httptest.NewRecorder(), req)
+			if ip != tt.ip || denied != tt.denied {
+				t.Fatalf("ClientIP = %q denied = %v, want %q %v", ip, denied, tt.ip, tt.denied)
+			}
+		})
+	}
+}
+
+func TestRegisterGuardReserveConcurrent(t *testing.T) {
+	requireRedis(t)
+
+	guard, err := service.NewRegisterGuard(&config.RegisterGuardConfig{IPDailyMax: 3})
+	if err != nil {
+		t.Fatalf("NewRegisterGuard error: %v", err)
+	}
+	now := time.Now()
+	ip := fmt.Sprintf("198.51.100.%d", now.UnixNano()%250+1)
+	defer dao.RedisDB.Del(dao.Ctx, fmt.Sprintf(dao.RegisterIPKey, now.Format("20060102"), ip))
+
+	var (
+		mu       sync.Mutex
+		releases []func()
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reason, release, err := guard.Reserve(ip, "", now)
+			if err != nil {
+				t.Errorf("Reserve error: %v", err)
+				return
+			}
+			if reason == "" {
+				mu.Lock()
+				releases = append(releases, release)
+				mu.Unlock()
+			} else if reason != service.RegisterBlockIPLimit {
+				t.Errorf("Reserve reason = %q, want %q", reason, service.RegisterBlockIPLimit)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(releases) != 3 {
+		t.Fatalf("concurrent reservations passed = %d, want 3", len(releases))
+	}
+	// 注册失败归还占用后可再次注册
+	releases[0]()
+	if reason, _, err := guard.Reserve(ip, "", now); err != nil || reason != "" {
+		t.Fatalf("Reserve after release = %q, %v, want passed", reason, err)
+	}
+	if reason, _, err := guard.Reserve(ip, "", now); err != nil || reason != service.RegisterBlockIPLimit {
+		t.Fatalf("Reserve over limit = %q, %v, want %q", reason, err, service.RegisterBlockIPLimit)
+	}
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"ppt/config"
+	"ppt/util"
+	"testing"
+)
+
+func TestJwtKeyRotation(t *testing.T) {
+	oldKey := config.JwtKey{Kid: "k1", Secret: "ppt-test-secret-k1-0123456789abcdef"}
+	newKey := config.JwtKey{Kid: "k2", Secret: "ppt-test-secret-k2-0123456789abcdef"}
+	if err := util.InitJwtKeys(&config.JwtConfig{Issuer: "ppt", ActiveKid: "k1", Keys: []config.JwtKey{oldKey}, AccessTTL: 60}); err != nil {
+		t.Fatalf("InitJwtKeys error: %v", err)
+	}
+	oldToken, _, err := util.GenerateAccessToken(1001, "ppt_001", "s1", nil, false)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken error: %v", err)
+	}
+
+	// 轮换:新token使用k2签名,k1签发的token在过期前仍有效
+	if err = util.InitJwtKeys(&config.JwtConfig{Issuer: "ppt", ActiveKid: "k2", Keys: []config.JwtKey{newKey, oldKey}, AccessTTL: 60}); err != nil {
+		t.Fatalf("InitJwtKeys error: %v", err)
+	}
+	newToken, _, err := util.GenerateAccessToken(1002, "ppt_002", "s2", []string{"admin"}, false)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken error: %v", err)
+	}
+	for token, wantID := range map[string]uint64{oldToken: 1001, newToken: 1002} {
+		claims, err := util.ParseToken(token)
+		if err != nil || claims.UserID != wantID {
+			t.Fatalf("ParseToken = %+v, %v, want id %d", claims, err, wantID)
+		}
+	}
+
+	// k1下线后其签发的token失效
+	if err = util.InitJwtKeys(&config.JwtConfig{Issuer: "ppt", ActiveKid: "k2", Keys: []config.JwtKey{newKey}, AccessTTL: 60}); err != nil {
+		t.Fatalf("InitJwtKeys error: %v", err)
+	}
+	if _, err = util.ParseToken(oldToken); !errors.Is(err, util.ErrTokenInvalid) {
+		t.Fatalf("ParseToken old token err = %v, want ErrTokenInvalid", err)
+	}
+
+	if err = util.InitJwtKeys(&config.JwtConfig{ActiveKid: "k3", Keys: []config.JwtKey{newKey}}); !errors.Is(err, util.ErrJwtConfigInvalid) {
+		t.Fatalf("InitJwtKeys unknown active kid err = %v, want ErrJwtConfigInvalid", err)
+	}
+}
+
+func TestJwtIssuerAudience(t *testing.T) {
+	key := config.JwtKey{Kid: "k1", Secret: "ppt-test-secret-k1-0123456789abcdef"}
+	if err := util.InitJwtKeys(&config.JwtConfig{Issuer: "ppt", Audience: "ppt-game", ActiveKid: "k1", Keys: []config.JwtKey{key}}); err != nil {
+		t.Fatalf("InitJwtKeys error: %v", err)
+	}
+	token, _, err := util.GenerateAccessToken(1001, "ppt_001", "s1", nil, false)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken error: %v", err)
+	}
+	if _, err = util.ParseToken(token); err != nil {
+		t.Fatalf("ParseToken error: %v", err)
+	}
+	for _, cfg := range []config.JwtConfig{
+		{Issuer: "other", Audience: "ppt-game", ActiveKid: "k1", Keys: []config.JwtKey{key}},
+		{Issuer: "ppt", Audience: "ppt-admin", ActiveKid: "k1", Keys: []config.JwtKey{key}},
+	} {
+		if err = util.InitJwtKeys(&cfg); err != nil {
+			t.Fatalf("InitJwtKeys error: %v", err)
+		}
+		if _, err = util.ParseToken(token); !errors.Is(err, util.ErrTokenInvalid) {
+			t.Fatalf("ParseToken iss=%s aud=%s err = %v, want ErrTokenInvalid", cfg.Issuer, cfg.Audience, err)
+		}
+	}
+}
+
+func TestAccessTokenSession(t *testing.T) {
+	key := config.JwtKey{Kid: "k1", Secret: "ppt-test-secret-k1-0123456789abcdef"}
+	if err := util.InitJwtKeys(&config.JwtConfig{Issuer: "ppt", ActiveKid: "k1", Keys: []config.JwtKey{key}}); err != nil {
+		t.Fatalf("InitJwtKeys error: %v", err)
+	}
+	token, _, err := util.GenerateAccessToken(1001, "ppt_001", "s1", nil, false)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken error: %v", err)
+	}
+	claims, err := util.ParseToken(token)
+	if err != nil || claims.SessionID != "s1" {
+		t.Fatalf("ParseToken = %+v, %v, want session s1", claims, err)
+	}
+	// 未绑定会话的token无法注销,直接拒绝
+	token, _, _ = util.GenerateAccessToken(1001, "ppt_001", "", nil, false)
+	if _, err = util.ParseToken(token); !errors.Is(err, util.ErrTokenInvalid) {
+		t.Fatalf("ParseToken without session err = %v, want ErrTokenInvalid", err)
+	}
+}
+
+func TestAuthMiddlewareStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key := config.JwtKey{Kid: "k1", Secret: "ppt-test-secret-k1-0123456789abcdef"}
+	if err := util.InitJwtKeys(&config.JwtConfig{Issuer: "ppt", ActiveKid: "k1", Keys: []config.JwtKey{key}}); err != nil {
+		t.Fatalf("InitJwtKeys error: %v", err)
+	}
+
+	r := gin.New()
+	r.GET("/user", util.AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	admin := r.Group("/admin", func(c *gin.Context) {
+		// 跳过吊销检查,直接写入声明
+		claims, err := util.ParseBearerToken(c)
+		if err == nil {
+			c.Set(util.ContextKeyClaims, claims)
+		}
+	}, util.RequireRoles("admin"))
+	admin.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	userToken, _, _ := util.GenerateAccessToken(1001, "ppt_001", "s1", nil, false)
+	adminToken, _, _ := util.GenerateAccessToken(1002, "ppt_002", "s2", []string{"admin"}, false)
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"missing token", "/user", "", http.StatusUnauthorized},
+		{"wrong scheme", "/user", "Basic " + userToken, http.StatusUnauthorized},
+		{"bad token", "/user", "Bearer not-a-jwt", http.StatusUnauthorized},
+		{"admin without token", "/admin/ping", "", http.StatusUnauthorized},
+		{"admin without role", "/admin/ping", "Bearer " + userToken, http.StatusForbidden},
+		{"admin with role", "/admin/ping", "bearer " + adminToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"encoding/base64"
+	"github.com/pquerna/otp/totp"
+	"ppt/config"
+	"ppt/util"
+	"testing"
+	"time"
+)
+
+func TestTotpStep(t *testing.T) {
+	key, err := util.GenerateTOTPKey("ppt", "ppt_001")
+	if err != nil {
+		t.Fatalf("GenerateTOTPKey error: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	code, err := totp.GenerateCode(key.Secret(), now)
+	if err != nil {
+		t.Fatalf("GenerateCode error: %v", err)
+	}
+	step, ok := util.ValidateTOTPStep(code, key.Secret(), now)
+	if !ok || step != now.Unix()/30 {
+		t.Fatalf("ValidateTOTPStep = %d, %v, want %d", step, ok, now.Unix()/30)
+	}
+	// 允许前后一个时间步的时钟偏差
+	if _, ok = util.ValidateTOTPStep(code, key.Secret(), now.Add(30*time.Second)); !ok {
+		t.Fatal("ValidateTOTPStep should accept previous step")
+	}
+	if _, ok = util.ValidateTOTPStep(code, key.Secret(), now.Add(2*time.Minute)); ok {
+		t.Fatal("ValidateTOTPStep should reject stale code")
+	}
+}
+
+func TestTotpSecretEncrypt(t *testing.T) {
+	if err := util.InitTotpConfig(&config.TotpConfig{EncryptKey: "short"}); err == nil {
+		t.Fatal("InitTotpConfig should reject invalid key")
+	}
+	encryptKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	if err := util.InitTotpConfig(&config.TotpConfig{EncryptKey: encryptKey}); err != nil {
+		t.Fatalf("InitTotpConfig error: %v", err)
+	}
+	secretEnc, err := util.EncryptTotpSecret("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("EncryptTotpSecret error: %v", err)
+	}
+	if secretEnc == "JBSWY3DPEHPK3PXP" {
+		t.Fatal("secret stored in plaintext")
+	}
+	secret, err := util.DecryptTotpSecret(secretEnc)
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("DecryptTotpSecret = %s, %v", secret, err)
+	}
+}
@@ -1,11 +1,14 @@
 package timer
 
 import (
+	"errors"
 	"go.uber.org/zap"
 	"ppt/config"
 	"ppt/dao"
 	"ppt/dao/db"
 	"ppt/log"
+	"ppt/model"
+	"ppt/service"
 	"time"
 )
 
@@ -14,6 +17,18 @@ func InitTimer() error {
 	if err != nil {
 		return err
 	}
+	err = initMailBroadcastResumeTimer()
+	if err != nil {
+		return err
+	}
+	err = initCouponExpireTimer()
+	if err != nil {
+		return err
+	}
+	err = initUserMailExpireNoticeTimer()
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -27,6 +42,51 @@ func initUserMailExpireTimer() error {
 	return nil
 }
 
+func initMailBroadcastResumeTimer() error {
+	spec := "0 */5 * * * *"
+	err := CreateCron("mailBroadcastResumeTimer", spec, config.TimeZone, service.ResumeStaleMailBroadcasts)
+	if err != nil {
+		log.Error("initMailBroadcastResumeTimer init mailBroadcastResumeTimer error", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func initCouponExpireTimer() error {
+	spec := "0 5 * * * *"
+	err := CreateCron("couponExpireTimer", spec, config.TimeZone, couponExpire)
+	if err != nil {
+		log.Error("initCouponExpireTimer init couponExpireTimer error", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func initUserMailExpireNoticeTimer() error {
+	spec := "0 * * * * *"
+	err := CreateCron("userMailExpireNoticeTimer", spec, config.TimeZone, service.NotifyExpiredUserMails)
+	if err != nil {
+		log.Error("initUserMailExpireNoticeTimer init userMailExpireNoticeTimer error", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func couponExpire() {
+	ok, err := db.GetCouponExpiredLock(dao.RedisDB, dao.CouponExpiredKey, dao.CouponExpiredKeyExpire)
+	if err != nil {
+		log.Error("couponExpireTimer get couponExpiredLock error", zap.Error(err))
+		return
+	}
+	if !ok {
+		log.Info("couponExpireTimer not get couponExpiredLock")
+		return
+	}
+	go func() {
+		service.ExpireCoupons()
+	}()
+}
+
 func userMailExpire() {
 	ok, err := db.GetUserMailExpiredLock(dao.RedisDB, dao.UserMailExpiredKey, dao.UserMailExpiredKeyExpire)
 	if err != nil {
@@ -46,7 +106,7 @@ func doUserMailExpireDelete() {
 	begin := time.Now()
 	flag := true
 	for flag {
-		delMails, err := db.NewUserMailDao(dao.PgDB).DeleteUserMailsByExpiredTimeAndBatch(time.Now(), int32(dao.UserMailExpiredDeleteBatch))
+		delMails, err := db.NewUserMailDao(dao.PgDB).ArchiveAndDeleteExpiredMails(time.Now(), int32(dao.UserMailExpiredDeleteBatch), archiveUserMails)
 		if err != nil {
 			log.Error("doUserMailExpireDelete delete userMailExpiredLock error", zap.Error(err))
 			flag = false
@@ -57,9 +117,22 @@ func doUserMailExpireDelete() {
 			flag = false
 			continue
 		}
-		// todo 归档处理
+		log.Info("doUserMailExpireDelete archive and delete batch", zap.Int("batch_size", len(delMails)))
 	}
 
 	deleteCost := time.Since(begin).Seconds()
 	log.Info("doUserMailExpireDelete cost seconds", zap.Float64("delete_cost", deleteCost))
 }
+
+// archiveUserMails 过期邮件归档至ClickHouse
+func archiveUserMails(userMails []*model.UserMail) error {
+	if dao.CKSqlSession == nil {
+		return errors.New("clickhouse not initialized")
+	}
+	archiveTime := time.Now().UnixMilli()
+	archives := make([]*model.UserMailArchive, 0, len(userMails))
+	for _, userMail := range userMails {
+		archives = append(archives, model.NewUserMailArchive(userMail, archiveTime))
+	}
+	return db.InsertUserMailArchives(dao.CKSqlSession, archives)
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"ppt/log"
+	"ppt/login/db"
+	"strings"
+	"time"
+)
+
+const ContextKeyClaims = "Claims"
+
+var ErrTokenMissing = errors.New("missing bearer token")
+
+// AuthMiddleware 校验Authorization: Bearer access token(签名、有效期、签发方、受众、吊销及会话状态)
+// 校验通过后将*JwtCustomClaims写入上下文,失败返回401
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := ParseBearerToken(c)
+		if err != nil {
+			abortUnauthorized(c, err)
+			return
+		}
+		revoked, err := db.IsAccessTokenRevoked(claims.RegisteredClaims.ID, claims.SessionID, GetRefreshTokenTTL())
+		if err != nil {
+			log.Error("AuthMiddleware IsAccessTokenRevoked error", zap.Uint64("user_id", claims.UserID), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "auth unavailable"})
+			return
+		}
+		if revoked {
+			abortUnauthorized(c, errors.New("token revoked"))
+			return
+		}
+		c.Set(ContextKeyClaims, claims)
+		c.Next()
+	}
+}
+
+// RequireRoles 要求已认证用户拥有任一指定角色,需在AuthMiddleware之后使用;未认证返回401,无权限返回403
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetContextClaims(c)
+		if !ok {
+			abortUnauthorized(c, ErrTokenMissing)
+			return
+		}
+		for _, role := range roles {
+			if claims.HasRole(role) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	}
+}
+
+// RequireMFA 要求登录时已通过两步验证,需在AuthMiddleware之后使用
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetContextClaims(c)
+		if !ok {
+			abortUnauthorized(c, ErrTokenMissing)
+			return
+		}
+		if !claims.MFA {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "two-factor authentication required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ParseBearerToken 解析请求头中的Bearer access token(不检查吊销状态)
+func ParseBearerToken(c *gin.Context) (*JwtCustomClaims, error) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, TokenTypeBearer) || strings.TrimSpace(token) == "" {
+		return nil, ErrTokenMissing
+	}
+	return ParseToken(strings.TrimSpace(token))
+}
+
+// RevokeToken 吊销access token直至其过期
+func RevokeToken(claims *JwtCustomClaims) error {
+	if claims.ExpiresAt == nil {
+		return nil
+	}
+	return db.RevokeAccessToken(claims.RegisteredClaims.ID, time.Until(claims.ExpiresAt.Time)+tokenLeeway)
+}
+
+// GetContextClaims 获取认证后的Token信息
+func GetContextClaims(c *gin.Context) (*JwtCustomClaims, bool) {
+	claimsAny, exists := c.Get(ContextKeyClaims)
+	if !exists {
+		return nil, false
+	}
+	claims, ok := claimsAny.(*JwtCustomClaims)
+	return claims, ok
+}
+
+// GetContextUserID 获取认证后的UserID
+func GetContextUserID(c *gin.Context) (uint64, bool) {
+	claims, ok := GetContextClaims(c)
+	if !ok || claims.UserID == 0 {
+		return 0, false
+	}
+	return claims.UserID, true
+}
+
+func abortUnauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+}
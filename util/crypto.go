@@ -3,10 +3,13 @@ package util
 import (
 	"bytes"
 	"crypto/aes"
+	"crypto/cipher"
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 )
 
 // SignSHA256WithKey SHA-256签名
@@ -82,3 +85,44 @@ func EcbDecrypt(cipherStr, key string) ([]byte, error) {
 	}
 	return PKCS7UnPadding(ciphertext), nil
 }
+
+// GcmEncrypt AES-GCM加密,key为base64编码的16/24/32字节密钥,返回base64(nonce+密文)
+func GcmEncrypt(data []byte, key string) (string, error) {
+	aead, err := newGcm(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, data, nil)), nil
+}
+
+// GcmDecrypt AES-GCM解密
+func GcmDecrypt(cipherStr, key string) ([]byte, error) {
+	aead, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(cipherStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("gcm ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+func newGcm(key string) (cipher.AEAD, error) {
+	keyByte, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(keyByte)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+// argon2id参数(OWASP推荐配置)
+const (
+	passwordArgonTime    = 2
+	passwordArgonMemory  = 19 * 1024
+	passwordArgonThreads = 1
+	passwordArgonKeyLen  = 32
+	passwordSaltLen      = 16
+)
+
+var ErrPasswordHashInvalid = errors.New("password hash invalid")
+
+// HashPassword 使用argon2id生成密码哈希,格式为$argon2id$v=19$m=,t=,p=$salt$hash
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(password), salt, passwordArgonTime, passwordArgonMemory, passwordArgonThreads, passwordArgonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, passwordArgonMemory, passwordArgonTime, passwordArgonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifyPassword 校验密码与哈希是否匹配,参数取自哈希串以兼容参数调整前的密码
+func VerifyPassword(password, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, ErrPasswordHashInvalid
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrPasswordHashInvalid
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, ErrPasswordHashInvalid
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrPasswordHashInvalid
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ErrPasswordHashInvalid
+	}
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(hash, other) == 1, nil
+}
@@ -1,66 +1,109 @@
 package util
 
 import (
-	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"ppt/login/db"
+	"github.com/google/uuid"
+	"ppt/config"
+	"sync/atomic"
 	"time"
 )
 
-const secret = "ppt&w4td%vw*er3r4tfd324sde"
+const (
+	TokenTypeBearer          = "Bearer"
+	defaultAccessTokenTTL    = 15 * time.Minute
+	defaultRefreshTokenTTL   = 30 * 24 * time.Hour
+	tokenHeaderKid           = "kid"
+	tokenSubjectAccess       = "access"
+	refreshTokenRandomLength = 32
+	tokenLeeway              = 30 * time.Second // 允许的时钟偏差
+)
 
-var ctx = context.Background()
+var (
+	ErrTokenInvalid     = errors.New("invalid token")
+	ErrJwtConfigInvalid = errors.New("jwt config invalid")
 
-func AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		token := c.PostForm("token")
-		if token == "" {
-			c.JSON(200, gin.H{
-				"code":     401,
-				"response": "should sync token",
-			})
-			c.Abort()
-			return
-		}
-		//key := FormatTokenKey(name)
-		custClaim, err := ParseToken(token)
-		if err != nil {
-			c.JSON(200, gin.H{
-				"code":     401,
-				"response": "invalid token",
-			})
-			c.Abort()
-			return
+	jwtKeySet atomic.Pointer[JwtKeySet]
+)
+
+// JwtKeySet JWT签名密钥集合
+type JwtKeySet struct {
+	issuer     string
+	audience   string
+	activeKid  string
+	keys       map[string][]byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// InitJwtKeys 启动时加载JWT签名密钥;轮换密钥需更新配置中的ActiveKid并重启服务,旧kid保留在Keys中直至已签发token过期
+func InitJwtKeys(cfg *config.JwtConfig) error {
+	keySet := &JwtKeySet{
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		activeKid:  cfg.ActiveKid,
+		keys:       make(map[string][]byte, len(cfg.Keys)),
+		accessTTL:  time.Duration(cfg.AccessTTL) * time.Second,
+		refreshTTL: time.Duration(cfg.RefreshTTL) * time.Second,
+	}
+	for _, key := range cfg.Keys {
+		if key.Kid == "" || len(key.Secret) < 32 {
+			return fmt.Errorf("%w: key %q requires kid and secret of at least 32 bytes", ErrJwtConfigInvalid, key.Kid)
 		}
-		if !db.IsTokenOutOfDate(custClaim.ID) {
-			c.JSON(200, gin.H{
-				"code":     401,
-				"response": "token out of date",
-			})
-			c.Abort()
-			return
+		if _, ok := keySet.keys[key.Kid]; ok {
+			return fmt.Errorf("%w: duplicate kid %s", ErrJwtConfigInvalid, key.Kid)
 		}
-		//UpdateToken(c, token)
+		keySet.keys[key.Kid] = []byte(key.Secret)
 	}
+	if _, ok := keySet.keys[keySet.activeKid]; !ok {
+		return fmt.Errorf("%w: active kid %q not found", ErrJwtConfigInvalid, keySet.activeKid)
+	}
+	if keySet.issuer == "" {
+		keySet.issuer = "ppt"
+	}
+	if keySet.accessTTL <= 0 {
+		keySet.accessTTL = defaultAccessTokenTTL
+	}
+	if keySet.refreshTTL <= 0 {
+		keySet.refreshTTL = defaultRefreshTokenTTL
+	}
+	jwtKeySet.Store(keySet)
+	return nil
 }
 
-func FormatTokenKey(name string) string {
-	return fmt.Sprintf("token_%s", name)
-}
-
-func UpdateToken(c *gin.Context, token string) {
-	db.GetRedis().Set(ctx, token, token, 24*time.Hour)
+// GetRefreshTokenTTL refresh token有效期
+func GetRefreshTokenTTL() time.Duration {
+	if keySet := jwtKeySet.Load(); keySet != nil {
+		return keySet.refreshTTL
+	}
+	return defaultRefreshTokenTTL
 }
 
+// JwtCustomClaims access token声明,RegisteredClaims.ID为token唯一ID(jti),用于吊销
 type JwtCustomClaims struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	UserID    uint64   `json:"uid"`
+	Name      string   `json:"name"`
+	SessionID string   `json:"sid"` // 所属登录会话,会话注销后token随即失效
+	Roles     []string `json:"roles,omitempty"`
+	MFA       bool     `json:"mfa,omitempty"` // 登录时是否通过两步验证
 	jwt.RegisteredClaims
 }
 
+// HasRole 是否拥有指定角色
+func (j *JwtCustomClaims) HasRole(role string) bool {
+	for _, r := range j.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 func (j *JwtCustomClaims) GetAudience() (jwt.ClaimStrings, error) {
 	return j.Audience, nil
 }
@@ -85,28 +128,94 @@ func (j *JwtCustomClaims) GetSubject() (string, error) {
 	return j.Subject, nil
 }
 
-func GenerateToken(id int64, name string) (string, error) {
+// GenerateAccessToken 使用当前密钥签发access token,返回token及过期时间
+func GenerateAccessToken(userID uint64, name, sessionID string, roles []string, mfa bool) (string, time.Time, error) {
+	keySet := jwtKeySet.Load()
+	if keySet == nil {
+		return "", time.Time{}, ErrJwtConfigInvalid
+	}
+	now := time.Now()
+	expiresAt := now.Add(keySet.accessTTL)
+	tokenID, err := uuid.NewV7()
+	if err != nil {
+		return "", time.Time{}, err
+	}
 	jwtClaims := JwtCustomClaims{
-		ID:   id,
-		Name: name,
+		UserID:    userID,
+		Name:      name,
+		SessionID: sessionID,
+		Roles:     roles,
+		MFA:       mfa,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24 * 7)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "ppt",
-			Subject:   "Token",
+			ID:        tokenID.String(),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    keySet.issuer,
+			Subject:   tokenSubjectAccess,
 		},
 	}
+	if keySet.audience != "" {
+		jwtClaims.Audience = jwt.ClaimStrings{keySet.audience}
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwtClaims)
-	return token.SignedString([]byte(secret))
+	token.Header[tokenHeaderKid] = keySet.activeKid
+	signed, err := token.SignedString(keySet.keys[keySet.activeKid])
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
 }
 
+// ParseToken 按token头部kid选择密钥校验签名、有效期、签发方及受众
 func ParseToken(tokenString string) (*JwtCustomClaims, error) {
+	keySet := jwtKeySet.Load()
+	if keySet == nil {
+		return nil, ErrJwtConfigInvalid
+	}
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(keySet.issuer),
+		jwt.WithLeeway(tokenLeeway),
+	}
+	if keySet.audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(keySet.audience))
+	}
 	jwtClaims := &JwtCustomClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, jwtClaims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
-	if err != nil && !token.Valid {
-		err = errors.New("invalid token")
+	_, err := jwt.ParseWithClaims(tokenString, jwtClaims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header[tokenHeaderKid].(string)
+		key, ok := keySet.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return key, nil
+	}, parserOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
 	}
-	return jwtClaims, err
+	if jwtClaims.Subject != tokenSubjectAccess || jwtClaims.UserID == 0 || jwtClaims.SessionID == "" || jwtClaims.RegisteredClaims.ID == "" {
+		return nil, fmt.Errorf("%w: malformed claims", ErrTokenInvalid)
+	}
+	return jwtClaims, nil
+}
+
+// GenerateRefreshToken 生成随机refresh token,服务端仅保存其哈希
+func GenerateRefreshToken() (string, error) {
+	return GenerateRandomToken(refreshTokenRandomLength)
+}
+
+// GenerateRandomToken 生成length字节随机数的base64url字符串
+func GenerateRandomToken(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashRefreshToken refresh token存储哈希
+func HashRefreshToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
@@ -1,11 +1,16 @@
 package util
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 	"go.uber.org/zap"
+	"ppt/config"
 	"ppt/log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,6 +30,71 @@ func ValidateTOTP(code, secret string) bool {
 	return totp.Validate(code, secret)
 }
 
+const (
+	totpPeriod = 30 // 秒
+	totpSkew   = 1  // 允许前后各1个时间步
+)
+
+var totpConfig atomic.Pointer[config.TotpConfig]
+
+// InitTotpConfig 加载两步验证配置
+func InitTotpConfig(cfg *config.TotpConfig) error {
+	if cfg.Issuer == "" {
+		cfg.Issuer = "ppt"
+	}
+	if _, err := GcmEncrypt(nil, cfg.EncryptKey); err != nil {
+		return fmt.Errorf("totp encrypt_key invalid: %w", err)
+	}
+	totpConfig.Store(cfg)
+	return nil
+}
+
+// GetTotpIssuer 验证器App中显示的签发方
+func GetTotpIssuer() string {
+	if cfg := totpConfig.Load(); cfg != nil {
+		return cfg.Issuer
+	}
+	return "ppt"
+}
+
+// EncryptTotpSecret 加密TOTP密钥用于存储
+func EncryptTotpSecret(secret string) (string, error) {
+	cfg := totpConfig.Load()
+	if cfg == nil {
+		return "", errors.New("totp config not initialized")
+	}
+	return GcmEncrypt([]byte(secret), cfg.EncryptKey)
+}
+
+// DecryptTotpSecret 解密存储的TOTP密钥
+func DecryptTotpSecret(secretEnc string) (string, error) {
+	cfg := totpConfig.Load()
+	if cfg == nil {
+		return "", errors.New("totp config not initialized")
+	}
+	secret, err := GcmDecrypt(secretEnc, cfg.EncryptKey)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// ValidateTOTPStep 校验TOTP并返回匹配的时间步,调用方记录已使用的时间步以防止验证码重放
+func ValidateTOTPStep(code, secret string, now time.Time) (int64, bool) {
+	step := now.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		t := time.Unix((step+i)*totpPeriod, 0)
+		expected, err := totp.GenerateCodeCustom(secret, t, totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step + i, true
+		}
+	}
+	return 0, false
+}
+
 type RotatingTOTP struct {
 	issuer         string
 	account        string